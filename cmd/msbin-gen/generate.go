@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// builtinTypes maps XML schema and WCF serialization types to Go types. Types without an exact
+// Go equivalent, like decimal and dateTime, are kept as strings so no precision or offset is lost.
+var builtinTypes = map[xml.Name]string{
+	{Space: xsdNamespace, Local: "boolean"}:                "bool",
+	{Space: xsdNamespace, Local: "byte"}:                   "int8",
+	{Space: xsdNamespace, Local: "short"}:                  "int16",
+	{Space: xsdNamespace, Local: "int"}:                    "int32",
+	{Space: xsdNamespace, Local: "long"}:                   "int64",
+	{Space: xsdNamespace, Local: "unsignedByte"}:           "uint8",
+	{Space: xsdNamespace, Local: "unsignedShort"}:          "uint16",
+	{Space: xsdNamespace, Local: "unsignedInt"}:            "uint32",
+	{Space: xsdNamespace, Local: "unsignedLong"}:           "uint64",
+	{Space: xsdNamespace, Local: "float"}:                  "float32",
+	{Space: xsdNamespace, Local: "double"}:                 "float64",
+	{Space: xsdNamespace, Local: "base64Binary"}:           "[]byte",
+	{Space: serializationNamespace, Local: "char"}:         "int32",
+	{Space: serializationNamespace, Local: "guid"}:         "string",
+	{Space: serializationNamespace, Local: "duration"}:     "string",
+	{Space: serializationNamespace, Local: "anyURI"}:       "string",
+	{Space: serializationNamespace, Local: "anyType"}:      "string",
+	{Space: serializationNamespace, Local: "base64Binary"}: "[]byte",
+	{Space: serializationNamespace, Local: "boolean"}:      "bool",
+	{Space: serializationNamespace, Local: "int"}:          "int32",
+	{Space: serializationNamespace, Local: "long"}:         "int64",
+	{Space: serializationNamespace, Local: "double"}:       "float64",
+	{Space: serializationNamespace, Local: "string"}:       "string",
+}
+
+// generator writes Go declarations for the types and port types of a WSDL
+type generator struct {
+	defs     *definitions
+	decls    []*bytes.Buffer
+	names    map[string]bool
+	types    map[xml.Name]string
+	elements map[xml.Name]string
+	structs  map[string]bool
+}
+
+// generate returns the Go source of a client package for the WSDL at wsdlPath
+func generate(wsdlPath string, schemaPaths []string, pkg string) ([]byte, error) {
+	defs, err := loadDefinitions(wsdlPath, schemaPaths)
+	if err != nil {
+		return nil, err
+	}
+	g := &generator{defs: defs, names: map[string]bool{}, types: map[xml.Name]string{}, elements: map[xml.Name]string{}, structs: map[string]bool{}}
+	portTypes, err := defs.portTypes()
+	if err != nil {
+		return nil, err
+	}
+	ports, err := defs.ports()
+	if err != nil {
+		return nil, err
+	}
+	clients := &bytes.Buffer{}
+	for _, pt := range portTypes {
+		err = g.writeClient(clients, pt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	src := &bytes.Buffer{}
+	fmt.Fprintf(src, "// Code generated by msbin-gen from %s. DO NOT EDIT.\n\n", filepath.Base(wsdlPath))
+	fmt.Fprintf(src, "// Package %s is a client for the services in %s\n", pkg, filepath.Base(wsdlPath))
+	fmt.Fprintf(src, "package %s\n\n", pkg)
+	src.WriteString("import (\n\t\"context\"\n\t\"encoding/xml\"\n\n\t\"github.com/khoad/msbingo/nbfs/client\"\n)\n\n")
+	if len(ports) > 0 {
+		src.WriteString("const (\n")
+		for _, p := range ports {
+			name := g.reserve(goName(p.service) + goName(p.name) + "Address")
+			fmt.Fprintf(src, "\t// %s is the address of the %s port of %s\n", name, p.name, p.service)
+			fmt.Fprintf(src, "\t%s = %s\n", name, strconv.Quote(p.address))
+		}
+		src.WriteString(")\n\n")
+	}
+	for _, decl := range g.decls {
+		src.Write(decl.Bytes())
+		src.WriteString("\n")
+	}
+	src.Write(clients.Bytes())
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("Generated invalid Go: %s", err.Error())
+	}
+	return formatted, nil
+}
+
+// goName turns an XML name into an exported Go identifier
+func goName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "X" + s
+	}
+	return s
+}
+
+// reserve claims a unique top level Go name based on name
+func (g *generator) reserve(name string) string {
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	g.names[unique] = true
+	return unique
+}
+
+// newDecl adds a top level declaration, keeping the order types are first used in
+func (g *generator) newDecl() *bytes.Buffer {
+	decl := &bytes.Buffer{}
+	g.decls = append(g.decls, decl)
+	return decl
+}
+
+func (g *generator) writeClient(w *bytes.Buffer, pt *portType) error {
+	clientName := g.reserve(goName(pt.name) + "Client")
+	fmt.Fprintf(w, "// %s calls the operations of %s\n", clientName, pt.name)
+	fmt.Fprintf(w, "type %s struct {\n\t*client.Client\n}\n\n", clientName)
+	fmt.Fprintf(w, "// New%s creates a %s sending through c\n", clientName, clientName)
+	fmt.Fprintf(w, "func New%s(c *client.Client) *%s {\n\treturn &%s{c}\n}\n\n", clientName, clientName, clientName)
+	for _, op := range pt.operations {
+		input, err := g.elementType(op.input)
+		if err != nil {
+			return err
+		}
+		method := goName(op.name)
+		if op.oneWay {
+			fmt.Fprintf(w, "// %s sends the one-way %s operation\n", method, op.name)
+			fmt.Fprintf(w, "func (c *%s) %s(ctx context.Context, request *%s) error {\n", clientName, method, input)
+			fmt.Fprintf(w, "\treturn c.Call(ctx, %s, request, nil)\n}\n\n", strconv.Quote(op.inputAction))
+			continue
+		}
+		output, err := g.elementType(op.output)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "// %s calls the %s operation\n", method, op.name)
+		fmt.Fprintf(w, "func (c *%s) %s(ctx context.Context, request *%s) (*%s, error) {\n", clientName, method, input, output)
+		fmt.Fprintf(w, "\tresponse := &%s{}\n", output)
+		fmt.Fprintf(w, "\terr := c.Call(ctx, %s, request, response)\n", strconv.Quote(op.inputAction))
+		fmt.Fprintf(w, "\tif err != nil {\n\t\treturn nil, err\n\t}\n\treturn response, nil\n}\n\n")
+	}
+	return nil
+}
+
+// elementType returns the Go struct for a global element used as a message body
+func (g *generator) elementType(name xml.Name) (string, error) {
+	if goType, ok := g.elements[name]; ok {
+		return goType, nil
+	}
+	d, ok := g.defs.elements[name]
+	if !ok {
+		return "", fmt.Errorf("Element {%s}%s not found, pass its schema with -xsd", name.Space, name.Local)
+	}
+	if typeAttr := d.node.attr("type"); typeAttr != "" {
+		typeName, err := d.node.qname(typeAttr)
+		if err != nil {
+			return "", err
+		}
+		base, err := g.typeRef(typeName)
+		if err != nil {
+			return "", err
+		}
+		goType := goName(name.Local)
+		if g.names[goType] {
+			goType += "Element"
+		}
+		goType = g.reserve(goType)
+		g.elements[name] = goType
+		decl := g.newDecl()
+		fmt.Fprintf(decl, "// %s is the %s element\n", goType, name.Local)
+		fmt.Fprintf(decl, "type %s struct {\n\tXMLName xml.Name `xml:\"%s %s\"`\n\t%s\n}\n", goType, name.Space, name.Local, base)
+		return goType, nil
+	}
+	goType := g.reserve(goName(name.Local))
+	g.elements[name] = goType
+	ct := d.node.child(xsdNamespace, "complexType")
+	if ct == nil {
+		return "", fmt.Errorf("Element %s has no type", name.Local)
+	}
+	return goType, g.writeStruct(g.newDecl(), goType, "the "+name.Local+" element", ct, d.schema, &name)
+}
+
+// typeRef returns the Go type for a named schema type, generating it when needed
+func (g *generator) typeRef(name xml.Name) (string, error) {
+	if goType, ok := builtinTypes[name]; ok {
+		return goType, nil
+	}
+	if name.Space == xsdNamespace {
+		return "string", nil
+	}
+	if goType, ok := g.types[name]; ok {
+		return goType, nil
+	}
+	if d, ok := g.defs.complexTypes[name]; ok {
+		goType := g.reserve(goName(name.Local))
+		g.types[name] = goType
+		return goType, g.writeStruct(g.newDecl(), goType, "the "+name.Local+" type", d.node, d.schema, nil)
+	}
+	if d, ok := g.defs.simpleTypes[name]; ok {
+		return g.simpleType(name, d)
+	}
+	return "", fmt.Errorf("Type {%s}%s not found, pass its schema with -xsd", name.Space, name.Local)
+}
+
+func (g *generator) simpleType(name xml.Name, d *decl) (string, error) {
+	restriction := d.node.child(xsdNamespace, "restriction")
+	if restriction == nil {
+		// lists and unions are kept as their text
+		g.types[name] = "string"
+		return "string", nil
+	}
+	base, err := restriction.qname(restriction.attr("base"))
+	if err != nil {
+		return "", err
+	}
+	enumerations := restriction.all(xsdNamespace, "enumeration")
+	if len(enumerations) == 0 {
+		goType, err := g.typeRef(base)
+		g.types[name] = goType
+		return goType, err
+	}
+	goType := g.reserve(goName(name.Local))
+	g.types[name] = goType
+	decl := g.newDecl()
+	fmt.Fprintf(decl, "// %s is the %s enumeration\n", goType, name.Local)
+	fmt.Fprintf(decl, "type %s string\n\n", goType)
+	fmt.Fprintf(decl, "// %s values\n", goType)
+	decl.WriteString("const (\n")
+	for _, e := range enumerations {
+		value := e.attr("value")
+		fmt.Fprintf(decl, "\t%s %s = %s\n", g.reserve(goType+goName(value)), goType, strconv.Quote(value))
+	}
+	decl.WriteString(")\n")
+	return goType, nil
+}
+
+// structField is a field of a generated struct
+type structField struct {
+	name   string
+	goType string
+	tag    string
+}
+
+func (g *generator) writeStruct(w *bytes.Buffer, goType, description string, ct *node, s *schema, element *xml.Name) error {
+	g.structs[goType] = true
+	var fields []structField
+	used := map[string]bool{"XMLName": true}
+	if element != nil {
+		fields = append(fields, structField{"XMLName", "xml.Name", element.Space + " " + element.Local})
+	}
+	err := g.collectFields(&fields, used, goType, ct, s)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "// %s is %s\n", goType, description)
+	fmt.Fprintf(w, "type %s struct {\n", goType)
+	for _, f := range fields {
+		if f.name == "" {
+			fmt.Fprintf(w, "\t%s\n", f.goType)
+		} else {
+			fmt.Fprintf(w, "\t%s %s `xml:\"%s\"`\n", f.name, f.goType, f.tag)
+		}
+	}
+	w.WriteString("}\n")
+	return nil
+}
+
+func uniqueField(used map[string]bool, name string) string {
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	used[unique] = true
+	return unique
+}
+
+// collectFields adds the fields of a complex type's content model and attributes
+func (g *generator) collectFields(fields *[]structField, used map[string]bool, owner string, n *node, s *schema) error {
+	for _, child := range n.children {
+		if child.name.Space != xsdNamespace {
+			continue
+		}
+		switch child.name.Local {
+		case "sequence", "all", "choice":
+			err := g.collectFields(fields, used, owner, child, s)
+			if err != nil {
+				return err
+			}
+		case "complexContent", "simpleContent":
+			extension := child.child(xsdNamespace, "extension")
+			if extension == nil {
+				extension = child.child(xsdNamespace, "restriction")
+			}
+			if extension == nil {
+				continue
+			}
+			base, err := extension.qname(extension.attr("base"))
+			if err != nil {
+				return err
+			}
+			baseType, err := g.typeRef(base)
+			if err != nil {
+				return err
+			}
+			if child.name.Local == "simpleContent" {
+				*fields = append(*fields, structField{uniqueField(used, "Value"), baseType, ",chardata"})
+			} else if extension.name.Local == "extension" {
+				// embedded so the base type's elements are promoted in order
+				*fields = append(*fields, structField{"", baseType, ""})
+			}
+			err = g.collectFields(fields, used, owner, extension, s)
+			if err != nil {
+				return err
+			}
+		case "element":
+			err := g.addElementField(fields, used, owner, child, s)
+			if err != nil {
+				return err
+			}
+		case "attribute":
+			name := child.attr("name")
+			if name == "" {
+				continue
+			}
+			goType := "string"
+			if typeAttr := child.attr("type"); typeAttr != "" {
+				typeName, err := child.qname(typeAttr)
+				if err != nil {
+					return err
+				}
+				goType, err = g.typeRef(typeName)
+				if err != nil {
+					return err
+				}
+			}
+			*fields = append(*fields, structField{uniqueField(used, goName(name)), goType, name + ",attr,omitempty"})
+		}
+	}
+	return nil
+}
+
+func (g *generator) addElementField(fields *[]structField, used map[string]bool, owner string, el *node, s *schema) error {
+	name := el.attr("name")
+	space := ""
+	if s.qualified {
+		space = s.targetNamespace
+	}
+	typeNode := el
+	if ref := el.attr("ref"); ref != "" {
+		refName, err := el.qname(ref)
+		if err != nil {
+			return err
+		}
+		d, ok := g.defs.elements[refName]
+		if !ok {
+			return fmt.Errorf("Element {%s}%s not found, pass its schema with -xsd", refName.Space, refName.Local)
+		}
+		name, space, typeNode, s = refName.Local, refName.Space, d.node, d.schema
+	}
+	fieldName := uniqueField(used, goName(name))
+	var goType string
+	if typeAttr := typeNode.attr("type"); typeAttr != "" {
+		typeName, err := typeNode.qname(typeAttr)
+		if err != nil {
+			return err
+		}
+		goType, err = g.typeRef(typeName)
+		if err != nil {
+			return err
+		}
+	} else if ct := typeNode.child(xsdNamespace, "complexType"); ct != nil {
+		goType = g.reserve(owner + fieldName)
+		err := g.writeStruct(g.newDecl(), goType, "the "+name+" element of "+owner, ct, s, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		goType = "string"
+	}
+
+	optional := el.attr("minOccurs") == "0"
+	repeated := el.attr("maxOccurs") != "" && el.attr("maxOccurs") != "0" && el.attr("maxOccurs") != "1"
+	tag := name
+	if space != "" {
+		tag = space + " " + name
+	}
+	switch {
+	case repeated && goType != "[]byte":
+		goType = "[]" + goType
+		tag += ",omitempty"
+	case optional && g.structs[goType]:
+		goType = "*" + goType
+		tag += ",omitempty"
+	}
+	*fields = append(*fields, structField{fieldName, goType, tag})
+	return nil
+}
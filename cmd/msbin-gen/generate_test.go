@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readGolden(t *testing.T) string {
+	golden, err := os.ReadFile("testdata/calculator.go.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(golden)
+}
+
+// copyWSDL copies the calculator WSDL into a temporary directory, without its schema,
+// with the local schema import replaced by location
+func copyWSDL(t *testing.T, location string) string {
+	wsdl, err := os.ReadFile("testdata/calculator.wsdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "calculator.wsdl")
+	wsdl = []byte(strings.Replace(string(wsdl), `schemaLocation="calculator0.xsd"`, `schemaLocation="`+location+`"`, 1))
+	err = os.WriteFile(path, wsdl, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("testdata/calculator.wsdl", nil, "calculator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != readGolden(t) {
+		t.Errorf("Generated code differs from testdata/calculator.go.golden:\n%s", src)
+	}
+}
+
+func TestGenerateExtraSchema(t *testing.T) {
+	path := copyWSDL(t, "http://localhost/Calculator.svc?xsd=xsd0")
+	src, err := generate(path, []string{"testdata/calculator0.xsd"}, "calculator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != readGolden(t) {
+		t.Errorf("Generated code differs from testdata/calculator.go.golden:\n%s", src)
+	}
+}
+
+func TestGenerateMissingSchema(t *testing.T) {
+	path := copyWSDL(t, "http://localhost/Calculator.svc?xsd=xsd0")
+	_, err := generate(path, nil, "calculator")
+	if err == nil || !strings.Contains(err.Error(), "-xsd") {
+		t.Errorf("Expected an error asking for the schema, got %v", err)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Add":                    "Add",
+		"addResult":              "AddResult",
+		"BinaryHttp_ICalculator": "BinaryHttpICalculator",
+		"ArrayOfdouble":          "ArrayOfdouble",
+		"2d-point":               "X2dPoint",
+	} {
+		if actual := goName(name); actual != expected {
+			t.Errorf("goName(%s): expected %s, got %s", name, expected, actual)
+		}
+	}
+}
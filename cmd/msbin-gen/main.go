@@ -0,0 +1,57 @@
+// Command msbin-gen generates a Go client for a WCF service from its WSDL.
+//
+// The generated package has a struct for each schema type and message element, and a client
+// per port type whose methods send msbin1 requests through a github.com/khoad/msbingo/nbfs/client.Client:
+//
+//	msbin-gen -wsdl service.wsdl -xsd service0.xsd -package service -o service.go
+//
+// Only document/literal services are supported. Schemas the WSDL imports by URL, as WCF's
+// ?xsd=xsd0 imports are, must be downloaded and passed with -xsd.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stringList is a flag that may be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func main() {
+	var schemas stringList
+	wsdlPath := flag.String("wsdl", "", "WSDL file to generate from")
+	pkg := flag.String("package", "client", "package name of the generated code")
+	out := flag.String("o", "", "output file, standard output when empty")
+	flag.Var(&schemas, "xsd", "additional schema file, may be repeated")
+	flag.Parse()
+	if *wsdlPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	src, err := generate(*wsdlPath, schemas, *pkg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "msbin-gen:", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*out, src, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "msbin-gen:", err)
+		os.Exit(1)
+	}
+}
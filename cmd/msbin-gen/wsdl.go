@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	wsdlNamespace          = "http://schemas.xmlsoap.org/wsdl/"
+	xsdNamespace           = "http://www.w3.org/2001/XMLSchema"
+	soap11BindingNamespace = "http://schemas.xmlsoap.org/wsdl/soap/"
+	soap12BindingNamespace = "http://schemas.xmlsoap.org/wsdl/soap12/"
+	wsawNamespace          = "http://www.w3.org/2006/05/addressing/wsdl"
+	wsamNamespace          = "http://www.w3.org/2007/05/addressing/metadata"
+	serializationNamespace = "http://schemas.microsoft.com/2003/10/Serialization/"
+)
+
+// node is an element of a WSDL or XSD document, keeping the namespace declarations
+// in scope so QName attribute values like type="tns:Foo" can be resolved
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	ns       map[string]string
+	children []*node
+}
+
+func readNode(r io.Reader) (*node, error) {
+	decoder := xml.NewDecoder(r)
+	var stack []*node
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("No root element")
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name, attrs: t.Attr, ns: map[string]string{}}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+				for prefix, uri := range parent.ns {
+					n.ns[prefix] = uri
+				}
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					n.ns[attr.Name.Local] = attr.Value
+				} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					n.ns[""] = attr.Value
+				}
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return n, nil
+			}
+		}
+	}
+}
+
+func readNodeFile(path string) (*node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	n, err := readNode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return n, nil
+}
+
+// attr returns the value of the unqualified attribute local
+func (n *node) attr(local string) string {
+	for _, attr := range n.attrs {
+		if attr.Name.Space == "" && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// attrNS returns the value of the attribute local in namespace space
+func (n *node) attrNS(space, local string) string {
+	for _, attr := range n.attrs {
+		if attr.Name.Space == space && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// qname resolves a QName attribute value against the declarations in scope
+func (n *node) qname(value string) (xml.Name, error) {
+	prefix, local := "", value
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		prefix, local = value[:i], value[i+1:]
+	}
+	uri, ok := n.ns[prefix]
+	if !ok && prefix != "" {
+		return xml.Name{}, fmt.Errorf("Undeclared prefix %s in %s", prefix, value)
+	}
+	return xml.Name{Space: uri, Local: local}, nil
+}
+
+func (n *node) all(space, local string) []*node {
+	var found []*node
+	for _, child := range n.children {
+		if child.name.Space == space && child.name.Local == local {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+func (n *node) child(space, local string) *node {
+	for _, child := range n.children {
+		if child.name.Space == space && child.name.Local == local {
+			return child
+		}
+	}
+	return nil
+}
+
+// schema is an xs:schema along with the settings its declarations inherit
+type schema struct {
+	targetNamespace string
+	qualified       bool
+	root            *node
+}
+
+// definitions is everything read from a WSDL and the schemas it uses
+type definitions struct {
+	targetNamespace string
+	root            *node
+	schemas         []*schema
+
+	elements     map[xml.Name]*decl
+	complexTypes map[xml.Name]*decl
+	simpleTypes  map[xml.Name]*decl
+}
+
+// decl is a global schema declaration
+type decl struct {
+	schema *schema
+	node   *node
+}
+
+// loadDefinitions reads the WSDL at path, the schemas it contains, and any schemas it
+// imports by a relative schemaLocation, plus the extra schema files
+func loadDefinitions(path string, extraSchemas []string) (*definitions, error) {
+	root, err := readNodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if root.name.Space != wsdlNamespace || root.name.Local != "definitions" {
+		return nil, fmt.Errorf("%s is not a WSDL 1.1 document", path)
+	}
+	defs := &definitions{
+		targetNamespace: root.attr("targetNamespace"),
+		root:            root,
+		elements:        map[xml.Name]*decl{},
+		complexTypes:    map[xml.Name]*decl{},
+		simpleTypes:     map[xml.Name]*decl{},
+	}
+	loaded := map[string]bool{}
+	for _, types := range root.all(wsdlNamespace, "types") {
+		for _, s := range types.all(xsdNamespace, "schema") {
+			err = defs.addSchema(s, filepath.Dir(path), loaded)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, extra := range extraSchemas {
+		err = defs.addSchemaFile(extra, loaded)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return defs, nil
+}
+
+func (defs *definitions) addSchemaFile(path string, loaded map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if loaded[abs] {
+		return nil
+	}
+	loaded[abs] = true
+	root, err := readNodeFile(path)
+	if err != nil {
+		return err
+	}
+	if root.name.Space != xsdNamespace || root.name.Local != "schema" {
+		return fmt.Errorf("%s is not an XML schema", path)
+	}
+	return defs.addSchema(root, filepath.Dir(path), loaded)
+}
+
+func (defs *definitions) addSchema(root *node, dir string, loaded map[string]bool) error {
+	s := &schema{
+		targetNamespace: root.attr("targetNamespace"),
+		qualified:       root.attr("elementFormDefault") == "qualified",
+		root:            root,
+	}
+	defs.schemas = append(defs.schemas, s)
+	for _, child := range root.children {
+		if child.name.Space != xsdNamespace {
+			continue
+		}
+		name := xml.Name{Space: s.targetNamespace, Local: child.attr("name")}
+		switch child.name.Local {
+		case "element":
+			defs.elements[name] = &decl{s, child}
+		case "complexType":
+			defs.complexTypes[name] = &decl{s, child}
+		case "simpleType":
+			defs.simpleTypes[name] = &decl{s, child}
+		case "import", "include":
+			location := child.attr("schemaLocation")
+			if location == "" {
+				continue
+			}
+			if strings.Contains(location, "://") || strings.HasPrefix(location, "?") {
+				// remote schemas are expected to be passed with -xsd
+				continue
+			}
+			err := defs.addSchemaFile(filepath.Join(dir, location), loaded)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// operation is a WSDL portType operation with the actions and body elements of its messages
+type operation struct {
+	name         string
+	inputAction  string
+	input        xml.Name
+	outputAction string
+	output       xml.Name
+	oneWay       bool
+}
+
+type portType struct {
+	name       string
+	operations []*operation
+}
+
+type port struct {
+	service  string
+	name     string
+	portType string
+	address  string
+}
+
+func (defs *definitions) messagePart(message xml.Name) (xml.Name, error) {
+	for _, m := range defs.root.all(wsdlNamespace, "message") {
+		if m.attr("name") != message.Local || defs.targetNamespace != message.Space {
+			continue
+		}
+		parts := m.all(wsdlNamespace, "part")
+		if len(parts) != 1 || parts[0].attr("element") == "" {
+			return xml.Name{}, fmt.Errorf("Message %s must have a single element part, as document/literal wrapped services do", message.Local)
+		}
+		return parts[0].qname(parts[0].attr("element"))
+	}
+	return xml.Name{}, fmt.Errorf("Message %s not found", message.Local)
+}
+
+// action reads the wsaw or wsam Action of an operation's input or output
+func action(n *node) string {
+	if a := n.attrNS(wsawNamespace, "Action"); a != "" {
+		return a
+	}
+	return n.attrNS(wsamNamespace, "Action")
+}
+
+// soapActions reads the soapAction of each operation from the bindings, keyed by portType/operation
+func (defs *definitions) soapActions() map[string]string {
+	actions := map[string]string{}
+	for _, binding := range defs.root.all(wsdlNamespace, "binding") {
+		typeName, err := binding.qname(binding.attr("type"))
+		if err != nil {
+			continue
+		}
+		for _, op := range binding.all(wsdlNamespace, "operation") {
+			for _, ns := range []string{soap12BindingNamespace, soap11BindingNamespace} {
+				if soapOp := op.child(ns, "operation"); soapOp != nil && soapOp.attr("soapAction") != "" {
+					actions[typeName.Local+"/"+op.attr("name")] = soapOp.attr("soapAction")
+				}
+			}
+		}
+	}
+	return actions
+}
+
+func (defs *definitions) portTypes() ([]*portType, error) {
+	var portTypes []*portType
+	soapActions := defs.soapActions()
+	for _, pt := range defs.root.all(wsdlNamespace, "portType") {
+		p := &portType{name: pt.attr("name")}
+		for _, op := range pt.all(wsdlNamespace, "operation") {
+			o := &operation{name: op.attr("name")}
+			input := op.child(wsdlNamespace, "input")
+			if input == nil {
+				return nil, fmt.Errorf("Operation %s has no input, notifications are not supported", o.name)
+			}
+			message, err := input.qname(input.attr("message"))
+			if err != nil {
+				return nil, err
+			}
+			o.input, err = defs.messagePart(message)
+			if err != nil {
+				return nil, err
+			}
+			o.inputAction = action(input)
+			if o.inputAction == "" {
+				o.inputAction = soapActions[p.name+"/"+o.name]
+			}
+			if o.inputAction == "" {
+				o.inputAction = defs.defaultAction(p.name, o.name)
+			}
+			output := op.child(wsdlNamespace, "output")
+			if output == nil {
+				o.oneWay = true
+			} else {
+				message, err = output.qname(output.attr("message"))
+				if err != nil {
+					return nil, err
+				}
+				o.output, err = defs.messagePart(message)
+				if err != nil {
+					return nil, err
+				}
+				o.outputAction = action(output)
+				if o.outputAction == "" {
+					o.outputAction = defs.defaultAction(p.name, o.name+"Response")
+				}
+			}
+			p.operations = append(p.operations, o)
+		}
+		portTypes = append(portTypes, p)
+	}
+	return portTypes, nil
+}
+
+func (defs *definitions) defaultAction(portType, operation string) string {
+	ns := defs.targetNamespace
+	if !strings.HasSuffix(ns, "/") {
+		ns += "/"
+	}
+	return ns + portType + "/" + operation
+}
+
+func (defs *definitions) ports() ([]*port, error) {
+	var ports []*port
+	for _, service := range defs.root.all(wsdlNamespace, "service") {
+		for _, p := range service.all(wsdlNamespace, "port") {
+			bindingName, err := p.qname(p.attr("binding"))
+			if err != nil {
+				return nil, err
+			}
+			pt := ""
+			for _, binding := range defs.root.all(wsdlNamespace, "binding") {
+				if binding.attr("name") == bindingName.Local {
+					typeName, err := binding.qname(binding.attr("type"))
+					if err != nil {
+						return nil, err
+					}
+					pt = typeName.Local
+				}
+			}
+			address := ""
+			for _, ns := range []string{soap12BindingNamespace, soap11BindingNamespace} {
+				if a := p.child(ns, "address"); a != nil {
+					address = a.attr("location")
+				}
+			}
+			ports = append(ports, &port{service: service.attr("name"), name: p.attr("name"), portType: pt, address: address})
+		}
+	}
+	return ports, nil
+}
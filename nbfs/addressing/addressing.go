@@ -0,0 +1,158 @@
+// Package addressing provides WS-Addressing 1.0 and 2004/08 headers for SOAP messages sent as msbin1
+package addressing
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
+	"github.com/satori/go.uuid"
+)
+
+// Version is the WS-Addressing version of a set of headers
+type Version int
+
+const (
+	// Addressing10 is WS-Addressing 1.0, used with SOAP 1.2 by WCF by default
+	Addressing10 Version = iota
+	// Addressing200408 is the WS-Addressing August 2004 submission
+	Addressing200408
+)
+
+// Namespace returns the namespace of the version
+func (v Version) Namespace() string {
+	if v == Addressing200408 {
+		return "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+	}
+	return "http://www.w3.org/2005/08/addressing"
+}
+
+// Anonymous returns the address meaning "reply on the same connection"
+func (v Version) Anonymous() string {
+	if v == Addressing200408 {
+		return "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+	}
+	return "http://www.w3.org/2005/08/addressing/anonymous"
+}
+
+func (v Version) name(local string) xml.Name {
+	return xml.Name{Space: v.Namespace(), Local: local}
+}
+
+// Headers are the WS-Addressing message headers
+type Headers struct {
+	Version   Version
+	Action    string
+	To        string
+	MessageID string
+	RelatesTo string
+	// ReplyTo is the address of the ReplyTo endpoint reference
+	ReplyTo string
+}
+
+// NewRequest creates request headers with a new urn:uuid: MessageID, replying to the anonymous address
+func NewRequest(version Version, action, to string) Headers {
+	return Headers{
+		Version:   version,
+		Action:    action,
+		To:        to,
+		MessageID: NewMessageID(),
+		ReplyTo:   version.Anonymous(),
+	}
+}
+
+// NewMessageID returns a new urn:uuid: message id
+func NewMessageID() string {
+	return "urn:uuid:" + uuid.NewV4().String()
+}
+
+// NewReply creates the headers of a reply to request, relating it to the request's MessageID
+func NewReply(request Headers, action string) Headers {
+	return Headers{
+		Version:   request.Version,
+		Action:    action,
+		To:        request.ReplyTo,
+		RelatesTo: request.MessageID,
+	}
+}
+
+// Apply adds the headers to envelope, marking Action and To as mustUnderstand like WCF does
+func (h Headers) Apply(envelope *soap.Envelope) {
+	mustUnderstand := []xml.Attr{{Name: xml.Name{Space: envelope.Version.Namespace(), Local: "mustUnderstand"}, Value: "1"}}
+	if h.Action != "" {
+		envelope.AddHeader(&soap.Element{Name: h.Version.name("Action"), Attr: mustUnderstand, Text: h.Action})
+	}
+	if h.MessageID != "" {
+		envelope.AddHeader(&soap.Element{Name: h.Version.name("MessageID"), Text: h.MessageID})
+	}
+	if h.RelatesTo != "" {
+		envelope.AddHeader(&soap.Element{Name: h.Version.name("RelatesTo"), Text: h.RelatesTo})
+	}
+	if h.ReplyTo != "" {
+		address := &soap.Element{Name: h.Version.name("Address"), Text: h.ReplyTo}
+		envelope.AddHeader(&soap.Element{Name: h.Version.name("ReplyTo"), Children: []*soap.Element{address}})
+	}
+	if h.To != "" {
+		envelope.AddHeader(&soap.Element{Name: h.Version.name("To"), Attr: mustUnderstand, Text: h.To})
+	}
+}
+
+// Read returns the addressing headers of envelope, and false if it has none of either version
+func Read(envelope *soap.Envelope) (Headers, bool) {
+	for _, version := range []Version{Addressing10, Addressing200408} {
+		h := Headers{Version: version}
+		found := false
+		text := func(local string) string {
+			entry := envelope.Header.Get(version.name(local))
+			if entry == nil {
+				return ""
+			}
+			found = true
+			return strings.TrimSpace(entry.Text)
+		}
+		h.Action = text("Action")
+		h.To = text("To")
+		h.MessageID = text("MessageID")
+		h.RelatesTo = text("RelatesTo")
+		if replyTo := envelope.Header.Get(version.name("ReplyTo")); replyTo != nil {
+			found = true
+			if address := replyTo.Child(version.name("Address")); address != nil {
+				h.ReplyTo = strings.TrimSpace(address.Text)
+			}
+		}
+		if found {
+			return h, true
+		}
+	}
+	return Headers{}, false
+}
+
+// TypeHint encodes MessageID and RelatesTo headers of either version as UniqueIdText, like WCF does
+// for the ids it generates, and leaves everything else to the encoder. The ids must then be urn:uuid: values.
+func TypeHint(path []xml.Name, attr xml.Name) nbfx.TextKind {
+	if attr.Local != "" || len(path) != 3 || path[1].Local != "Header" {
+		return nbfx.TextAuto
+	}
+	switch path[2] {
+	case Addressing10.name("MessageID"), Addressing10.name("RelatesTo"),
+		Addressing200408.name("MessageID"), Addressing200408.name("RelatesTo"):
+		return nbfx.TextUniqueId
+	}
+	return nbfx.TextAuto
+}
+
+// EncoderOptions adds TypeHint to opts, consulting any TypeHint already set for everything else
+func EncoderOptions(opts nbfx.EncoderOptions) nbfx.EncoderOptions {
+	next := opts.TypeHint
+	opts.TypeHint = func(path []xml.Name, attr xml.Name) nbfx.TextKind {
+		if kind := TypeHint(path, attr); kind != nbfx.TextAuto {
+			return kind
+		}
+		if next != nil {
+			return next(path, attr)
+		}
+		return nbfx.TextAuto
+	}
+	return opts
+}
@@ -0,0 +1,76 @@
+package addressing
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/khoad/msbingo/nbfs"
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
+)
+
+func TestRequestRoundTrip(t *testing.T) {
+	request := NewRequest(Addressing10, "http://tempuri.org/IService/GetData", "http://localhost/Service.svc")
+	envelope := soap.NewEnvelope(soap.Soap12)
+	request.Apply(envelope)
+
+	buf := &bytes.Buffer{}
+	encoder := nbfs.NewEncoderWithOptions(EncoderOptions(nbfx.EncoderOptions{Strategy: nbfx.WCFCompatible}))
+	err := envelope.Write(buf, encoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(request.MessageID[len("urn:uuid:"):])) {
+		t.Error("Expected MessageID to be encoded as UniqueIdText, not characters")
+	}
+
+	read, err := soap.ReadEnvelope(buf, nbfs.NewDecoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, ok := Read(read)
+	if !ok {
+		t.Fatal("Expected addressing headers")
+	}
+	if headers != request {
+		t.Errorf("%v not equal to expected %v", headers, request)
+	}
+}
+
+func TestReadAddressing200408(t *testing.T) {
+	envelope := soap.NewEnvelope(soap.Soap11)
+	NewRequest(Addressing200408, "action", "http://localhost/").Apply(envelope)
+	headers, ok := Read(envelope)
+	if !ok {
+		t.Fatal("Expected addressing headers")
+	}
+	if headers.Version != Addressing200408 || headers.ReplyTo != Addressing200408.Anonymous() {
+		t.Errorf("Unexpected headers %v", headers)
+	}
+}
+
+func TestCorrelator(t *testing.T) {
+	correlator := NewCorrelator()
+	request := NewRequest(Addressing10, "action", "http://localhost/")
+	responses := correlator.Register(request.MessageID)
+
+	response := soap.NewEnvelope(soap.Soap12)
+	NewReply(request, "actionResponse").Apply(response)
+	err := correlator.Deliver(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if <-responses != response {
+		t.Error("Expected the delivered response")
+	}
+	if correlator.Deliver(response) == nil {
+		t.Error("Expected error delivering a response twice")
+	}
+}
+
+func TestCorrelatorWithoutRelatesTo(t *testing.T) {
+	correlator := NewCorrelator()
+	if correlator.Deliver(soap.NewEnvelope(soap.Soap12)) == nil {
+		t.Error("Expected error delivering a response without RelatesTo")
+	}
+}
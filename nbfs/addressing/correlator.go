@@ -0,0 +1,54 @@
+package addressing
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+// Correlator matches responses to outstanding requests by their RelatesTo header
+type Correlator struct {
+	mu      sync.Mutex
+	pending map[string]chan *soap.Envelope
+}
+
+// NewCorrelator creates an empty Correlator
+func NewCorrelator() *Correlator {
+	return &Correlator{pending: map[string]chan *soap.Envelope{}}
+}
+
+// Register starts waiting for the response to the request with messageID.
+// The returned channel receives the response once, when it is delivered.
+func (c *Correlator) Register(messageID string) <-chan *soap.Envelope {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan *soap.Envelope, 1)
+	c.pending[messageID] = ch
+	return ch
+}
+
+// Cancel stops waiting for the response to messageID
+func (c *Correlator) Cancel(messageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, messageID)
+}
+
+// Deliver hands response to the request it relates to
+func (c *Correlator) Deliver(response *soap.Envelope) error {
+	headers, ok := Read(response)
+	if !ok || headers.RelatesTo == "" {
+		return errors.New("Response has no RelatesTo header")
+	}
+	c.mu.Lock()
+	ch, ok := c.pending[headers.RelatesTo]
+	delete(c.pending, headers.RelatesTo)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("No request is waiting for a response to %s", headers.RelatesTo)
+	}
+	ch <- response
+	return nil
+}
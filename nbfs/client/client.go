@@ -0,0 +1,80 @@
+// Package client sends SOAP requests to WCF services as msbin1 and decodes their responses
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/khoad/msbingo/nbfs"
+	"github.com/khoad/msbingo/nbfs/addressing"
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
+)
+
+// Transport carries msbin1 encoded messages to a service
+type Transport interface {
+	// RoundTrip sends an encoded request and returns the encoded response,
+	// which is empty for one-way operations
+	RoundTrip(ctx context.Context, request []byte) ([]byte, error)
+}
+
+// Client calls operations on a WCF service, by default with SOAP 1.2 and WS-Addressing 1.0
+// like WCF's binary message encoding
+type Client struct {
+	Transport Transport
+	// To is the address written in the To header of each request
+	To                string
+	SoapVersion       soap.Version
+	AddressingVersion addressing.Version
+	// EncoderOptions control how request text is encoded. MessageIDs are always encoded as UniqueIdText.
+	EncoderOptions nbfx.EncoderOptions
+}
+
+// New creates a Client sending msbin1 over HTTP to url
+func New(url string) *Client {
+	return &Client{Transport: &HTTPTransport{URL: url}, To: url}
+}
+
+// Call sends request, marshalled by encoding/xml, as the body of a message with the given action,
+// and unmarshals the body of the response into response. A nil response makes the call one-way.
+// A fault response is returned as a *soap.FaultError.
+func (c *Client) Call(ctx context.Context, action string, request, response interface{}) error {
+	envelope := soap.NewEnvelope(c.SoapVersion)
+	headers := addressing.NewRequest(c.AddressingVersion, action, c.To)
+	if response == nil {
+		headers.ReplyTo = ""
+	}
+	headers.Apply(envelope)
+	err := envelope.SetBody(request)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	err = envelope.Write(buf, nbfs.NewEncoderWithOptions(addressing.EncoderOptions(c.EncoderOptions)))
+	if err != nil {
+		return err
+	}
+	if c.Transport == nil {
+		return errors.New("Client has no Transport")
+	}
+	reply, err := c.Transport.RoundTrip(ctx, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if response == nil && len(reply) == 0 {
+		return nil
+	}
+	replyEnvelope, err := nbfs.DecodeResponse(bytes.NewReader(reply))
+	if err != nil {
+		return err
+	}
+	if replyHeaders, ok := addressing.Read(replyEnvelope); ok && replyHeaders.RelatesTo != "" && replyHeaders.RelatesTo != headers.MessageID {
+		return fmt.Errorf("Response relates to %s, not to request %s", replyHeaders.RelatesTo, headers.MessageID)
+	}
+	if response == nil {
+		return nil
+	}
+	return replyEnvelope.Body.Decode(response)
+}
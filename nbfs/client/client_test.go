@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/khoad/msbingo/nbfs"
+	"github.com/khoad/msbingo/nbfs/addressing"
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+type add struct {
+	XMLName xml.Name `xml:"http://tempuri.org/ Add"`
+	A       int32    `xml:"http://tempuri.org/ a"`
+	B       int32    `xml:"http://tempuri.org/ b"`
+}
+
+type addResponse struct {
+	XMLName   xml.Name `xml:"http://tempuri.org/ AddResponse"`
+	AddResult int32    `xml:"http://tempuri.org/ AddResult"`
+}
+
+// newCalculatorServer answers Add requests, and faults when b is negative
+func newCalculatorServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != ContentType {
+			t.Errorf("Unexpected Content-Type %s", r.Header.Get("Content-Type"))
+		}
+		request, err := soap.ReadEnvelope(r.Body, nbfs.NewDecoder())
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		headers, _ := addressing.Read(request)
+		if headers.Action != "http://tempuri.org/ICalculator/Add" {
+			t.Errorf("Unexpected action %s", headers.Action)
+		}
+		var body add
+		err = request.Body.Decode(&body)
+		if err != nil {
+			t.Error(err)
+		}
+		response := soap.NewEnvelope(soap.Soap12)
+		addressing.NewReply(headers, "http://tempuri.org/ICalculator/AddResponse").Apply(response)
+		if body.B < 0 {
+			response.Body.Fault = &soap.Fault{Code: xml.Name{Space: soap.Soap12.Namespace(), Local: "Sender"}, Reason: "b must not be negative"}
+		} else {
+			response.SetBody(addResponse{AddResult: body.A + body.B})
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if body.B < 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		response.Write(w, nbfs.NewEncoder())
+	}))
+}
+
+func TestCall(t *testing.T) {
+	server := newCalculatorServer(t)
+	defer server.Close()
+
+	var response addResponse
+	err := New(server.URL).Call(context.Background(), "http://tempuri.org/ICalculator/Add", add{A: 2, B: 3}, &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.AddResult != 5 {
+		t.Errorf("Expected 5, got %d", response.AddResult)
+	}
+}
+
+func TestCallFault(t *testing.T) {
+	server := newCalculatorServer(t)
+	defer server.Close()
+
+	err := New(server.URL).Call(context.Background(), "http://tempuri.org/ICalculator/Add", add{A: 2, B: -1}, &addResponse{})
+	var fault *soap.FaultError
+	if !errors.As(err, &fault) {
+		t.Fatalf("Expected a *soap.FaultError, got %v", err)
+	}
+	if fault.Reason != "b must not be negative" {
+		t.Errorf("Unexpected reason %s", fault.Reason)
+	}
+}
+
+func TestHTTPTransportErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := (&HTTPTransport{URL: server.URL}).RoundTrip(context.Background(), []byte{})
+	if err == nil {
+		t.Error("Expected error for a 404 response")
+	}
+}
+
+type transportFunc func(ctx context.Context, request []byte) ([]byte, error)
+
+func (f transportFunc) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	return f(ctx, request)
+}
+
+func TestCallOneWay(t *testing.T) {
+	var sent []byte
+	c := &Client{Transport: transportFunc(func(ctx context.Context, request []byte) ([]byte, error) {
+		sent = request
+		return nil, nil
+	})}
+	err := c.Call(context.Background(), "http://tempuri.org/ICalculator/Reset", add{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := soap.ReadEnvelope(bytes.NewReader(sent), nbfs.NewDecoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, _ := addressing.Read(envelope)
+	if headers.ReplyTo != "" {
+		t.Error("Expected no ReplyTo on a one-way request")
+	}
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// ContentType is the HTTP content type of msbin1 messages
+const ContentType = "application/soap+msbin1"
+
+// HTTPTransport posts msbin1 messages to a URL
+type HTTPTransport struct {
+	URL string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+}
+
+// RoundTrip posts request and returns the response body. Error statuses are returned as responses
+// when they carry an msbin1 body, as WCF sends faults with status 500.
+func (t *HTTPTransport) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", t.URL, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", ContentType)
+	httpClient := t.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if mediaType != ContentType || len(body) == 0 {
+			return nil, fmt.Errorf("Unexpected HTTP status %s from %s", resp.Status, t.URL)
+		}
+	}
+	return body, nil
+}
@@ -3,14 +3,67 @@
 // More info https://msdn.microsoft.com/en-us/library/cc219175.aspx
 package nbfs
 
-import "github.com/khoad/msbingo/nbfx"
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
+)
+
+var dictionary = nbfx.NewDictionary(nbfsDictionary)
 
 // NewDecoder creates a new NBFS Decoder
 func NewDecoder() nbfx.Decoder {
-	return nbfx.NewDecoderWithStrings(nbfsDictionary)
+	return nbfx.NewDecoderWithDictionary(dictionary)
+}
+
+// NewDecoderWithOptions creates a new NBFS Decoder with options controlling the XML it writes
+func NewDecoderWithOptions(opts nbfx.DecoderOptions) nbfx.Decoder {
+	return nbfx.NewDecoderWithOptions(dictionary, opts)
+}
+
+// NewPushDecoder creates an nbfx.PushDecoder of an NBFS message calling fn with each token
+func NewPushDecoder(fn func(xml.Token) error) *nbfx.PushDecoder {
+	return nbfx.NewPushDecoder(dictionary, fn)
+}
+
+// NewReader creates an nbfx.Reader of an NBFS message
+func NewReader(r io.Reader) *nbfx.Reader {
+	return nbfx.NewReader(r, dictionary)
+}
+
+// NewWriter creates an nbfx.Writer of an NBFS message
+func NewWriter(w io.Writer) *nbfx.Writer {
+	return nbfx.NewWriter(w, dictionary)
+}
+
+// Parse reads an NBFS message into an nbfx.Document
+func Parse(r io.Reader) (*nbfx.Document, error) {
+	return nbfx.Parse(r, dictionary)
+}
+
+// NewDocument creates an empty nbfx.Document of an NBFS message
+func NewDocument() *nbfx.Document {
+	return nbfx.NewDocument(dictionary)
 }
 
 // NewEncoder creates a new NBFS Encoder
 func NewEncoder() nbfx.Encoder {
-	return nbfx.NewEncoderWithStrings(nbfsDictionary)
+	return nbfx.NewEncoderWithDictionary(dictionary)
+}
+
+// NewEncoderWithOptions creates a new NBFS Encoder with options controlling how text records are chosen
+func NewEncoderWithOptions(opts nbfx.EncoderOptions) nbfx.Encoder {
+	return nbfx.NewEncoderWithOptions(dictionary, opts)
+}
+
+// DecodeResponse decodes an msbin1 SOAP response. When the body is a fault the envelope
+// is returned along with a *soap.FaultError describing it.
+func DecodeResponse(r io.Reader) (*soap.Envelope, error) {
+	envelope, err := soap.ReadEnvelope(r, NewDecoder())
+	if err != nil {
+		return nil, err
+	}
+	return envelope, envelope.Err()
 }
@@ -0,0 +1,297 @@
+// Package datacontract maps Go values to and from the XML written by WCF's DataContractSerializer.
+//
+// Values are marshalled to soap.Elements, which can be used as SOAP body content or header entries.
+// Struct fields are data members, named and ordered with dc struct tags:
+//
+//	type Person struct {
+//		Name    string   `dc:"Name,order=1"`
+//		Age     int32    `dc:"Age,emitDefault=false"`
+//		Manager *Person  `dc:"Manager,isRequired=true"`
+//		Skip    string   `dc:"-"`
+//	}
+//
+// Members are written in DataContractSerializer order: the members of an embedded struct, which
+// plays the part of a base class, come first, then members without an order in alphabetical order,
+// then members with an order. Nil pointers, slices and interfaces are written with i:nil="true",
+// interface values with i:type, and with PreserveReferences struct pointers are written once
+// with a z:Id and then as a z:Ref.
+package datacontract
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// NamespacePrefix starts the default namespace of a DataContract, which ends with its CLR namespace
+	NamespacePrefix = "http://schemas.datacontract.org/2004/07/"
+	// InstanceNamespace is the namespace of the i:nil and i:type attributes
+	InstanceNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+	// SerializationNamespace is the namespace of the z:Id and z:Ref attributes
+	SerializationNamespace = "http://schemas.microsoft.com/2003/10/Serialization/"
+	// ArraysNamespace is the namespace of collections of primitive types
+	ArraysNamespace = "http://schemas.microsoft.com/2003/10/Serialization/Arrays"
+
+	xsdNamespace = "http://www.w3.org/2001/XMLSchema"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+// primitiveNames are the schema types primitive Go types are written as in i:type and collection items.
+// int and uint are 64 bits, so they are written as long and unsignedLong.
+var primitiveNames = map[reflect.Type]xml.Name{
+	reflect.TypeOf(""):         {Space: xsdNamespace, Local: "string"},
+	reflect.TypeOf(false):      {Space: xsdNamespace, Local: "boolean"},
+	reflect.TypeOf(int8(0)):    {Space: xsdNamespace, Local: "byte"},
+	reflect.TypeOf(int16(0)):   {Space: xsdNamespace, Local: "short"},
+	reflect.TypeOf(int32(0)):   {Space: xsdNamespace, Local: "int"},
+	reflect.TypeOf(int64(0)):   {Space: xsdNamespace, Local: "long"},
+	reflect.TypeOf(0):          {Space: xsdNamespace, Local: "long"},
+	reflect.TypeOf(uint8(0)):   {Space: xsdNamespace, Local: "unsignedByte"},
+	reflect.TypeOf(uint16(0)):  {Space: xsdNamespace, Local: "unsignedShort"},
+	reflect.TypeOf(uint32(0)):  {Space: xsdNamespace, Local: "unsignedInt"},
+	reflect.TypeOf(uint64(0)):  {Space: xsdNamespace, Local: "unsignedLong"},
+	reflect.TypeOf(uint(0)):    {Space: xsdNamespace, Local: "unsignedLong"},
+	reflect.TypeOf(float32(0)): {Space: xsdNamespace, Local: "float"},
+	reflect.TypeOf(float64(0)): {Space: xsdNamespace, Local: "double"},
+	bytesType:                  {Space: xsdNamespace, Local: "base64Binary"},
+	timeType:                   {Space: xsdNamespace, Local: "dateTime"},
+	durationType:               {Space: SerializationNamespace, Local: "duration"},
+}
+
+// primitiveTypes are the Go types i:type values of schema types are read as
+var primitiveTypes = map[xml.Name]reflect.Type{}
+
+func init() {
+	for t, name := range primitiveNames {
+		if t.Kind() != reflect.Int && t.Kind() != reflect.Uint {
+			primitiveTypes[name] = t
+		}
+	}
+}
+
+// Serializer maps Go values to and from DataContract XML
+type Serializer struct {
+	// Namespace is the contract namespace of struct types that are not registered
+	Namespace string
+	// PreserveReferences writes struct pointers with a z:Id the first time they are seen and with
+	// a z:Ref after that, like a DataContractSerializer with preserveObjectReferences set.
+	// References are always resolved when unmarshalling.
+	PreserveReferences bool
+
+	names map[reflect.Type]xml.Name
+	types map[xml.Name]reflect.Type
+}
+
+// NewSerializer creates a Serializer whose unregistered types are in namespace,
+// usually NamespacePrefix followed by the CLR namespace of the service's contracts
+func NewSerializer(namespace string) *Serializer {
+	return &Serializer{Namespace: namespace, names: map[reflect.Type]xml.Name{}, types: map[xml.Name]reflect.Type{}}
+}
+
+// Register sets the contract name of the type of v and makes it a known type,
+// so interface values holding it are written with i:type and can be read back
+func (s *Serializer) Register(v interface{}, name xml.Name) {
+	t := indirectType(reflect.TypeOf(v))
+	s.names[t] = name
+	s.types[name] = t
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func isPrimitive(t reflect.Type) bool {
+	_, ok := primitiveNames[t]
+	if ok {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// contractName returns the name values of type t are written with at the root and as collection items
+func (s *Serializer) contractName(t reflect.Type) (xml.Name, error) {
+	t = indirectType(t)
+	if name, ok := s.names[t]; ok {
+		return name, nil
+	}
+	if name, ok := primitiveNames[t]; ok {
+		return name, nil
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return xml.Name{Space: ArraysNamespace, Local: "anyType"}, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return xml.Name{}, fmt.Errorf("Anonymous struct %s has no contract name", t)
+		}
+		return xml.Name{Space: s.Namespace, Local: t.Name()}, nil
+	case reflect.Slice, reflect.Array:
+		item, err := s.itemName(t.Elem())
+		if err != nil {
+			return xml.Name{}, err
+		}
+		return xml.Name{Space: item.Space, Local: "ArrayOf" + item.Local}, nil
+	}
+	if isPrimitive(t) {
+		return primitiveNames[primitiveType(t)], nil
+	}
+	return xml.Name{}, fmt.Errorf("Type %s is not supported", t)
+}
+
+// itemName returns the element name of the items of a collection of t
+func (s *Serializer) itemName(t reflect.Type) (xml.Name, error) {
+	name, err := s.contractName(t)
+	if err != nil {
+		return xml.Name{}, err
+	}
+	if name.Space == xsdNamespace || name.Space == SerializationNamespace {
+		name.Space = ArraysNamespace
+	}
+	return name, nil
+}
+
+// typeName returns the i:type name of a value of type t held in an interface
+func (s *Serializer) typeName(t reflect.Type) (xml.Name, error) {
+	t = indirectType(t)
+	if name, ok := s.names[t]; ok {
+		return name, nil
+	}
+	if isPrimitive(t) {
+		return primitiveNames[primitiveType(t)], nil
+	}
+	return xml.Name{}, fmt.Errorf("Type %s is not registered", t)
+}
+
+// primitiveType returns the predeclared type named types like `type Status string` are written as
+func primitiveType(t reflect.Type) reflect.Type {
+	if _, ok := primitiveNames[t]; ok {
+		return t
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.TypeOf("")
+	case reflect.Bool:
+		return reflect.TypeOf(false)
+	case reflect.Int8:
+		return reflect.TypeOf(int8(0))
+	case reflect.Int16:
+		return reflect.TypeOf(int16(0))
+	case reflect.Int32:
+		return reflect.TypeOf(int32(0))
+	case reflect.Int, reflect.Int64:
+		return reflect.TypeOf(int64(0))
+	case reflect.Uint8:
+		return reflect.TypeOf(uint8(0))
+	case reflect.Uint16:
+		return reflect.TypeOf(uint16(0))
+	case reflect.Uint32:
+		return reflect.TypeOf(uint32(0))
+	case reflect.Uint, reflect.Uint64:
+		return reflect.TypeOf(uint64(0))
+	case reflect.Float32:
+		return reflect.TypeOf(float32(0))
+	}
+	return reflect.TypeOf(float64(0))
+}
+
+// member is a data member of a struct
+type member struct {
+	index       []int
+	name        xml.Name
+	order       int
+	hasOrder    bool
+	emitDefault bool
+	isRequired  bool
+}
+
+// members returns the data members of struct type t in the order they are written
+func (s *Serializer) members(t reflect.Type) ([]member, error) {
+	contract, err := s.contractName(t)
+	if err != nil {
+		return nil, err
+	}
+	var base, own []member
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, hasTag := field.Tag.Lookup("dc")
+		if field.Anonymous && !hasTag && field.Type.Kind() == reflect.Struct {
+			embedded, err := s.members(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range embedded {
+				m.index = append([]int{i}, m.index...)
+				base = append(base, m)
+			}
+			continue
+		}
+		if field.PkgPath != "" || tag == "-" {
+			continue
+		}
+		m, err := parseTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("Field %s of %s: %s", field.Name, t, err.Error())
+		}
+		if m.name.Local == "" {
+			m.name.Local = field.Name
+		}
+		m.name.Space = contract.Space
+		m.index = []int{i}
+		own = append(own, m)
+	}
+	sort.SliceStable(own, func(i, j int) bool {
+		a, b := own[i], own[j]
+		if a.hasOrder != b.hasOrder {
+			return !a.hasOrder
+		}
+		if a.order != b.order {
+			return a.order < b.order
+		}
+		return a.name.Local < b.name.Local
+	})
+	return append(base, own...), nil
+}
+
+// parseTag reads a dc:"Name,order=2,emitDefault=false,isRequired=true" struct tag
+func parseTag(tag string) (member, error) {
+	m := member{emitDefault: true}
+	parts := strings.Split(tag, ",")
+	m.name.Local = parts[0]
+	for _, option := range parts[1:] {
+		key, value, _ := strings.Cut(option, "=")
+		var err error
+		switch key {
+		case "order":
+			m.order, err = strconv.Atoi(value)
+			m.hasOrder = true
+		case "emitDefault":
+			m.emitDefault, err = strconv.ParseBool(value)
+		case "isRequired":
+			m.isRequired, err = strconv.ParseBool(value)
+		default:
+			return m, fmt.Errorf("Unknown dc tag option %s", key)
+		}
+		if err != nil {
+			return m, fmt.Errorf("Invalid dc tag option %s", option)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,315 @@
+package datacontract
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs"
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+const testNamespace = NamespacePrefix + "Contoso"
+
+const (
+	envelopeStart = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body>`
+	envelopeEnd   = `</s:Body></s:Envelope>`
+)
+
+type Address struct {
+	City   string
+	Street string `dc:"Line1"`
+}
+
+type Person struct {
+	Name     string `dc:"Name,order=1"`
+	Age      int32  `dc:"Age,order=2"`
+	Email    string `dc:"Email,emitDefault=false"`
+	Address  *Address
+	Nickname *string
+	ignored  string
+	Skipped  string `dc:"-"`
+}
+
+type Employee struct {
+	Person
+	Badge int64
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	Radius float64
+}
+
+func (c *Circle) Area() float64 { return 3 * c.Radius * c.Radius }
+
+type Drawing struct {
+	Shapes []Shape
+	Note   interface{}
+}
+
+type Node struct {
+	Value int32
+	Next  *Node
+}
+
+// writeBody returns the XML text of element as the body of an envelope, after a trip through msbin1
+func writeBody(t *testing.T, element *soap.Element) string {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	envelope.Body.Content = []*soap.Element{element}
+	buf := &bytes.Buffer{}
+	err := envelope.Write(buf, nbfs.NewEncoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := nbfs.NewDecoder().Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(text, envelopeStart), envelopeEnd)
+}
+
+// readBody reads the body element of an envelope around body, after a trip through msbin1
+func readBody(t *testing.T, body string) *soap.Element {
+	encoded, err := nbfs.NewEncoder().Encode(strings.NewReader(envelopeStart + body + envelopeEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := soap.ReadEnvelope(bytes.NewReader(encoded), nbfs.NewDecoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope.Body.Content[0]
+}
+
+func TestMarshalMemberOrder(t *testing.T) {
+	s := NewSerializer(testNamespace)
+	element, err := s.Marshal(Person{Name: "Ann", Age: 41, Address: &Address{City: "Oslo", Street: "Main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, writeBody(t, element), `<Person xmlns:i="http://www.w3.org/2001/XMLSchema-instance" xmlns="http://schemas.datacontract.org/2004/07/Contoso">`+
+		`<Address><City>Oslo</City><Line1>Main</Line1></Address><Nickname i:nil="true"></Nickname><Name>Ann</Name><Age>41</Age></Person>`)
+}
+
+func TestMarshalEmbeddedBaseFirst(t *testing.T) {
+	s := NewSerializer(testNamespace)
+	s.Register(Person{}, xml.Name{Space: NamespacePrefix + "Contoso.People", Local: "Person"})
+	element, err := s.MarshalElement(xml.Name{Space: "http://tempuri.org/", Local: "employee"}, Employee{Person: Person{Name: "Bo"}, Badge: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, child := range element.Children {
+		names = append(names, child.Name.Local)
+	}
+	assertStringEqual(t, strings.Join(names, ","), "Address,Nickname,Name,Age,Badge")
+	assertStringEqual(t, element.Children[0].Name.Space, NamespacePrefix+"Contoso.People")
+	assertStringEqual(t, element.Children[4].Name.Space, testNamespace)
+
+	var read Employee
+	err = s.Unmarshal(readBody(t, writeBody(t, element)), &read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, read.Name, "Bo")
+	assertEqual(t, read.Badge, int64(7))
+}
+
+func TestUnmarshalWcfXml(t *testing.T) {
+	element := readBody(t, `<GetPersonResult xmlns="http://tempuri.org/" xmlns:a="http://schemas.datacontract.org/2004/07/Contoso" xmlns:i="http://www.w3.org/2001/XMLSchema-instance">`+
+		`<a:Address i:nil="true"/><a:Email>ann@example.com</a:Email><a:Nickname>Annie</a:Nickname><a:Name>Ann</a:Name><a:Age>41</a:Age></GetPersonResult>`)
+	var person Person
+	err := NewSerializer(testNamespace).Unmarshal(element, &person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, person.Name, "Ann")
+	assertEqual(t, person.Age, int32(41))
+	assertStringEqual(t, person.Email, "ann@example.com")
+	assertEqual(t, person.Address == nil, true)
+	if person.Nickname == nil || *person.Nickname != "Annie" {
+		t.Errorf("Unexpected nickname %v", person.Nickname)
+	}
+}
+
+func TestUnmarshalRequiredMember(t *testing.T) {
+	type Order struct {
+		Id int32 `dc:"Id,isRequired=true"`
+	}
+	element := readBody(t, `<Order xmlns="http://schemas.datacontract.org/2004/07/Contoso"></Order>`)
+	var order Order
+	err := NewSerializer(testNamespace).Unmarshal(element, &order)
+	if err == nil {
+		t.Error("Expected an error for the missing required member")
+	}
+}
+
+func TestInterfaceType(t *testing.T) {
+	s := NewSerializer(testNamespace)
+	s.Register(&Circle{}, xml.Name{Space: NamespacePrefix + "Contoso.Shapes", Local: "Circle"})
+	element, err := s.Marshal(Drawing{Shapes: []Shape{&Circle{Radius: 2}, nil}, Note: int32(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := writeBody(t, element)
+	assertStringEqual(t, text, `<Drawing xmlns:i="http://www.w3.org/2001/XMLSchema-instance" xmlns="http://schemas.datacontract.org/2004/07/Contoso">`+
+		`<Note xmlns:t="http://www.w3.org/2001/XMLSchema" i:type="t:int">5</Note>`+
+		`<Shapes><anyType xmlns:t="http://schemas.datacontract.org/2004/07/Contoso.Shapes" xmlns="http://schemas.microsoft.com/2003/10/Serialization/Arrays" i:type="t:Circle"><t:Radius>2</t:Radius></anyType>`+
+		`<anyType xmlns="http://schemas.microsoft.com/2003/10/Serialization/Arrays" i:nil="true"></anyType></Shapes></Drawing>`)
+
+	var read Drawing
+	err = s.Unmarshal(readBody(t, text), &read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(read.Shapes), 2)
+	assertEqual(t, read.Shapes[0].Area(), 12.0)
+	assertEqual(t, read.Shapes[1], nil)
+	assertEqual(t, read.Note, int32(5))
+}
+
+func TestUnregisteredInterfaceType(t *testing.T) {
+	_, err := NewSerializer(testNamespace).Marshal(Drawing{Shapes: []Shape{&Circle{}}})
+	if err == nil {
+		t.Error("Expected an error for an unregistered type")
+	}
+}
+
+func TestPreserveReferences(t *testing.T) {
+	s := NewSerializer(testNamespace)
+	s.PreserveReferences = true
+	first := &Node{Value: 1}
+	first.Next = &Node{Value: 2, Next: first}
+	element, err := s.MarshalElement(xml.Name{Space: testNamespace, Local: "Node"}, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := writeBody(t, element)
+	assertStringEqual(t, text, `<Node xmlns:i="http://www.w3.org/2001/XMLSchema-instance" xmlns:z="http://schemas.microsoft.com/2003/10/Serialization/" xmlns="http://schemas.datacontract.org/2004/07/Contoso" z:Id="i1">`+
+		`<Next z:Id="i2"><Next z:Ref="i1"></Next><Value>2</Value></Next><Value>1</Value></Node>`)
+
+	var read *Node
+	err = s.Unmarshal(readBody(t, text), &read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, read.Next.Value, int32(2))
+	assertEqual(t, read.Next.Next, read)
+}
+
+func TestPrimitiveCollection(t *testing.T) {
+	element, err := NewSerializer(testNamespace).Marshal([]int32{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, writeBody(t, element), `<ArrayOfint xmlns:i="http://www.w3.org/2001/XMLSchema-instance" xmlns="http://schemas.microsoft.com/2003/10/Serialization/Arrays"><int>1</int><int>2</int></ArrayOfint>`)
+}
+
+func TestPrimitiveRoundTrip(t *testing.T) {
+	type Values struct {
+		When     time.Time
+		Elapsed  time.Duration
+		Ratio    float64
+		Infinite float32
+		Data     []byte
+		Flag     bool
+		Big      uint64
+	}
+	when := time.Date(2020, 5, 6, 7, 8, 9, 123000000, time.UTC)
+	s := NewSerializer(testNamespace)
+	element, err := s.Marshal(Values{When: when, Elapsed: 90*time.Minute + 1500*time.Millisecond, Ratio: 0.5, Infinite: float32(math.Inf(1)), Data: []byte{1, 2}, Flag: true, Big: 1 << 63})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := writeBody(t, element)
+	assertStringEqual(t, text, `<Values xmlns:i="http://www.w3.org/2001/XMLSchema-instance" xmlns="http://schemas.datacontract.org/2004/07/Contoso">`+
+		`<Big>9223372036854775808</Big><Data>AQI=</Data><Elapsed>PT1H30M1.5S</Elapsed><Flag>true</Flag><Infinite>INF</Infinite><Ratio>0.5</Ratio><When>2020-05-06T07:08:09.123Z</When></Values>`)
+
+	var read Values
+	err = s.Unmarshal(readBody(t, text), &read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, read.When.Equal(when), true)
+	assertEqual(t, read.Elapsed, 90*time.Minute+1500*time.Millisecond)
+	assertEqual(t, read.Ratio, 0.5)
+	assertEqual(t, read.Big, uint64(1<<63))
+	assertStringEqual(t, string(read.Data), "\x01\x02")
+}
+
+func TestFormatFloat(t *testing.T) {
+	for _, test := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{1e6, "1000000"},
+		{1e14, "100000000000000"},
+		{1e16, "1E+16"},
+		{1e17, "1E+17"},
+		{1e20, "1E+20"},
+		{123456789012345.0, "123456789012345"},
+		{math.Inf(-1), "-INF"},
+		{math.NaN(), "NaN"},
+		{float32(0.1), "0.1"},
+		{float32(1e6), "1000000"},
+		{float32(1e7), "1E+07"},
+		{float32(1e9), "1E+09"},
+	} {
+		text, err := formatPrimitive(reflect.ValueOf(test.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertStringEqual(t, text, test.expected)
+	}
+}
+
+func TestParseFloatXmlConvertForms(t *testing.T) {
+	var f float64
+	for _, text := range []string{"inf", "0x1p-2", "1_000", "+Inf", "1e"} {
+		if err := parsePrimitive(text, reflect.ValueOf(&f).Elem()); err == nil {
+			t.Errorf("Expected error parsing %q", text)
+		}
+	}
+	if err := parsePrimitive(" 1E+06 ", reflect.ValueOf(&f).Elem()); err != nil || f != 1e6 {
+		t.Errorf("Expected 1000000, got %v, %v", f, err)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for text, d := range map[string]time.Duration{
+		"PT0S":        0,
+		"P1D":         24 * time.Hour,
+		"P1DT2H":      26 * time.Hour,
+		"-PT0.25S":    -250 * time.Millisecond,
+		"PT1M0.0001S": time.Minute + 100*time.Microsecond,
+	} {
+		assertStringEqual(t, formatDuration(d), text)
+		parsed, err := parseDuration(text)
+		if err != nil {
+			t.Error(err)
+		}
+		assertEqual(t, parsed, d)
+	}
+	_, err := parseDuration("P1H")
+	if err == nil {
+		t.Error("Expected an error for hours outside the time part")
+	}
+}
+
+func TestInvalidTag(t *testing.T) {
+	type Bad struct {
+		Field string `dc:"Field,order=first"`
+	}
+	_, err := NewSerializer(testNamespace).Marshal(Bad{})
+	if err == nil {
+		t.Error("Expected an error for an invalid order")
+	}
+}
@@ -0,0 +1,151 @@
+package datacontract
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+// refKey identifies a struct pointer, including its type so a struct and its first field differ
+type refKey struct {
+	pointer uintptr
+	t       reflect.Type
+}
+
+type encodeState struct {
+	*Serializer
+	ids map[refKey]string
+}
+
+// Marshal writes v as an element named after its contract
+func (s *Serializer) Marshal(v interface{}) (*soap.Element, error) {
+	if v == nil {
+		return nil, errors.New("Cannot marshal nil")
+	}
+	name, err := s.contractName(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return s.MarshalElement(name, v)
+}
+
+// MarshalElement writes v as an element with the given name, as operation parameters are written
+func (s *Serializer) MarshalElement(name xml.Name, v interface{}) (*soap.Element, error) {
+	element := &soap.Element{
+		Name: name,
+		Attr: []xml.Attr{{Name: xml.Name{Space: "xmlns", Local: "i"}, Value: InstanceNamespace}},
+	}
+	if s.PreserveReferences {
+		element.Attr = append(element.Attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: "z"}, Value: SerializationNamespace})
+	}
+	e := &encodeState{Serializer: s, ids: map[refKey]string{}}
+	err := e.encodeValue(element, reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return element, nil
+}
+
+func setNil(element *soap.Element) {
+	element.Attr = append(element.Attr, xml.Attr{Name: xml.Name{Space: InstanceNamespace, Local: "nil"}, Value: "true"})
+}
+
+// setType writes an i:type attribute, declaring a prefix for the type's namespace on the element
+func setType(element *soap.Element, name xml.Name) {
+	element.Attr = append(element.Attr,
+		xml.Attr{Name: xml.Name{Space: "xmlns", Local: "t"}, Value: name.Space},
+		xml.Attr{Name: xml.Name{Space: InstanceNamespace, Local: "type"}, Value: "t:" + name.Local})
+}
+
+func (e *encodeState) encodeValue(element *soap.Element, v reflect.Value) error {
+	if !v.IsValid() {
+		setNil(element)
+		return nil
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			setNil(element)
+			return nil
+		}
+		v = v.Elem()
+		name, err := e.typeName(v.Type())
+		if err != nil {
+			return err
+		}
+		setType(element, name)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			setNil(element)
+			return nil
+		}
+		if e.PreserveReferences && v.Elem().Kind() == reflect.Struct && v.Type().Elem() != timeType {
+			key := refKey{v.Pointer(), v.Type()}
+			if id, ok := e.ids[key]; ok {
+				element.Attr = append(element.Attr, xml.Attr{Name: xml.Name{Space: SerializationNamespace, Local: "Ref"}, Value: id})
+				return nil
+			}
+			id := "i" + strconv.Itoa(len(e.ids)+1)
+			e.ids[key] = id
+			element.Attr = append(element.Attr, xml.Attr{Name: xml.Name{Space: SerializationNamespace, Local: "Id"}, Value: id})
+		}
+		return e.encodeValue(element, v.Elem())
+	}
+
+	t := v.Type()
+	switch {
+	case isPrimitive(t) || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			setNil(element)
+			return nil
+		}
+		text, err := formatPrimitive(v)
+		element.Text = text
+		return err
+	case t.Kind() == reflect.Struct:
+		return e.encodeStruct(element, v)
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			setNil(element)
+			return nil
+		}
+		itemName, err := e.itemName(t.Elem())
+		if err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			item := &soap.Element{Name: itemName}
+			err = e.encodeValue(item, v.Index(i))
+			if err != nil {
+				return err
+			}
+			element.Children = append(element.Children, item)
+		}
+		return nil
+	}
+	return fmt.Errorf("Type %s is not supported", t)
+}
+
+func (e *encodeState) encodeStruct(element *soap.Element, v reflect.Value) error {
+	members, err := e.members(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		field := v.FieldByIndex(m.index)
+		if !m.emitDefault && field.IsZero() {
+			continue
+		}
+		child := &soap.Element{Name: m.name}
+		err = e.encodeValue(child, field)
+		if err != nil {
+			return fmt.Errorf("%s: %s", m.name.Local, err.Error())
+		}
+		element.Children = append(element.Children, child)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package datacontract
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/khoad/msbingo/nbfx"
+)
+
+// dateTimeLayout is the xs:dateTime format of DataContractSerializer, with up to 7 fraction digits
+const dateTimeLayout = "2006-01-02T15:04:05.9999999Z07:00"
+
+// formatPrimitive writes v the way XmlConvert does
+func formatPrimitive(v reflect.Value) (string, error) {
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time).Format(dateTimeLayout), nil
+	case durationType:
+		return formatDuration(time.Duration(v.Int())), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return nbfx.FormatFloat(v.Float(), 32), nil
+	case reflect.Float64:
+		return nbfx.FormatFloat(v.Float(), 64), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("Type %s is not a primitive", v.Type())
+}
+
+// formatDuration writes d as an xs:duration, as TimeSpan values are written
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	b.WriteByte('P')
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	if d == 0 {
+		return b.String()
+	}
+	b.WriteByte('T')
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	if hours > 0 {
+		fmt.Fprintf(&b, "%dH", hours)
+	}
+	if minutes > 0 {
+		fmt.Fprintf(&b, "%dM", minutes)
+	}
+	if d > 0 {
+		fmt.Fprintf(&b, "%d", d/time.Second)
+		if fraction := d % time.Second; fraction > 0 {
+			b.WriteString(strings.TrimRight(fmt.Sprintf(".%09d", fraction), "0"))
+		}
+		b.WriteByte('S')
+	}
+	return b.String()
+}
+
+// parseDuration reads an xs:duration of days, hours, minutes and seconds
+func parseDuration(s string) (time.Duration, error) {
+	invalid := fmt.Errorf("Invalid duration %s", s)
+	rest := s
+	negative := strings.HasPrefix(rest, "-")
+	rest = strings.TrimPrefix(rest, "-")
+	if !strings.HasPrefix(rest, "P") || len(rest) == 1 {
+		return 0, invalid
+	}
+	rest = rest[1:]
+	var d time.Duration
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' {
+			if inTime || len(rest) == 1 {
+				return 0, invalid
+			}
+			inTime = true
+			rest = rest[1:]
+			continue
+		}
+		i := strings.IndexAny(rest, "DHMS")
+		if i <= 0 {
+			return 0, invalid
+		}
+		number, designator := rest[:i], rest[i]
+		rest = rest[i+1:]
+		if designator == 'S' {
+			if !inTime {
+				return 0, invalid
+			}
+			whole, fraction, _ := strings.Cut(number, ".")
+			n, err := strconv.ParseInt(whole, 10, 64)
+			if err != nil {
+				return 0, invalid
+			}
+			d += time.Duration(n) * time.Second
+			if fraction != "" {
+				fraction = (fraction + "000000000")[:9]
+				nanos, err := strconv.ParseInt(fraction, 10, 64)
+				if err != nil {
+					return 0, invalid
+				}
+				d += time.Duration(nanos)
+			}
+			continue
+		}
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			return 0, invalid
+		}
+		switch {
+		case designator == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case designator == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case designator == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		default:
+			return 0, invalid
+		}
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+// parsePrimitive reads text into v the way XmlConvert does
+func parsePrimitive(text string, v reflect.Value) error {
+	switch v.Type() {
+	case timeType:
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			// dateTimes of unspecified kind have no offset
+			t, err = time.Parse("2006-01-02T15:04:05.999999999", text)
+		}
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := parseDuration(strings.TrimSpace(text))
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		switch strings.TrimSpace(text) {
+		case "true", "1":
+			v.SetBool(true)
+		case "false", "0":
+			v.SetBool(false)
+		default:
+			return fmt.Errorf("Invalid boolean %s", text)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(text), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := nbfx.ParseFloat(text, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return errors.New("Only byte slices are primitive")
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+		if err != nil {
+			return err
+		}
+		v.SetBytes(b)
+	default:
+		return fmt.Errorf("Type %s is not a primitive", v.Type())
+	}
+	return nil
+}
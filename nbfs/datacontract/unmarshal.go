@@ -0,0 +1,204 @@
+package datacontract
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+type decodeState struct {
+	*Serializer
+	ids map[string]reflect.Value
+}
+
+// Unmarshal reads element into the value v points to. The element's own name is not checked,
+// so operation parameters and contracts at the root are read alike.
+func (s *Serializer) Unmarshal(element *soap.Element, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Unmarshal needs a non-nil pointer")
+	}
+	d := &decodeState{Serializer: s, ids: map[string]reflect.Value{}}
+	return d.decodeValue(element, rv.Elem(), map[string]string{})
+}
+
+// scope adds the namespace declarations of element to the ones in scope of its parent
+func scope(element *soap.Element, parent map[string]string) map[string]string {
+	ns := parent
+	copied := false
+	for _, attr := range element.Attr {
+		prefix := attr.Name.Local
+		if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			prefix = ""
+		} else if attr.Name.Space != "xmlns" {
+			continue
+		}
+		if !copied {
+			ns = make(map[string]string, len(parent)+1)
+			for p, uri := range parent {
+				ns[p] = uri
+			}
+			copied = true
+		}
+		ns[prefix] = attr.Value
+	}
+	return ns
+}
+
+func (d *decodeState) decodeValue(element *soap.Element, v reflect.Value, parent map[string]string) error {
+	ns := scope(element, parent)
+	if ref, ok := element.AttrValue(xml.Name{Space: SerializationNamespace, Local: "Ref"}); ok {
+		return d.setReference(v, ref)
+	}
+	if isNil, _ := element.AttrValue(xml.Name{Space: InstanceNamespace, Local: "nil"}); isNil == "true" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	id, hasId := element.AttrValue(xml.Name{Space: SerializationNamespace, Local: "Id"})
+
+	if v.Kind() == reflect.Interface {
+		typeAttr, ok := element.AttrValue(xml.Name{Space: InstanceNamespace, Local: "type"})
+		if !ok {
+			if v.NumMethod() > 0 {
+				return fmt.Errorf("Element %s has no i:type for %s", element.Name.Local, v.Type())
+			}
+			v.Set(reflect.ValueOf(element.Text))
+			return nil
+		}
+		name, err := resolveQName(typeAttr, ns)
+		if err != nil {
+			return err
+		}
+		t, ok := d.types[name]
+		if !ok {
+			t, ok = primitiveTypes[name]
+		}
+		if !ok {
+			return fmt.Errorf("Unknown type {%s}%s, register it with the Serializer", name.Space, name.Local)
+		}
+		p := reflect.New(t)
+		if hasId {
+			d.ids[id] = p
+		}
+		err = d.decodeContent(element, p.Elem(), ns)
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.Kind() == reflect.Struct && p.Type().AssignableTo(v.Type()):
+			// structs are held by pointer so references to them are shared
+			v.Set(p)
+		case t.AssignableTo(v.Type()):
+			v.Set(p.Elem())
+		case p.Type().AssignableTo(v.Type()):
+			v.Set(p)
+		default:
+			return fmt.Errorf("Type %s is not assignable to %s", t, v.Type())
+		}
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if hasId {
+			// registered before the content is read so cycles resolve
+			d.ids[id] = v
+		}
+		return d.decodeContent(element, v.Elem(), ns)
+	}
+	if hasId {
+		d.ids[id] = v.Addr()
+	}
+	return d.decodeContent(element, v, ns)
+}
+
+// setReference sets v to the value read with z:Id ref
+func (d *decodeState) setReference(v reflect.Value, ref string) error {
+	target, ok := d.ids[ref]
+	if !ok {
+		return fmt.Errorf("Unknown z:Ref %s", ref)
+	}
+	if target.Type().AssignableTo(v.Type()) {
+		v.Set(target)
+	} else if target.Kind() == reflect.Ptr && target.Elem().Type().AssignableTo(v.Type()) {
+		v.Set(target.Elem())
+	} else {
+		return fmt.Errorf("z:Ref %s is a %s, not a %s", ref, target.Type(), v.Type())
+	}
+	return nil
+}
+
+func resolveQName(value string, ns map[string]string) (xml.Name, error) {
+	prefix, local := "", value
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		prefix, local = value[:i], value[i+1:]
+	}
+	uri, ok := ns[prefix]
+	if !ok && prefix != "" {
+		return xml.Name{}, fmt.Errorf("Undeclared prefix %s in %s", prefix, value)
+	}
+	return xml.Name{Space: uri, Local: local}, nil
+}
+
+// decodeContent reads the text or children of element into v, which is not a pointer or interface
+func (d *decodeState) decodeContent(element *soap.Element, v reflect.Value, ns map[string]string) error {
+	t := v.Type()
+	switch {
+	case isPrimitive(t) || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		err := parsePrimitive(element.Text, v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", element.Name.Local, err.Error())
+		}
+		return nil
+	case t.Kind() == reflect.Struct:
+		return d.decodeStruct(element, v, ns)
+	case t.Kind() == reflect.Slice:
+		items := reflect.MakeSlice(t, len(element.Children), len(element.Children))
+		for i, child := range element.Children {
+			err := d.decodeValue(child, items.Index(i), ns)
+			if err != nil {
+				return err
+			}
+		}
+		v.Set(items)
+		return nil
+	case t.Kind() == reflect.Array:
+		if len(element.Children) > v.Len() {
+			return fmt.Errorf("%s has %d items, more than the %d of %s", element.Name.Local, len(element.Children), v.Len(), t)
+		}
+		for i, child := range element.Children {
+			err := d.decodeValue(child, v.Index(i), ns)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Type %s is not supported", t)
+}
+
+func (d *decodeState) decodeStruct(element *soap.Element, v reflect.Value, ns map[string]string) error {
+	members, err := d.members(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		child := element.Child(m.name)
+		if child == nil {
+			if m.isRequired {
+				return fmt.Errorf("Required member %s of %s is missing", m.name.Local, v.Type())
+			}
+			continue
+		}
+		err = d.decodeValue(child, v.FieldByIndex(m.index), ns)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,9 +2,16 @@ package nbfs
 
 import (
 	"bytes"
+	"encoding/xml"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
 )
 
 func TestDecodeExample1(t *testing.T) {
@@ -33,17 +40,19 @@ func TestDecodeExample1(t *testing.T) {
 func TestDecodeExample1ThroughHttpServer(t *testing.T) {
 	path := "../examples/1"
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bin, err := ioutil.ReadFile(path + ".bin")
 		if failOn(err, "unable to open "+path+".bin", t) {
 			return
 		}
 		w.Write(bin)
-	})
-	go http.ListenAndServe(":12345", mux)
+	}))
+	defer server.Close()
 
-	resp, _ := http.Get("http://localhost:12345")
+	resp, err := http.Get(server.URL)
+	if failOn(err, "unable to reach test server", t) {
+		return
+	}
 	decoder := NewDecoder()
 
 	actual, err := decoder.Decode(resp.Body)
@@ -62,13 +71,15 @@ func TestDecodeExample1ThroughHttpServer(t *testing.T) {
 }
 
 func TestDecodeExampleEndElementThroughHttpServer(t *testing.T) {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x01})
-	})
-	go http.ListenAndServe(":12346", mux)
+	}))
+	defer server.Close()
 
-	resp, _ := http.Get("http://localhost:12346")
+	resp, err := http.Get(server.URL)
+	if failOn(err, "unable to reach test server", t) {
+		return
+	}
 	decoder := NewDecoder()
 
 	actual, err := decoder.Decode(resp.Body)
@@ -93,3 +104,93 @@ func TestDecodePrefixDictionaryElementS(t *testing.T) {
 	}
 	assertEqual(t, actual, "<s:Envelope>")
 }
+
+func TestReadEnvelopeExample1(t *testing.T) {
+	bin, err := ioutil.ReadFile("../examples/1.bin")
+	if failOn(err, "unable to open ../examples/1.bin", t) {
+		return
+	}
+	envelope, err := soap.ReadEnvelope(bytes.NewReader(bin), NewDecoder())
+	if failOn(err, "unable to read envelope", t) {
+		return
+	}
+	action := envelope.Header.Get(xml.Name{Space: "http://www.w3.org/2005/08/addressing", Local: "Action"})
+	if action == nil {
+		t.Fatal("Expected Action header")
+	}
+	assertEqual(t, action.Text, "action")
+	if len(envelope.Body.Content) != 1 {
+		t.Fatal("Expected one body element")
+	}
+	assertEqual(t, envelope.Body.Content[0].Name.Local, "Inventory")
+	assertEqual(t, envelope.Body.Content[0].Text, "0")
+}
+
+func TestDecodeResponseSoap11Fault(t *testing.T) {
+	xmlString := `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring xml:lang="en-US">The message could not be processed.</faultstring></s:Fault></s:Body></s:Envelope>`
+	bin, err := NewEncoder().Encode(bytes.NewReader([]byte(xmlString)))
+	if failOn(err, "unable to encode fault", t) {
+		return
+	}
+	envelope, err := DecodeResponse(bytes.NewReader(bin))
+	var faultErr *soap.FaultError
+	if !errors.As(err, &faultErr) {
+		t.Fatalf("Expected a *soap.FaultError, got %v", err)
+	}
+	if envelope == nil || envelope.Body.Fault == nil {
+		t.Error("Expected the fault envelope to be returned")
+	}
+	assertEqual(t, faultErr.Code.Space, soap.Soap11.Namespace())
+	assertEqual(t, faultErr.Code.Local, "Client")
+	assertEqual(t, faultErr.Reason, "The message could not be processed.")
+}
+
+func TestDecodeResponseWithoutFault(t *testing.T) {
+	bin, err := ioutil.ReadFile("../examples/1.bin")
+	if failOn(err, "unable to open ../examples/1.bin", t) {
+		return
+	}
+	envelope, err := DecodeResponse(bytes.NewReader(bin))
+	if failOn(err, "unable to decode response", t) {
+		return
+	}
+	assertEqual(t, envelope.Body.Content[0].Name.Local, "Inventory")
+}
+
+func TestDecodeExample1Canonical(t *testing.T) {
+	bin, err := ioutil.ReadFile("../examples/1.bin")
+	if failOn(err, "unable to open ../examples/1.bin", t) {
+		return
+	}
+	actual, err := NewDecoderWithOptions(nbfx.DecoderOptions{Canonical: true}).Decode(bytes.NewReader(bin))
+	if failOn(err, "unable to decode canonically", t) {
+		return
+	}
+	// canonicalizing the plain XML gives the same result
+	text, err := NewDecoder().Decode(bytes.NewReader(bin))
+	if failOn(err, "unable to decode", t) {
+		return
+	}
+	expected := &bytes.Buffer{}
+	err = (&nbfx.Canonicalizer{}).Canonicalize(expected, strings.NewReader(text))
+	if failOn(err, "unable to canonicalize", t) {
+		return
+	}
+	assertEqual(t, actual, expected.String())
+}
+
+func BenchmarkDecodeExample1(b *testing.B) {
+	bin, err := ioutil.ReadFile("../examples/1.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	decoder := NewDecoder()
+	b.SetBytes(int64(len(bin)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decoder.Decode(bytes.NewReader(bin)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+
+	"github.com/khoad/msbingo/nbfx"
 )
 
 func TestEncodeExample1(t *testing.T) {
@@ -25,3 +27,46 @@ func TestEncodeExample1(t *testing.T) {
 	}
 	assertBinEqual(t, actual, expected)
 }
+
+func TestEncodeExample1WCFCompatible(t *testing.T) {
+	encoder := NewEncoderWithOptions(nbfx.EncoderOptions{Strategy: nbfx.WCFCompatible})
+	xmlBin, err := ioutil.ReadFile("../examples/1.xml")
+	if failOn(err, "unable to open ../examples/1.xml", t) {
+		return
+	}
+	expected, err := ioutil.ReadFile("../examples/1.bin")
+	if failOn(err, "unable to open ../examples/1.bin", t) {
+		return
+	}
+	actual, err := encoder.Encode(bytes.NewReader(xmlBin))
+	if err != nil {
+		t.Error(fmt.Sprint(err.Error()+" : Got ", actual))
+		return
+	}
+	assertBinEqual(t, actual, expected)
+}
+
+func TestEncodeXmlnsPrefixInDictionary(t *testing.T) {
+	// "a" is a dictionary string, but the namespace it is bound to is not
+	actual, err := NewEncoder().Encode(bytes.NewReader([]byte(`<G xmlns:a="urn:y"></G>`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBinEqual(t, actual, []byte{0x40, 0x01, 'G', 0x09, 0x01, 'a', 0x05, 'u', 'r', 'n', ':', 'y', 0x01})
+}
+
+func BenchmarkEncodeExample1(b *testing.B) {
+	xmlBin, err := ioutil.ReadFile("../examples/1.xml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	encoder := NewEncoder()
+	b.SetBytes(int64(len(xmlBin)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := encoder.EncodeTo(ioutil.Discard, bytes.NewReader(xmlBin)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
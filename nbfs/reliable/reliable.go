@@ -0,0 +1,199 @@
+// Package reliable provides WS-ReliableMessaging 2005/02 sessions for calling WCF endpoints
+// configured with reliableSession, and the Sequence and SequenceAcknowledgement headers they use
+package reliable
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+const (
+	// Namespace is the WS-ReliableMessaging 2005/02 namespace WCF's reliable sessions use by default
+	Namespace = "http://schemas.xmlsoap.org/ws/2005/02/rm"
+	// NetNamespace is the namespace of WCF's BufferRemaining flow control extension
+	NetNamespace = "http://schemas.microsoft.com/ws/2006/05/rm"
+
+	CreateSequenceAction          = Namespace + "/CreateSequence"
+	CreateSequenceResponseAction  = Namespace + "/CreateSequenceResponse"
+	SequenceAcknowledgementAction = Namespace + "/SequenceAcknowledgement"
+	LastMessageAction             = Namespace + "/LastMessage"
+	TerminateSequenceAction       = Namespace + "/TerminateSequence"
+)
+
+func rm(local string) xml.Name {
+	return xml.Name{Space: Namespace, Local: local}
+}
+
+// Sequence is the Sequence header giving the number of a message in a sequence
+type Sequence struct {
+	Identifier    string
+	MessageNumber uint64
+	// LastMessage marks the last message of the sequence
+	LastMessage bool
+}
+
+// Apply adds the Sequence header to envelope, marked mustUnderstand like WCF does
+func (s Sequence) Apply(envelope *soap.Envelope) {
+	header := &soap.Element{
+		Name: rm("Sequence"),
+		Attr: []xml.Attr{{Name: xml.Name{Space: envelope.Version.Namespace(), Local: "mustUnderstand"}, Value: "1"}},
+		Children: []*soap.Element{
+			{Name: rm("Identifier"), Text: s.Identifier},
+			{Name: rm("MessageNumber"), Text: strconv.FormatUint(s.MessageNumber, 10)},
+		},
+	}
+	if s.LastMessage {
+		header.Children = append(header.Children, &soap.Element{Name: rm("LastMessage")})
+	}
+	envelope.AddHeader(header)
+}
+
+// ReadSequence returns the Sequence header of envelope, and nil if it has none
+func ReadSequence(envelope *soap.Envelope) (*Sequence, error) {
+	header := envelope.Header.Get(rm("Sequence"))
+	if header == nil {
+		return nil, nil
+	}
+	identifier := header.Child(rm("Identifier"))
+	number := header.Child(rm("MessageNumber"))
+	if identifier == nil || number == nil {
+		return nil, errors.New("Sequence header needs an Identifier and a MessageNumber")
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(number.Text), 10, 64)
+	if err != nil || n == 0 {
+		return nil, fmt.Errorf("Invalid MessageNumber %s", number.Text)
+	}
+	return &Sequence{
+		Identifier:    strings.TrimSpace(identifier.Text),
+		MessageNumber: n,
+		LastMessage:   header.Child(rm("LastMessage")) != nil,
+	}, nil
+}
+
+// Range is an inclusive range of acknowledged message numbers
+type Range struct {
+	Lower uint64
+	Upper uint64
+}
+
+// Acknowledgement is a SequenceAcknowledgement header, acknowledging the messages of a sequence
+// received so far or naming the ones that are missing
+type Acknowledgement struct {
+	Identifier string
+	Ranges     []Range
+	Nacks      []uint64
+	// BufferRemaining is the number of messages the receiver can still buffer, written in WCF's
+	// flow control extension when it is not negative
+	BufferRemaining int
+}
+
+// Apply adds the SequenceAcknowledgement header to envelope
+func (a Acknowledgement) Apply(envelope *soap.Envelope) {
+	header := &soap.Element{Name: rm("SequenceAcknowledgement"), Children: []*soap.Element{{Name: rm("Identifier"), Text: a.Identifier}}}
+	for _, r := range a.Ranges {
+		header.Children = append(header.Children, &soap.Element{Name: rm("AcknowledgementRange"), Attr: []xml.Attr{
+			{Name: xml.Name{Local: "Lower"}, Value: strconv.FormatUint(r.Lower, 10)},
+			{Name: xml.Name{Local: "Upper"}, Value: strconv.FormatUint(r.Upper, 10)},
+		}})
+	}
+	for _, n := range a.Nacks {
+		header.Children = append(header.Children, &soap.Element{Name: rm("Nack"), Text: strconv.FormatUint(n, 10)})
+	}
+	if a.BufferRemaining >= 0 {
+		header.Children = append(header.Children, &soap.Element{
+			Name: xml.Name{Space: NetNamespace, Local: "BufferRemaining"},
+			Text: strconv.Itoa(a.BufferRemaining),
+		})
+	}
+	envelope.AddHeader(header)
+}
+
+// Acknowledges reports whether message number n is in one of the ranges of the acknowledgement
+func (a Acknowledgement) Acknowledges(n uint64) bool {
+	for _, r := range a.Ranges {
+		if r.Lower <= n && n <= r.Upper {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadAcknowledgements returns the SequenceAcknowledgement headers of envelope, one per sequence.
+// BufferRemaining is -1 for acknowledgements without it.
+func ReadAcknowledgements(envelope *soap.Envelope) ([]Acknowledgement, error) {
+	if envelope.Header == nil {
+		return nil, nil
+	}
+	var acks []Acknowledgement
+	for _, header := range envelope.Header.Entries {
+		if header.Name != rm("SequenceAcknowledgement") {
+			continue
+		}
+		ack := Acknowledgement{BufferRemaining: -1}
+		for _, child := range header.Children {
+			var err error
+			switch child.Name {
+			case rm("Identifier"):
+				ack.Identifier = strings.TrimSpace(child.Text)
+			case rm("AcknowledgementRange"):
+				var r Range
+				r.Lower, err = parseAttr(child, "Lower")
+				if err == nil {
+					r.Upper, err = parseAttr(child, "Upper")
+				}
+				if err == nil && r.Lower > r.Upper {
+					err = fmt.Errorf("AcknowledgementRange Lower %d is above Upper %d", r.Lower, r.Upper)
+				}
+				ack.Ranges = append(ack.Ranges, r)
+			case rm("Nack"):
+				var n uint64
+				n, err = strconv.ParseUint(strings.TrimSpace(child.Text), 10, 64)
+				ack.Nacks = append(ack.Nacks, n)
+			case xml.Name{Space: NetNamespace, Local: "BufferRemaining"}:
+				ack.BufferRemaining, err = strconv.Atoi(strings.TrimSpace(child.Text))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid SequenceAcknowledgement: %s", err.Error())
+			}
+		}
+		acks = append(acks, ack)
+	}
+	return acks, nil
+}
+
+func parseAttr(element *soap.Element, local string) (uint64, error) {
+	value, ok := element.AttrValue(xml.Name{Local: local})
+	if !ok {
+		return 0, fmt.Errorf("%s has no %s", element.Name.Local, local)
+	}
+	return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+}
+
+// addRange adds message number n to the sorted ranges, merging the ranges it joins
+func addRange(ranges []Range, n uint64) []Range {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].Upper+1 >= n })
+	switch {
+	case i < len(ranges) && ranges[i].Lower <= n && n <= ranges[i].Upper:
+		return ranges
+	case i < len(ranges) && ranges[i].Upper+1 == n:
+		ranges[i].Upper = n
+		if i+1 < len(ranges) && ranges[i+1].Lower == n+1 {
+			ranges[i].Upper = ranges[i+1].Upper
+			ranges = append(ranges[:i+1], ranges[i+2:]...)
+		}
+		return ranges
+	case i < len(ranges) && ranges[i].Lower == n+1:
+		ranges[i].Lower = n
+		return ranges
+	}
+	ranges = append(ranges, Range{})
+	copy(ranges[i+1:], ranges[i:])
+	ranges[i] = Range{n, n}
+	return ranges
+}
@@ -0,0 +1,269 @@
+package reliable
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs"
+	"github.com/khoad/msbingo/nbfs/addressing"
+	"github.com/khoad/msbingo/nbfs/client"
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+type add struct {
+	XMLName xml.Name `xml:"http://tempuri.org/ Add"`
+	A       int32    `xml:"http://tempuri.org/ a"`
+	B       int32    `xml:"http://tempuri.org/ b"`
+}
+
+type addResponse struct {
+	XMLName   xml.Name `xml:"http://tempuri.org/ AddResponse"`
+	AddResult int32    `xml:"http://tempuri.org/ AddResult"`
+}
+
+// service plays a WCF endpoint with a reliable session over request-reply
+type service struct {
+	t *testing.T
+	// dropReplies is the number of replies lost after the request was processed
+	dropReplies int
+
+	offer      string
+	received   []Range
+	replies    map[uint64][]byte
+	lastReply  uint64
+	calls      int
+	actions    []string
+	clientAcks []Acknowledgement
+	terminated bool
+}
+
+func newService(t *testing.T) *service {
+	return &service{t: t, replies: map[uint64][]byte{}}
+}
+
+func (s *service) reply(request addressing.Headers, action string) *soap.Envelope {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	addressing.NewReply(request, action).Apply(envelope)
+	return envelope
+}
+
+func (s *service) encode(envelope *soap.Envelope) []byte {
+	buf := &bytes.Buffer{}
+	err := envelope.Write(buf, nbfs.NewEncoder())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func (s *service) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	envelope, err := soap.ReadEnvelope(bytes.NewReader(request), nbfs.NewDecoder())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	headers, _ := addressing.Read(envelope)
+	s.actions = append(s.actions, headers.Action)
+	acks, err := ReadAcknowledgements(envelope)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.clientAcks = append(s.clientAcks, acks...)
+
+	switch headers.Action {
+	case CreateSequenceAction:
+		s.offer = envelope.Body.Content[0].Child(rm("Offer")).Child(rm("Identifier")).Text
+		reply := s.reply(headers, CreateSequenceResponseAction)
+		reply.Body.Content = []*soap.Element{{Name: rm("CreateSequenceResponse"), Children: []*soap.Element{
+			{Name: rm("Identifier"), Text: "urn:uuid:service"},
+			{Name: rm("Accept")},
+		}}}
+		return s.encode(reply), nil
+	case TerminateSequenceAction:
+		s.terminated = true
+		return nil, nil
+	}
+
+	seq, err := ReadSequence(envelope)
+	if err != nil || seq == nil || seq.Identifier != "urn:uuid:service" {
+		s.t.Fatalf("Expected a Sequence header for the service's sequence, got %v, %v", seq, err)
+	}
+	s.received = addRange(s.received, seq.MessageNumber)
+	ack := Acknowledgement{Identifier: seq.Identifier, Ranges: s.received, BufferRemaining: 8}
+	reply, ok := s.replies[seq.MessageNumber]
+	if !ok {
+		var response *soap.Envelope
+		if headers.Action == LastMessageAction {
+			response = s.reply(headers, SequenceAcknowledgementAction)
+		} else {
+			var body add
+			err = envelope.Body.Decode(&body)
+			if err != nil {
+				s.t.Fatal(err)
+			}
+			s.calls++
+			response = s.reply(headers, "http://tempuri.org/ICalculator/AddResponse")
+			s.lastReply++
+			Sequence{Identifier: s.offer, MessageNumber: s.lastReply}.Apply(response)
+			response.SetBody(addResponse{AddResult: body.A + body.B})
+		}
+		ack.Apply(response)
+		reply = s.encode(response)
+		s.replies[seq.MessageNumber] = reply
+	}
+	if s.dropReplies > 0 {
+		s.dropReplies--
+		return nil, errors.New("Connection reset")
+	}
+	return reply, nil
+}
+
+func TestSession(t *testing.T) {
+	svc := newService(t)
+	session := NewSession(svc, "http://localhost/Calculator.svc")
+	session.RetryInterval = time.Millisecond
+	c := &client.Client{Transport: session, To: session.To}
+	ctx := context.Background()
+
+	for i := int32(1); i <= 2; i++ {
+		var response addResponse
+		err := c.Call(ctx, "http://tempuri.org/ICalculator/Add", add{A: i, B: 10}, &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.AddResult != i+10 {
+			t.Errorf("Expected %d, got %d", i+10, response.AddResult)
+		}
+	}
+	if session.Identifier() != "urn:uuid:service" || !session.Acknowledged(2) {
+		t.Error("Expected messages 1 and 2 to be acknowledged")
+	}
+	err := session.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedActions := []string{CreateSequenceAction, "http://tempuri.org/ICalculator/Add", "http://tempuri.org/ICalculator/Add", LastMessageAction, TerminateSequenceAction}
+	if !reflect.DeepEqual(svc.actions, expectedActions) {
+		t.Errorf("%v not equal to expected %v", svc.actions, expectedActions)
+	}
+	if !reflect.DeepEqual(svc.received, []Range{{1, 3}}) || !svc.terminated {
+		t.Errorf("Expected messages 1 to 3 and TerminateSequence, got %v", svc.received)
+	}
+	// the second request and LastMessage acknowledge the replies received before them
+	if len(svc.clientAcks) != 2 || svc.clientAcks[1].Identifier != svc.offer || !reflect.DeepEqual(svc.clientAcks[1].Ranges, []Range{{1, 2}}) {
+		t.Errorf("Unexpected acknowledgements of the replies %v", svc.clientAcks)
+	}
+	if session.Open(ctx) == nil {
+		t.Error("Expected error opening a closed session")
+	}
+	if session.Close(ctx) != nil {
+		t.Error("Expected closing twice to succeed")
+	}
+}
+
+func TestSessionRetransmits(t *testing.T) {
+	svc := newService(t)
+	session := NewSession(svc, "http://localhost/Calculator.svc")
+	session.RetryInterval = time.Millisecond
+	c := &client.Client{Transport: session, To: session.To}
+	err := session.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc.dropReplies = 2
+	var response addResponse
+	err = c.Call(context.Background(), "http://tempuri.org/ICalculator/Add", add{A: 1, B: 2}, &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.AddResult != 3 {
+		t.Errorf("Expected 3, got %d", response.AddResult)
+	}
+	if svc.calls != 1 || len(svc.actions) != 4 {
+		t.Errorf("Expected the request to be sent 3 times and processed once, got %d calls of %v", svc.calls, svc.actions)
+	}
+}
+
+func TestSessionGivesUp(t *testing.T) {
+	svc := newService(t)
+	session := &Session{Transport: svc, To: "http://localhost/Calculator.svc", RetryInterval: time.Millisecond, MaxRetries: 2}
+	err := session.Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.dropReplies = 3
+	c := &client.Client{Transport: session, To: session.To}
+	err = c.Call(context.Background(), "http://tempuri.org/ICalculator/Add", add{}, &addResponse{})
+	if err == nil {
+		t.Error("Expected an error after the retries run out")
+	}
+}
+
+func TestAcknowledgementRoundTrip(t *testing.T) {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	ack := Acknowledgement{Identifier: "urn:uuid:1", Ranges: []Range{{1, 3}, {5, 5}}, BufferRemaining: 7}
+	ack.Apply(envelope)
+	Sequence{Identifier: "urn:uuid:2", MessageNumber: 4, LastMessage: true}.Apply(envelope)
+
+	buf := &bytes.Buffer{}
+	err := envelope.Write(buf, nbfs.NewEncoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{Namespace, NetNamespace, "SequenceAcknowledgement", "AcknowledgementRange", "BufferRemaining", "MessageNumber"} {
+		if bytes.Contains(buf.Bytes(), []byte(text)) {
+			t.Errorf("Expected %s to be encoded from the dictionary", text)
+		}
+	}
+	read, err := soap.ReadEnvelope(buf, nbfs.NewDecoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	acks, err := ReadAcknowledgements(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acks) != 1 || !reflect.DeepEqual(acks[0], ack) {
+		t.Errorf("%v not equal to expected %v", acks, ack)
+	}
+	if !ack.Acknowledges(2) || ack.Acknowledges(4) {
+		t.Error("Expected 2 to be acknowledged and 4 not")
+	}
+	seq, err := ReadSequence(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *seq != (Sequence{Identifier: "urn:uuid:2", MessageNumber: 4, LastMessage: true}) {
+		t.Errorf("Unexpected Sequence %v", seq)
+	}
+}
+
+func TestAddRange(t *testing.T) {
+	var ranges []Range
+	for _, n := range []uint64{3, 1, 5, 2, 3, 9, 4, 8} {
+		ranges = addRange(ranges, n)
+	}
+	expected := []Range{{1, 5}, {8, 9}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("%v not equal to expected %v", ranges, expected)
+	}
+}
+
+func TestReadInvalidSequence(t *testing.T) {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	envelope.AddHeader(&soap.Element{Name: rm("Sequence"), Children: []*soap.Element{
+		{Name: rm("Identifier"), Text: "urn:uuid:1"},
+		{Name: rm("MessageNumber"), Text: strconv.Itoa(-1)},
+	}})
+	_, err := ReadSequence(envelope)
+	if err == nil {
+		t.Error("Expected error for a negative MessageNumber")
+	}
+}
@@ -0,0 +1,257 @@
+package reliable
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs"
+	"github.com/khoad/msbingo/nbfs/addressing"
+	"github.com/khoad/msbingo/nbfs/client"
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
+)
+
+const (
+	// DefaultRetryInterval is the time waited before a message without a reply is sent again
+	DefaultRetryInterval = time.Second
+	// DefaultMaxRetries is the number of times a message is sent again, WCF's default maxRetryCount
+	DefaultMaxRetries = 8
+)
+
+// Session is a client.Transport that sends the requests of a client.Client as the messages of a
+// reliable session: it creates a sequence on first use, numbers each request, sends it again until
+// the service acknowledges it, and acknowledges the replies, which come in a sequence it offers.
+//
+//	session := reliable.NewSession(&client.HTTPTransport{URL: url}, url)
+//	c := &client.Client{Transport: session, To: url}
+//	defer session.Close(ctx)
+//
+// Requests are expected to be encoded by a client.Client with the same SOAP and addressing versions.
+type Session struct {
+	// Transport carries the messages of the session, usually a *client.HTTPTransport
+	Transport client.Transport
+	// To is the address of the service
+	To                string
+	SoapVersion       soap.Version
+	AddressingVersion addressing.Version
+	EncoderOptions    nbfx.EncoderOptions
+	// RetryInterval is the time waited before sending a message again, DefaultRetryInterval if zero
+	RetryInterval time.Duration
+	// MaxRetries is the number of times a message is sent again before it fails, DefaultMaxRetries if zero
+	MaxRetries int
+
+	mu         sync.Mutex
+	identifier string
+	offer      string
+	last       uint64
+	acked      []Range
+	received   []Range
+	closed     bool
+}
+
+// NewSession creates a session with the service at to, sending its messages over transport
+func NewSession(transport client.Transport, to string) *Session {
+	return &Session{Transport: transport, To: to}
+}
+
+// Identifier returns the identifier of the session's sequence, empty until it is opened
+func (s *Session) Identifier() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.identifier
+}
+
+// Acknowledged reports whether the service acknowledged message number n of the sequence
+func (s *Session) Acknowledged(n uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Acknowledgement{Ranges: s.acked}.Acknowledges(n)
+}
+
+// Open creates the sequence of the session, offering one for the replies.
+// RoundTrip opens the session when it is first used.
+func (s *Session) Open(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("Session is closed")
+	}
+	if s.identifier != "" {
+		return nil
+	}
+	envelope := s.newEnvelope(CreateSequenceAction)
+	offer := addressing.NewMessageID()
+	address := &soap.Element{Name: xml.Name{Space: s.AddressingVersion.Namespace(), Local: "Address"}, Text: s.AddressingVersion.Anonymous()}
+	envelope.Body.Content = []*soap.Element{{Name: rm("CreateSequence"), Children: []*soap.Element{
+		{Name: rm("AcksTo"), Children: []*soap.Element{address}},
+		{Name: rm("Offer"), Children: []*soap.Element{{Name: rm("Identifier"), Text: offer}}},
+	}}}
+	reply, _, err := s.exchange(ctx, envelope, func(*soap.Envelope) bool { return true })
+	if err != nil {
+		return err
+	}
+	if err = reply.Err(); err != nil {
+		return err
+	}
+	if len(reply.Body.Content) == 0 || reply.Body.Content[0].Name != rm("CreateSequenceResponse") {
+		return errors.New("Reply to CreateSequence is not a CreateSequenceResponse")
+	}
+	response := reply.Body.Content[0]
+	identifier := response.Child(rm("Identifier"))
+	if identifier == nil || strings.TrimSpace(identifier.Text) == "" {
+		return errors.New("CreateSequenceResponse has no Identifier")
+	}
+	s.identifier = strings.TrimSpace(identifier.Text)
+	if response.Child(rm("Accept")) != nil {
+		s.offer = offer
+	}
+	return nil
+}
+
+// RoundTrip sends request as the next message of the sequence and returns the reply, or nothing
+// when the service only acknowledged it, as it does for one-way operations
+func (s *Session) RoundTrip(ctx context.Context, request []byte) ([]byte, error) {
+	err := s.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := soap.ReadEnvelope(bytes.NewReader(request), nbfs.NewDecoder())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("Session is closed")
+	}
+	s.last++
+	n := s.last
+	Sequence{Identifier: s.identifier, MessageNumber: n}.Apply(envelope)
+	s.acknowledgeOffer(envelope)
+	s.mu.Unlock()
+
+	replyEnvelope, reply, err := s.exchange(ctx, envelope, func(r *soap.Envelope) bool { return s.delivered(r, n) })
+	if err != nil {
+		return nil, err
+	}
+	if len(replyEnvelope.Body.Content) == 0 && replyEnvelope.Body.Fault == nil {
+		// an acknowledgement, not a reply
+		return nil, nil
+	}
+	return reply, nil
+}
+
+// Close ends the session, sending the LastMessage of the sequence and then TerminateSequence
+func (s *Session) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed || s.identifier == "" {
+		s.closed = true
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.last++
+	n := s.last
+	last := s.newEnvelope(LastMessageAction)
+	Sequence{Identifier: s.identifier, MessageNumber: n, LastMessage: true}.Apply(last)
+	s.acknowledgeOffer(last)
+	s.mu.Unlock()
+
+	_, _, err := s.exchange(ctx, last, func(r *soap.Envelope) bool { return s.delivered(r, n) })
+	if err != nil {
+		return err
+	}
+	terminate := s.newEnvelope(TerminateSequenceAction)
+	terminate.Body.Content = []*soap.Element{{Name: rm("TerminateSequence"), Children: []*soap.Element{{Name: rm("Identifier"), Text: s.identifier}}}}
+	buf := &bytes.Buffer{}
+	err = terminate.Write(buf, nbfs.NewEncoderWithOptions(addressing.EncoderOptions(s.EncoderOptions)))
+	if err != nil {
+		return err
+	}
+	_, err = s.Transport.RoundTrip(ctx, buf.Bytes())
+	return err
+}
+
+func (s *Session) newEnvelope(action string) *soap.Envelope {
+	envelope := soap.NewEnvelope(s.SoapVersion)
+	addressing.NewRequest(s.AddressingVersion, action, s.To).Apply(envelope)
+	return envelope
+}
+
+// acknowledgeOffer adds the acknowledgement of the replies received so far
+func (s *Session) acknowledgeOffer(envelope *soap.Envelope) {
+	if s.offer != "" && len(s.received) > 0 {
+		Acknowledgement{Identifier: s.offer, Ranges: append([]Range(nil), s.received...), BufferRemaining: -1}.Apply(envelope)
+	}
+}
+
+// delivered processes the sequence headers of reply, and reports whether it shows message n arrived:
+// it acknowledges n, or it is the reply to n
+func (s *Session) delivered(reply *soap.Envelope, n uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acks, _ := ReadAcknowledgements(reply)
+	for _, ack := range acks {
+		if ack.Identifier == s.identifier {
+			s.acked = ack.Ranges
+		}
+	}
+	if seq, _ := ReadSequence(reply); seq != nil && seq.Identifier == s.offer && s.offer != "" {
+		s.received = addRange(s.received, seq.MessageNumber)
+	}
+	return Acknowledgement{Ranges: s.acked}.Acknowledges(n) || len(reply.Body.Content) > 0 || reply.Body.Fault != nil
+}
+
+// exchange sends envelope until a reply satisfies done, or the retries run out,
+// and returns the reply both decoded and as received
+func (s *Session) exchange(ctx context.Context, envelope *soap.Envelope, done func(*soap.Envelope) bool) (*soap.Envelope, []byte, error) {
+	buf := &bytes.Buffer{}
+	err := envelope.Write(buf, nbfs.NewEncoderWithOptions(addressing.EncoderOptions(s.EncoderOptions)))
+	if err != nil {
+		return nil, nil, err
+	}
+	retryInterval, maxRetries := s.RetryInterval, s.MaxRetries
+	if retryInterval == 0 {
+		retryInterval = DefaultRetryInterval
+	}
+	if maxRetries == 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(retryInterval):
+			}
+		}
+		reply, err := s.Transport.RoundTrip(ctx, buf.Bytes())
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if len(reply) == 0 {
+			lastErr = errors.New("No reply")
+			continue
+		}
+		replyEnvelope, err := soap.ReadEnvelope(bytes.NewReader(reply), nbfs.NewDecoder())
+		if err != nil {
+			return nil, nil, err
+		}
+		if done(replyEnvelope) {
+			return replyEnvelope, reply, nil
+		}
+		lastErr = errors.New("Message was not acknowledged")
+	}
+	return nil, nil, fmt.Errorf("Giving up after %d retries: %s", maxRetries, lastErr.Error())
+}
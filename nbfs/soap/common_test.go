@@ -0,0 +1,15 @@
+package soap
+
+import "testing"
+
+func assertEqual(t *testing.T, actual, expected interface{}) {
+	if expected != actual {
+		t.Errorf("%v not equal to expected %v", actual, expected)
+	}
+}
+
+func assertStringEqual(t *testing.T, actual, expected string) {
+	if expected != actual {
+		t.Error(actual + " not equal to expected " + expected)
+	}
+}
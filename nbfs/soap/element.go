@@ -0,0 +1,275 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Element is a generic XML element with namespace-resolved names.
+//
+// Namespace declarations read from a message are kept in Attr (as xmlns and xmlns:prefix attributes)
+// so the same prefixes are used when the element is written again. Text holds all the character data
+// directly inside the element, so the order of mixed content is not kept.
+type Element struct {
+	Name     xml.Name
+	Attr     []xml.Attr
+	Text     string
+	Children []*Element
+}
+
+// NewElement marshals v with encoding/xml into an Element
+func NewElement(v interface{}) (*Element, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return readElement(bytes.NewReader(b))
+}
+
+// Decode unmarshals the element into v with encoding/xml
+func (e *Element) Decode(v interface{}) error {
+	buf := &bytes.Buffer{}
+	err := e.writeXML(buf)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(buf.Bytes(), v)
+}
+
+// Child returns the first child element with the given name, or nil
+func (e *Element) Child(name xml.Name) *Element {
+	for _, child := range e.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// AttrValue returns the value of the attribute with the given name, and whether it was present
+func (e *Element) AttrValue(name xml.Name) (string, bool) {
+	for _, attr := range e.Attr {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func (e *Element) writeXML(w io.Writer) error {
+	writer := &xmlWriter{buf: &bytes.Buffer{}}
+	err := writer.writeElement(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(writer.buf.Bytes())
+	return err
+}
+
+// readElement reads the first element of an XML document, resolving namespaces as it goes
+func readElement(r io.Reader) (*Element, error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("No element found")
+		} else if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return readElementContent(decoder, start)
+		}
+	}
+}
+
+func readElementContent(decoder *xml.Decoder, start xml.StartElement) (*Element, error) {
+	element := &Element{Name: start.Name, Attr: start.Attr}
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := readElementContent(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			element.Children = append(element.Children, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			element.Text = text.String()
+			return element, nil
+		}
+	}
+}
+
+// preferredPrefixes are the prefixes WCF uses for well known namespaces
+var preferredPrefixes = map[string]string{
+	Soap11.Namespace():                                 "s",
+	Soap12.Namespace():                                 "s",
+	"http://www.w3.org/2005/08/addressing":             "a",
+	"http://schemas.xmlsoap.org/ws/2004/08/addressing": "a",
+	"http://www.w3.org/2001/XMLSchema-instance":        "i",
+	"http://schemas.xmlsoap.org/ws/2005/02/rm":         "r",
+	"http://schemas.microsoft.com/ws/2006/05/rm":       "netrm",
+}
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+type nsBinding struct {
+	prefix string
+	uri    string
+}
+
+// xmlWriter writes Elements as XML text, choosing and declaring prefixes as needed
+type xmlWriter struct {
+	buf      *bytes.Buffer
+	bindings []nsBinding
+	nextId   int
+}
+
+func (w *xmlWriter) lookupPrefix(uri string) (string, bool) {
+	for i := len(w.bindings) - 1; i >= 0; i-- {
+		if w.bindings[i].uri == uri && w.isInScope(w.bindings[i].prefix, i) {
+			return w.bindings[i].prefix, true
+		}
+	}
+	return "", false
+}
+
+// isInScope reports whether the binding at index i is not shadowed by a later binding of the same prefix
+func (w *xmlWriter) isInScope(prefix string, i int) bool {
+	for j := i + 1; j < len(w.bindings); j++ {
+		if w.bindings[j].prefix == prefix {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *xmlWriter) lookupNamespace(prefix string) (string, bool) {
+	for i := len(w.bindings) - 1; i >= 0; i-- {
+		if w.bindings[i].prefix == prefix {
+			return w.bindings[i].uri, true
+		}
+	}
+	return "", false
+}
+
+// newPrefix returns a prefix for uri that is not bound yet
+func (w *xmlWriter) newPrefix(uri string) string {
+	if prefix, ok := preferredPrefixes[uri]; ok {
+		if _, bound := w.lookupNamespace(prefix); !bound {
+			return prefix
+		}
+	}
+	for {
+		w.nextId++
+		prefix := fmt.Sprintf("p%d", w.nextId)
+		if _, bound := w.lookupNamespace(prefix); !bound {
+			return prefix
+		}
+	}
+}
+
+func (w *xmlWriter) declare(prefix, uri string) {
+	w.bindings = append(w.bindings, nsBinding{prefix, uri})
+	if prefix == "" {
+		fmt.Fprintf(w.buf, ` xmlns="%s"`, escapeAttr(uri))
+	} else {
+		fmt.Fprintf(w.buf, ` xmlns:%s="%s"`, prefix, escapeAttr(uri))
+	}
+}
+
+func (w *xmlWriter) writeElement(e *Element) error {
+	if e.Name.Local == "" {
+		return errors.New("Element has no name")
+	}
+	mark := len(w.bindings)
+	defer func() { w.bindings = w.bindings[:mark] }()
+
+	var declared []nsBinding
+	for _, attr := range e.Attr {
+		if attr.Name.Space == "xmlns" {
+			declared = append(declared, nsBinding{attr.Name.Local, attr.Value})
+		} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			declared = append(declared, nsBinding{"", attr.Value})
+		}
+	}
+	w.bindings = append(w.bindings, declared...)
+
+	// pick the element prefix before writing so any new declaration can follow the name
+	prefix, ok := w.lookupPrefix(e.Name.Space)
+	if defaultNs, _ := w.lookupNamespace(""); e.Name.Space == defaultNs {
+		prefix, ok = "", true
+	}
+	var pending []nsBinding
+	if !ok {
+		if _, hasPreferred := preferredPrefixes[e.Name.Space]; hasPreferred {
+			prefix = w.newPrefix(e.Name.Space)
+		} else {
+			prefix = ""
+		}
+		pending = append(pending, nsBinding{prefix, e.Name.Space})
+	}
+	qname := e.Name.Local
+	if prefix != "" {
+		qname = prefix + ":" + e.Name.Local
+	}
+	w.buf.WriteString("<" + qname)
+	for _, binding := range declared {
+		if binding.prefix == "" {
+			fmt.Fprintf(w.buf, ` xmlns="%s"`, escapeAttr(binding.uri))
+		} else {
+			fmt.Fprintf(w.buf, ` xmlns:%s="%s"`, binding.prefix, escapeAttr(binding.uri))
+		}
+	}
+	for _, binding := range pending {
+		w.declare(binding.prefix, binding.uri)
+	}
+	for _, attr := range e.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		name := attr.Name.Local
+		switch attr.Name.Space {
+		case "":
+		case xmlNamespace, "xml":
+			name = "xml:" + name
+		default:
+			attrPrefix, ok := w.lookupPrefix(attr.Name.Space)
+			if !ok || attrPrefix == "" {
+				attrPrefix = w.newPrefix(attr.Name.Space)
+				w.declare(attrPrefix, attr.Name.Space)
+			}
+			name = attrPrefix + ":" + name
+		}
+		fmt.Fprintf(w.buf, ` %s="%s"`, name, escapeAttr(attr.Value))
+	}
+	w.buf.WriteString(">")
+	err := xml.EscapeText(w.buf, []byte(e.Text))
+	if err != nil {
+		return err
+	}
+	for _, child := range e.Children {
+		err = w.writeElement(child)
+		if err != nil {
+			return err
+		}
+	}
+	w.buf.WriteString("</" + qname + ">")
+	return nil
+}
+
+func escapeAttr(s string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
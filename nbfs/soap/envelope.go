@@ -0,0 +1,209 @@
+// Package soap provides a SOAP 1.1 and 1.2 envelope object model that reads and writes msbin1 messages
+// through an nbfx Decoder and Encoder, such as the ones returned by nbfs.NewDecoder and nbfs.NewEncoder
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/khoad/msbingo/nbfx"
+)
+
+// Version is the SOAP version of an envelope
+type Version int
+
+const (
+	// Soap12 is SOAP 1.2, used by WCF's msbin1 bindings by default
+	Soap12 Version = iota
+	// Soap11 is SOAP 1.1
+	Soap11
+)
+
+// Namespace returns the envelope namespace of the version
+func (v Version) Namespace() string {
+	if v == Soap11 {
+		return "http://schemas.xmlsoap.org/soap/envelope/"
+	}
+	return "http://www.w3.org/2003/05/soap-envelope"
+}
+
+func (v Version) String() string {
+	if v == Soap11 {
+		return "SOAP 1.1"
+	}
+	return "SOAP 1.2"
+}
+
+func (v Version) name(local string) xml.Name {
+	return xml.Name{Space: v.Namespace(), Local: local}
+}
+
+// Envelope is a SOAP envelope
+type Envelope struct {
+	Version Version
+	// Attr holds the attributes and namespace declarations of the Envelope element
+	Attr   []xml.Attr
+	Header *Header
+	Body   Body
+}
+
+// Header holds the SOAP header entries
+type Header struct {
+	Attr    []xml.Attr
+	Entries []*Element
+}
+
+// Body holds the SOAP body content, or the Fault when the body is a fault
+type Body struct {
+	Attr    []xml.Attr
+	Content []*Element
+	Fault   *Fault
+}
+
+// NewEnvelope creates an empty envelope of the given version
+func NewEnvelope(version Version) *Envelope {
+	return &Envelope{Version: version}
+}
+
+// Get returns the first header entry with the given name, or nil
+func (h *Header) Get(name xml.Name) *Element {
+	if h == nil {
+		return nil
+	}
+	for _, entry := range h.Entries {
+		if entry.Name == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+// AddHeader appends a header entry, creating the Header if needed
+func (e *Envelope) AddHeader(entry *Element) {
+	if e.Header == nil {
+		e.Header = &Header{}
+	}
+	e.Header.Entries = append(e.Header.Entries, entry)
+}
+
+// SetBody replaces the body content with v marshalled by encoding/xml
+func (e *Envelope) SetBody(v interface{}) error {
+	element, err := NewElement(v)
+	if err != nil {
+		return err
+	}
+	e.Body.Content = []*Element{element}
+	e.Body.Fault = nil
+	return nil
+}
+
+// Decode unmarshals the first body element into v with encoding/xml.
+// A fault body is returned as a *FaultError.
+func (b *Body) Decode(v interface{}) error {
+	if b.Fault != nil {
+		return NewFaultError(b.Fault)
+	}
+	if len(b.Content) == 0 {
+		return errors.New("Body is empty")
+	}
+	return b.Content[0].Decode(v)
+}
+
+// ReadEnvelope reads an msbin1 message with decoder
+func ReadEnvelope(r io.Reader, decoder nbfx.Decoder) (*Envelope, error) {
+	text, err := decoder.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := readElement(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+	return envelopeFromElement(root)
+}
+
+// Write writes the envelope as an msbin1 message with encoder
+func (e *Envelope) Write(w io.Writer, encoder nbfx.Encoder) error {
+	buf := &bytes.Buffer{}
+	err := e.WriteXML(buf)
+	if err != nil {
+		return err
+	}
+	return encoder.EncodeTo(w, buf)
+}
+
+// WriteXML writes the envelope as the XML text Write encodes, with the same prefixes
+func (e *Envelope) WriteXML(w io.Writer) error {
+	return e.element().writeXML(w)
+}
+
+func envelopeFromElement(root *Element) (*Envelope, error) {
+	var version Version
+	switch root.Name {
+	case Soap12.name("Envelope"):
+		version = Soap12
+	case Soap11.name("Envelope"):
+		version = Soap11
+	default:
+		return nil, fmt.Errorf("Not a SOAP envelope: {%s}%s", root.Name.Space, root.Name.Local)
+	}
+	envelope := &Envelope{Version: version, Attr: root.Attr}
+	hasBody := false
+	for _, child := range root.Children {
+		switch child.Name {
+		case version.name("Header"):
+			envelope.Header = &Header{Attr: child.Attr, Entries: child.Children}
+		case version.name("Body"):
+			hasBody = true
+			envelope.Body.Attr = child.Attr
+			if len(child.Children) > 0 && child.Children[0].Name == version.name("Fault") {
+				ns := newNamespaces(root.Attr, child.Attr)
+				fault, err := faultFromElement(version, child.Children[0], ns)
+				if err != nil {
+					return nil, err
+				}
+				envelope.Body.Fault = fault
+			} else {
+				envelope.Body.Content = child.Children
+			}
+		default:
+			return nil, fmt.Errorf("Unexpected element in %v envelope: {%s}%s", version, child.Name.Space, child.Name.Local)
+		}
+	}
+	if !hasBody {
+		return nil, fmt.Errorf("%v envelope has no Body", envelope.Version)
+	}
+	return envelope, nil
+}
+
+func (e *Envelope) element() *Element {
+	root := &Element{Name: e.Version.name("Envelope"), Attr: e.Attr}
+	if _, ok := lookupDeclaration(root.Attr, e.Version.Namespace()); !ok {
+		// declare the envelope namespace up front so fault codes can use the s prefix
+		root.Attr = append([]xml.Attr{{Name: xml.Name{Space: "xmlns", Local: "s"}, Value: e.Version.Namespace()}}, root.Attr...)
+	}
+	if e.Header != nil {
+		root.Children = append(root.Children, &Element{Name: e.Version.name("Header"), Attr: e.Header.Attr, Children: e.Header.Entries})
+	}
+	body := &Element{Name: e.Version.name("Body"), Attr: e.Body.Attr, Children: e.Body.Content}
+	if e.Body.Fault != nil {
+		envelopePrefix, _ := lookupDeclaration(root.Attr, e.Version.Namespace())
+		body.Children = []*Element{e.Body.Fault.element(e.Version, envelopePrefix)}
+	}
+	root.Children = append(root.Children, body)
+	return root
+}
+
+// lookupDeclaration finds the prefix an xmlns:prefix attribute declares for uri
+func lookupDeclaration(attrs []xml.Attr, uri string) (string, bool) {
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" && attr.Value == uri {
+			return attr.Name.Local, true
+		}
+	}
+	return "", false
+}
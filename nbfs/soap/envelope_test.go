@@ -0,0 +1,139 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/khoad/msbingo/nbfx"
+)
+
+const addressingNamespace = "http://www.w3.org/2005/08/addressing"
+
+type getData struct {
+	XMLName xml.Name `xml:"http://tempuri.org/ GetData"`
+	Value   int      `xml:"value"`
+}
+
+func roundTrip(t *testing.T, envelope *Envelope) *Envelope {
+	buf := &bytes.Buffer{}
+	err := envelope.Write(buf, nbfx.NewEncoder())
+	if err != nil {
+		t.Fatal("Unexpected error writing envelope: " + err.Error())
+	}
+	read, err := ReadEnvelope(buf, nbfx.NewDecoder())
+	if err != nil {
+		t.Fatal("Unexpected error reading envelope: " + err.Error())
+	}
+	return read
+}
+
+func TestEnvelopeXml(t *testing.T) {
+	envelope := NewEnvelope(Soap12)
+	envelope.AddHeader(&Element{
+		Name: xml.Name{Space: addressingNamespace, Local: "Action"},
+		Attr: []xml.Attr{{Name: xml.Name{Space: Soap12.Namespace(), Local: "mustUnderstand"}, Value: "1"}},
+		Text: "http://tempuri.org/IService/GetData"})
+	err := envelope.SetBody(getData{Value: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	err = envelope.WriteXML(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, buf.String(), `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header><a:Action xmlns:a="http://www.w3.org/2005/08/addressing" s:mustUnderstand="1">http://tempuri.org/IService/GetData</a:Action></s:Header><s:Body><GetData xmlns="http://tempuri.org/"><value>42</value></GetData></s:Body></s:Envelope>`)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	envelope := NewEnvelope(Soap12)
+	envelope.AddHeader(&Element{Name: xml.Name{Space: addressingNamespace, Local: "To"}, Text: "http://localhost/Service.svc"})
+	err := envelope.SetBody(getData{Value: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := roundTrip(t, envelope)
+	assertEqual(t, read.Version, Soap12)
+	to := read.Header.Get(xml.Name{Space: addressingNamespace, Local: "To"})
+	if to == nil {
+		t.Fatal("Expected To header")
+	}
+	assertStringEqual(t, to.Text, "http://localhost/Service.svc")
+	var body getData
+	err = read.Body.Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, body.Value, 42)
+}
+
+func TestEnvelopeRoundTripSoap11(t *testing.T) {
+	envelope := NewEnvelope(Soap11)
+	err := envelope.SetBody(getData{Value: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := roundTrip(t, envelope)
+	assertEqual(t, read.Version, Soap11)
+	if read.Header != nil {
+		t.Error("Expected no Header")
+	}
+	var body getData
+	err = read.Body.Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, body.Value, 7)
+}
+
+func TestFaultRoundTrip(t *testing.T) {
+	envelope := NewEnvelope(Soap12)
+	envelope.Body.Fault = &Fault{
+		Code:     Soap12.name("Sender"),
+		Subcodes: []xml.Name{{Space: addressingNamespace, Local: "DestinationUnreachable"}},
+		Reason:   "No endpoint",
+		Detail:   &Element{Children: []*Element{{Name: xml.Name{Space: "urn:x", Local: "Info"}, Text: "details"}}},
+	}
+	read := roundTrip(t, envelope)
+	fault := read.Body.Fault
+	if fault == nil {
+		t.Fatal("Expected a Fault")
+	}
+	assertEqual(t, fault.Code, Soap12.name("Sender"))
+	assertEqual(t, len(fault.Subcodes), 1)
+	assertEqual(t, fault.Subcodes[0], xml.Name{Space: addressingNamespace, Local: "DestinationUnreachable"})
+	assertStringEqual(t, fault.Reason, "No endpoint")
+	assertStringEqual(t, fault.Lang, "en-US")
+	if fault.Detail == nil || fault.Detail.Child(xml.Name{Space: "urn:x", Local: "Info"}) == nil {
+		t.Error("Expected Info in fault Detail")
+	}
+	if read.Body.Decode(&getData{}) == nil {
+		t.Error("Expected an error decoding a fault body")
+	}
+}
+
+func TestFaultRoundTripSoap11(t *testing.T) {
+	envelope := NewEnvelope(Soap11)
+	envelope.Body.Fault = &Fault{Code: Soap11.name("Client"), Reason: "Bad request", Role: "http://localhost/"}
+	read := roundTrip(t, envelope)
+	fault := read.Body.Fault
+	if fault == nil {
+		t.Fatal("Expected a Fault")
+	}
+	assertEqual(t, fault.Code, Soap11.name("Client"))
+	assertStringEqual(t, fault.Reason, "Bad request")
+	assertStringEqual(t, fault.Role, "http://localhost/")
+}
+
+func TestReadNotAnEnvelope(t *testing.T) {
+	bin, err := nbfx.NewEncoder().Encode(bytes.NewReader([]byte("<a>b</a>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadEnvelope(bytes.NewReader(bin), nbfx.NewDecoder())
+	if err == nil {
+		t.Error("Expected error reading a document that isn't an envelope")
+	}
+}
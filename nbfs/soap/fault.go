@@ -0,0 +1,196 @@
+package soap
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Fault is a SOAP fault, holding the fields of either version.
+//
+// For SOAP 1.1, Code is the faultcode, Reason the faultstring and Role the faultactor.
+type Fault struct {
+	// Code is the namespace-resolved fault code, such as {http://www.w3.org/2003/05/soap-envelope}Sender
+	Code xml.Name
+	// Subcodes are the SOAP 1.2 subcodes, outermost first
+	Subcodes []xml.Name
+	Reason   string
+	// Lang is the xml:lang of the Reason
+	Lang   string
+	Node   string
+	Role   string
+	Detail *Element
+}
+
+// namespaces resolves QName text against the namespace declarations in scope
+type namespaces []xml.Attr
+
+func newNamespaces(attrs ...[]xml.Attr) namespaces {
+	var ns namespaces
+	for _, a := range attrs {
+		ns = ns.with(a)
+	}
+	return ns
+}
+
+func (ns namespaces) with(attrs []xml.Attr) namespaces {
+	scoped := ns[:len(ns):len(ns)]
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			scoped = append(scoped, attr)
+		}
+	}
+	return scoped
+}
+
+func (ns namespaces) resolve(qname string) (xml.Name, error) {
+	qname = strings.TrimSpace(qname)
+	prefix, local := "", qname
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		prefix, local = qname[:i], qname[i+1:]
+	}
+	for i := len(ns) - 1; i >= 0; i-- {
+		if prefix == "" && ns[i].Name.Space == "" || prefix != "" && ns[i].Name.Local == prefix && ns[i].Name.Space == "xmlns" {
+			return xml.Name{Space: ns[i].Value, Local: local}, nil
+		}
+	}
+	if prefix == "" {
+		return xml.Name{Local: local}, nil
+	}
+	return xml.Name{}, fmt.Errorf("Undeclared prefix %s in QName %s", prefix, qname)
+}
+
+func faultFromElement(version Version, element *Element, ns namespaces) (*Fault, error) {
+	ns = ns.with(element.Attr)
+	if version == Soap11 {
+		return fault11FromElement(element, ns)
+	}
+	fault := &Fault{}
+	code := element.Child(version.name("Code"))
+	if code == nil {
+		return nil, errors.New("SOAP 1.2 Fault has no Code")
+	}
+	for code != nil {
+		ns = ns.with(code.Attr)
+		value := code.Child(version.name("Value"))
+		if value == nil {
+			return nil, errors.New("SOAP 1.2 fault Code has no Value")
+		}
+		name, err := ns.with(value.Attr).resolve(value.Text)
+		if err != nil {
+			return nil, err
+		}
+		if fault.Code.Local == "" {
+			fault.Code = name
+		} else {
+			fault.Subcodes = append(fault.Subcodes, name)
+		}
+		code = code.Child(version.name("Subcode"))
+	}
+	if reason := element.Child(version.name("Reason")); reason != nil {
+		if text := reason.Child(version.name("Text")); text != nil {
+			fault.Reason = text.Text
+			fault.Lang, _ = text.AttrValue(xml.Name{Space: xmlNamespace, Local: "lang"})
+		}
+	}
+	if node := element.Child(version.name("Node")); node != nil {
+		fault.Node = node.Text
+	}
+	if role := element.Child(version.name("Role")); role != nil {
+		fault.Role = role.Text
+	}
+	fault.Detail = element.Child(version.name("Detail"))
+	return fault, nil
+}
+
+func fault11FromElement(element *Element, ns namespaces) (*Fault, error) {
+	fault := &Fault{}
+	code := element.Child(xml.Name{Local: "faultcode"})
+	if code == nil {
+		return nil, errors.New("SOAP 1.1 Fault has no faultcode")
+	}
+	name, err := ns.with(code.Attr).resolve(code.Text)
+	if err != nil {
+		return nil, err
+	}
+	fault.Code = name
+	if reason := element.Child(xml.Name{Local: "faultstring"}); reason != nil {
+		fault.Reason = reason.Text
+		fault.Lang, _ = reason.AttrValue(xml.Name{Space: xmlNamespace, Local: "lang"})
+	}
+	if actor := element.Child(xml.Name{Local: "faultactor"}); actor != nil {
+		fault.Role = actor.Text
+	}
+	fault.Detail = element.Child(xml.Name{Local: "detail"})
+	return fault, nil
+}
+
+func (f *Fault) element(version Version, envelopePrefix string) *Element {
+	if version == Soap11 {
+		fault := &Element{Name: version.name("Fault")}
+		fault.Children = append(fault.Children, qnameElement(xml.Name{Local: "faultcode"}, f.Code, version, envelopePrefix))
+		fault.Children = append(fault.Children, textElement(xml.Name{Local: "faultstring"}, f.Reason, f.Lang))
+		if f.Role != "" {
+			fault.Children = append(fault.Children, textElement(xml.Name{Local: "faultactor"}, f.Role, ""))
+		}
+		if f.Detail != nil {
+			fault.Children = append(fault.Children, &Element{Name: xml.Name{Local: "detail"}, Attr: f.Detail.Attr, Text: f.Detail.Text, Children: f.Detail.Children})
+		}
+		return fault
+	}
+	fault := &Element{Name: version.name("Fault")}
+	code := &Element{Name: version.name("Code")}
+	code.Children = append(code.Children, qnameElement(version.name("Value"), f.Code, version, envelopePrefix))
+	fault.Children = append(fault.Children, code)
+	for _, subcode := range f.Subcodes {
+		next := &Element{Name: version.name("Subcode")}
+		next.Children = append(next.Children, qnameElement(version.name("Value"), subcode, version, envelopePrefix))
+		code.Children = append(code.Children, next)
+		code = next
+	}
+	lang := f.Lang
+	if lang == "" {
+		lang = "en-US"
+	}
+	reason := &Element{Name: version.name("Reason")}
+	reason.Children = append(reason.Children, textElement(version.name("Text"), f.Reason, lang))
+	fault.Children = append(fault.Children, reason)
+	if f.Node != "" {
+		fault.Children = append(fault.Children, textElement(version.name("Node"), f.Node, ""))
+	}
+	if f.Role != "" {
+		fault.Children = append(fault.Children, textElement(version.name("Role"), f.Role, ""))
+	}
+	if f.Detail != nil {
+		fault.Children = append(fault.Children, &Element{Name: version.name("Detail"), Attr: f.Detail.Attr, Text: f.Detail.Text, Children: f.Detail.Children})
+	}
+	return fault
+}
+
+// qnameElement writes value as QName text, declaring a prefix for it unless it is in the envelope namespace
+func qnameElement(name xml.Name, value xml.Name, version Version, envelopePrefix string) *Element {
+	element := &Element{Name: name}
+	switch {
+	case value.Space == "":
+		element.Text = value.Local
+	case value.Space == version.Namespace():
+		element.Text = envelopePrefix + ":" + value.Local
+	default:
+		prefix := preferredPrefixes[value.Space]
+		if prefix == "" || prefix == envelopePrefix {
+			prefix = "c"
+		}
+		element.Attr = []xml.Attr{{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: value.Space}}
+		element.Text = prefix + ":" + value.Local
+	}
+	return element
+}
+
+func textElement(name xml.Name, text, lang string) *Element {
+	element := &Element{Name: name, Text: text}
+	if lang != "" {
+		element.Attr = []xml.Attr{{Name: xml.Name{Space: xmlNamespace, Local: "lang"}, Value: lang}}
+	}
+	return element
+}
@@ -0,0 +1,83 @@
+package soap
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// ExceptionDetailNamespace is the namespace of the ExceptionDetail WCF puts in fault details
+// when a service sets includeExceptionDetailInFaults
+const ExceptionDetailNamespace = "http://schemas.datacontract.org/2004/07/System.ServiceModel"
+
+const xmlSchemaInstanceNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// FaultError is the error returned for a message whose body is a SOAP fault
+type FaultError struct {
+	Fault
+	// ExceptionDetail is the service's exception, when the fault detail carries one
+	ExceptionDetail *ExceptionDetail
+}
+
+// ExceptionDetail is the .NET exception WCF reports in fault details
+type ExceptionDetail struct {
+	HelpLink       string
+	Message        string
+	StackTrace     string
+	Type           string
+	InnerException *ExceptionDetail
+}
+
+// NewFaultError creates the FaultError for fault, reading any ExceptionDetail from its detail
+func NewFaultError(fault *Fault) *FaultError {
+	err := &FaultError{Fault: *fault}
+	if fault.Detail != nil {
+		err.ExceptionDetail = readExceptionDetail(fault.Detail.Child(exceptionDetailName("ExceptionDetail")))
+	}
+	return err
+}
+
+func (e *FaultError) Error() string {
+	codes := []string{e.Code.Local}
+	for _, subcode := range e.Subcodes {
+		codes = append(codes, subcode.Local)
+	}
+	message := "SOAP fault " + strings.Join(codes, "/")
+	if e.Reason != "" {
+		message += ": " + e.Reason
+	}
+	return message
+}
+
+// Err returns a *FaultError if the envelope's body is a fault, and nil otherwise
+func (e *Envelope) Err() error {
+	if e.Body.Fault == nil {
+		return nil
+	}
+	return NewFaultError(e.Body.Fault)
+}
+
+func exceptionDetailName(local string) xml.Name {
+	return xml.Name{Space: ExceptionDetailNamespace, Local: local}
+}
+
+func readExceptionDetail(element *Element) *ExceptionDetail {
+	if element == nil {
+		return nil
+	}
+	if isNil, _ := element.AttrValue(xml.Name{Space: xmlSchemaInstanceNamespace, Local: "nil"}); isNil == "true" {
+		return nil
+	}
+	text := func(local string) string {
+		if child := element.Child(exceptionDetailName(local)); child != nil {
+			return child.Text
+		}
+		return ""
+	}
+	return &ExceptionDetail{
+		HelpLink:       text("HelpLink"),
+		Message:        text("Message"),
+		StackTrace:     text("StackTrace"),
+		Type:           text("Type"),
+		InnerException: readExceptionDetail(element.Child(exceptionDetailName("InnerException"))),
+	}
+}
@@ -0,0 +1,61 @@
+package soap
+
+import (
+	"encoding/xml"
+	"errors"
+	"testing"
+)
+
+func exceptionDetailElement() *Element {
+	nilInner := &Element{
+		Name: exceptionDetailName("InnerException"),
+		Attr: []xml.Attr{{Name: xml.Name{Space: xmlSchemaInstanceNamespace, Local: "nil"}, Value: "true"}}}
+	inner := &Element{Name: exceptionDetailName("InnerException"), Children: []*Element{
+		nilInner,
+		{Name: exceptionDetailName("Message"), Text: "Object reference not set to an instance of an object."},
+		{Name: exceptionDetailName("Type"), Text: "System.NullReferenceException"},
+	}}
+	return &Element{Name: exceptionDetailName("ExceptionDetail"), Children: []*Element{
+		{Name: exceptionDetailName("HelpLink"), Attr: nilInner.Attr},
+		inner,
+		{Name: exceptionDetailName("Message"), Text: "Lookup failed"},
+		{Name: exceptionDetailName("StackTrace"), Text: "   at Service.GetData(Int32 value)"},
+		{Name: exceptionDetailName("Type"), Text: "System.InvalidOperationException"},
+	}}
+}
+
+func TestFaultErrorWithExceptionDetail(t *testing.T) {
+	envelope := NewEnvelope(Soap12)
+	envelope.Body.Fault = &Fault{
+		Code:     Soap12.name("Receiver"),
+		Subcodes: []xml.Name{{Space: "http://schemas.microsoft.com/net/2005/12/windowscommunicationfoundation/dispatcher", Local: "InternalServiceFault"}},
+		Reason:   "Lookup failed",
+		Detail:   &Element{Children: []*Element{exceptionDetailElement()}},
+	}
+	read := roundTrip(t, envelope)
+
+	var faultErr *FaultError
+	if !errors.As(read.Body.Decode(&getData{}), &faultErr) {
+		t.Fatal("Expected a *FaultError")
+	}
+	assertStringEqual(t, faultErr.Error(), "SOAP fault Receiver/InternalServiceFault: Lookup failed")
+	detail := faultErr.ExceptionDetail
+	if detail == nil {
+		t.Fatal("Expected ExceptionDetail")
+	}
+	assertStringEqual(t, detail.Type, "System.InvalidOperationException")
+	assertStringEqual(t, detail.StackTrace, "   at Service.GetData(Int32 value)")
+	if detail.InnerException == nil {
+		t.Fatal("Expected InnerException")
+	}
+	assertStringEqual(t, detail.InnerException.Type, "System.NullReferenceException")
+	if detail.InnerException.InnerException != nil {
+		t.Error("Expected nil InnerException to be read as nil")
+	}
+}
+
+func TestEnvelopeErrIsNilWithoutFault(t *testing.T) {
+	if NewEnvelope(Soap12).Err() != nil {
+		t.Error("Expected no error for an envelope without a fault")
+	}
+}
@@ -0,0 +1,522 @@
+package wssecurity
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
+)
+
+// Algorithms and token types of XML Signatures
+const (
+	SignatureNamespace = "http://www.w3.org/2000/09/xmldsig#"
+	ExcC14N            = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	SHA1               = "http://www.w3.org/2000/09/xmldsig#sha1"
+	SHA256             = "http://www.w3.org/2001/04/xmlenc#sha256"
+	RSASHA1            = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	RSASHA256          = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	HMACSHA1           = "http://www.w3.org/2000/09/xmldsig#hmac-sha1"
+	HMACSHA256         = "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256"
+	// X509v3 is the ValueType of a BinarySecurityToken holding a DER encoded certificate
+	X509v3 = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-x509-token-profile-1.0#X509v3"
+)
+
+func dsig(local string) xml.Name {
+	return xml.Name{Space: SignatureNamespace, Local: local}
+}
+
+func algorithm(name xml.Name, uri string) *soap.Element {
+	return &soap.Element{Name: name, Attr: []xml.Attr{{Name: xml.Name{Local: "Algorithm"}, Value: uri}}}
+}
+
+// Signer signs messages with the RSA key of an X.509 certificate or with a symmetric key
+type Signer struct {
+	// Certificate is sent as a BinarySecurityToken the signature refers to. Key must be its *rsa.PrivateKey.
+	Certificate *x509.Certificate
+	// Key is an *rsa.PrivateKey, or the []byte key of an HMAC signature
+	Key interface{}
+	// KeyReference is the SecurityTokenReference URI the signature of a symmetric key refers to,
+	// such as "#" and the Id of a SecurityContextToken
+	KeyReference string
+	// SHA256 signs with RSA-SHA256 or HMAC-SHA256 and SHA-256 digests rather than the SHA-1 ones WCF uses by default
+	SHA256 bool
+	// Headers are the names of header entries signed besides the Body and the Timestamp, such as WS-Addressing To
+	Headers []xml.Name
+}
+
+// Sign signs the Body, the Timestamp of the Security header and the Headers of envelope, adding the
+// Signature to the Security header. Elements without a u:Id are given one. Apply the Security header
+// and add all signed content first: what is signed must not change afterwards.
+func (s *Signer) Sign(envelope *soap.Envelope) error {
+	security := envelope.Header.Get(secext("Security"))
+	if security == nil {
+		return errors.New("Envelope has no Security header to sign")
+	}
+	method, err := s.signatureMethod()
+	if err != nil {
+		return err
+	}
+	ids := newIds(envelope)
+	var references []string
+	if timestamp := security.Child(utility("Timestamp")); timestamp != nil {
+		references = append(references, ids.ensure(&timestamp.Attr))
+	}
+	for _, name := range s.Headers {
+		entry := envelope.Header.Get(name)
+		if entry == nil {
+			return fmt.Errorf("No %s header to sign", name.Local)
+		}
+		references = append(references, ids.ensure(&entry.Attr))
+	}
+	references = append(references, ids.ensure(&envelope.Body.Attr))
+
+	tokenReference := &soap.Element{Name: secext("Reference"), Attr: []xml.Attr{{Name: xml.Name{Local: "URI"}, Value: s.KeyReference}}}
+	if s.Certificate != nil {
+		tokenId := newTokenId()
+		security.Children = append(security.Children, &soap.Element{
+			Name: secext("BinarySecurityToken"),
+			Attr: []xml.Attr{
+				{Name: xml.Name{Space: "xmlns", Local: "u"}, Value: UtilityNamespace},
+				{Name: utility("Id"), Value: tokenId},
+				{Name: xml.Name{Local: "ValueType"}, Value: X509v3},
+				{Name: xml.Name{Local: "EncodingType"}, Value: Base64Binary},
+			},
+			Text: base64.StdEncoding.EncodeToString(s.Certificate.Raw),
+		})
+		tokenReference.Attr = []xml.Attr{{Name: xml.Name{Local: "URI"}, Value: "#" + tokenId}, {Name: xml.Name{Local: "ValueType"}, Value: X509v3}}
+	}
+
+	digestMethod, newHash := SHA1, sha1.New
+	if s.SHA256 {
+		digestMethod, newHash = SHA256, sha256.New
+	}
+	buf := &bytes.Buffer{}
+	err = envelope.WriteXML(buf)
+	if err != nil {
+		return err
+	}
+	signedInfo := &soap.Element{Name: dsig("SignedInfo"), Children: []*soap.Element{algorithm(dsig("CanonicalizationMethod"), ExcC14N), algorithm(dsig("SignatureMethod"), method)}}
+	for _, id := range references {
+		digest, err := digestElement(buf.Bytes(), byId(id), &nbfx.Canonicalizer{}, newHash())
+		if err != nil {
+			return err
+		}
+		transforms := &soap.Element{Name: dsig("Transforms"), Children: []*soap.Element{algorithm(dsig("Transform"), ExcC14N)}}
+		signedInfo.Children = append(signedInfo.Children, &soap.Element{
+			Name: dsig("Reference"),
+			Attr: []xml.Attr{{Name: xml.Name{Local: "URI"}, Value: "#" + id}},
+			Children: []*soap.Element{
+				transforms,
+				algorithm(dsig("DigestMethod"), digestMethod),
+				{Name: dsig("DigestValue"), Text: base64.StdEncoding.EncodeToString(digest)},
+			},
+		})
+	}
+	signatureValue := &soap.Element{Name: dsig("SignatureValue")}
+	signature := &soap.Element{
+		Name:     dsig("Signature"),
+		Attr:     []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: SignatureNamespace}},
+		Children: []*soap.Element{signedInfo, signatureValue},
+	}
+	if s.Certificate != nil || s.KeyReference != "" {
+		securityTokenReference := &soap.Element{Name: secext("SecurityTokenReference"), Children: []*soap.Element{tokenReference}}
+		signature.Children = append(signature.Children, &soap.Element{Name: dsig("KeyInfo"), Children: []*soap.Element{securityTokenReference}})
+	}
+	security.Children = append(security.Children, signature)
+
+	// SignedInfo is signed as it is written in the message
+	buf.Reset()
+	err = envelope.WriteXML(buf)
+	if err != nil {
+		return err
+	}
+	canonical := &bytes.Buffer{}
+	err = (&nbfx.Canonicalizer{}).CanonicalizeElement(canonical, buf, isSignedInfo)
+	if err != nil {
+		return err
+	}
+	value, err := s.sign(method, canonical.Bytes())
+	if err != nil {
+		return err
+	}
+	signatureValue.Text = base64.StdEncoding.EncodeToString(value)
+	return nil
+}
+
+func (s *Signer) signatureMethod() (string, error) {
+	switch key := s.Key.(type) {
+	case *rsa.PrivateKey:
+		if s.Certificate != nil && !key.PublicKey.Equal(s.Certificate.PublicKey) {
+			return "", errors.New("Key is not the key of Certificate")
+		}
+		if s.SHA256 {
+			return RSASHA256, nil
+		}
+		return RSASHA1, nil
+	case []byte:
+		if s.Certificate != nil {
+			return "", errors.New("A Certificate needs its RSA key")
+		}
+		if s.SHA256 {
+			return HMACSHA256, nil
+		}
+		return HMACSHA1, nil
+	}
+	return "", fmt.Errorf("Unsupported signing key %T", s.Key)
+}
+
+func (s *Signer) sign(method string, signedInfo []byte) ([]byte, error) {
+	switch method {
+	case RSASHA1:
+		digest := sha1.Sum(signedInfo)
+		return rsa.SignPKCS1v15(rand.Reader, s.Key.(*rsa.PrivateKey), crypto.SHA1, digest[:])
+	case RSASHA256:
+		digest := sha256.Sum256(signedInfo)
+		return rsa.SignPKCS1v15(rand.Reader, s.Key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	}
+	return macSignedInfo(method, s.Key.([]byte), signedInfo), nil
+}
+
+func macSignedInfo(method string, key, signedInfo []byte) []byte {
+	newHash := sha1.New
+	if method == HMACSHA256 {
+		newHash = sha256.New
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write(signedInfo)
+	return mac.Sum(nil)
+}
+
+// ids are the u:Id values of the elements of an envelope
+type ids map[string]int
+
+func newIds(envelope *soap.Envelope) ids {
+	found := ids{}
+	if id, ok := idOf(envelope.Body.Attr); ok {
+		found[id]++
+	}
+	var elements []*soap.Element
+	if envelope.Header != nil {
+		elements = append(elements, envelope.Header.Entries...)
+	}
+	elements = append(elements, envelope.Body.Content...)
+	walk(elements, func(element *soap.Element) {
+		if id, ok := idOf(element.Attr); ok {
+			found[id]++
+		}
+	})
+	return found
+}
+
+// ensure returns the u:Id of the element with attrs, adding a new one if it has none
+func (found ids) ensure(attrs *[]xml.Attr) string {
+	if id, ok := idOf(*attrs); ok {
+		return id
+	}
+	for n := 1; ; n++ {
+		id := "_" + strconv.Itoa(n)
+		if found[id] == 0 {
+			found[id]++
+			*attrs = append(*attrs, xml.Attr{Name: xml.Name{Space: "xmlns", Local: "u"}, Value: UtilityNamespace}, xml.Attr{Name: utility("Id"), Value: id})
+			return id
+		}
+	}
+}
+
+func idOf(attrs []xml.Attr) (string, bool) {
+	for _, attr := range attrs {
+		if attr.Name == utility("Id") {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func walk(elements []*soap.Element, f func(*soap.Element)) {
+	for _, element := range elements {
+		f(element)
+		walk(element.Children, f)
+	}
+}
+
+func byId(id string) func(xml.Name, []xml.Attr) bool {
+	return func(name xml.Name, attr []xml.Attr) bool {
+		value, ok := idOf(attr)
+		return ok && value == id
+	}
+}
+
+func isSignedInfo(name xml.Name, attr []xml.Attr) bool {
+	return name == dsig("SignedInfo")
+}
+
+// digestElement hashes the canonical form of the element of document match selects
+func digestElement(document []byte, match func(xml.Name, []xml.Attr) bool, c *nbfx.Canonicalizer, h hash.Hash) ([]byte, error) {
+	err := c.CanonicalizeElement(h, bytes.NewReader(document), match)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Verifier checks the signatures of received messages
+type Verifier struct {
+	// Roots are the certificate authorities trusted to issue signing certificates, the system roots if nil
+	Roots *x509.CertPool
+	// SymmetricKey returns the key of an HMAC signature by the URI of its SecurityTokenReference,
+	// and false if it is unknown
+	SymmetricKey func(reference string) ([]byte, bool)
+	// Now returns the time certificates are checked at, time.Now if nil
+	Now func() time.Time
+}
+
+// Verify checks the Signature of the Security header of envelope: the digests of its References,
+// its SignatureValue and the certificate it was made with. The Body and the Timestamp, if there
+// is one, must be signed. It returns the signing certificate, or nil for a symmetric key.
+// The errors of failed checks are *Error.
+func (v *Verifier) Verify(envelope *soap.Envelope) (*x509.Certificate, error) {
+	security := envelope.Header.Get(secext("Security"))
+	if security == nil {
+		return nil, newError(InvalidSecurity, "Message has no Security header")
+	}
+	signature := security.Child(dsig("Signature"))
+	if signature == nil {
+		return nil, newError(InvalidSecurity, "Message is not signed")
+	}
+	signedInfo := signature.Child(dsig("SignedInfo"))
+	if signedInfo == nil {
+		return nil, newError(InvalidSecurity, "Signature has no SignedInfo")
+	}
+	found := newIds(envelope)
+	signedInfos := 0
+	walk(envelope.Header.Entries, func(element *soap.Element) {
+		if element.Name == dsig("SignedInfo") {
+			signedInfos++
+		}
+	})
+	for id, count := range found {
+		if count > 1 {
+			// a second element with the Id of a signed one could be digested in its place
+			return nil, newError(InvalidSecurity, "Duplicate Id %s", id)
+		}
+	}
+	if signedInfos > 1 {
+		return nil, newError(InvalidSecurity, "Message has more than one SignedInfo")
+	}
+
+	canonicalizer, err := canonicalizationMethod(signedInfo.Child(dsig("CanonicalizationMethod")))
+	if err != nil {
+		return nil, err
+	}
+	methodElement := signedInfo.Child(dsig("SignatureMethod"))
+	if methodElement == nil {
+		return nil, newError(InvalidSecurity, "SignedInfo has no SignatureMethod")
+	}
+	method, _ := methodElement.AttrValue(xml.Name{Local: "Algorithm"})
+
+	buf := &bytes.Buffer{}
+	err = envelope.WriteXML(buf)
+	if err != nil {
+		return nil, err
+	}
+	signed := map[string]bool{}
+	for _, reference := range signedInfo.Children {
+		if reference.Name != dsig("Reference") {
+			continue
+		}
+		id, err := verifyReference(buf.Bytes(), reference)
+		if err != nil {
+			return nil, err
+		}
+		signed[id] = true
+	}
+	if id, ok := idOf(envelope.Body.Attr); !ok || !signed[id] {
+		return nil, newError(InvalidSecurity, "Body is not signed")
+	}
+	if timestamp := security.Child(utility("Timestamp")); timestamp != nil {
+		if id, ok := idOf(timestamp.Attr); !ok || !signed[id] {
+			return nil, newError(InvalidSecurity, "Timestamp is not signed")
+		}
+	}
+
+	value := signature.Child(dsig("SignatureValue"))
+	if value == nil {
+		return nil, newError(InvalidSecurity, "Signature has no SignatureValue")
+	}
+	signatureValue, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value.Text))
+	if err != nil {
+		return nil, newError(InvalidSecurity, "Invalid SignatureValue")
+	}
+	canonical := &bytes.Buffer{}
+	err = canonicalizer.CanonicalizeElement(canonical, buf, isSignedInfo)
+	if err != nil {
+		return nil, err
+	}
+	reference := keyReference(signature)
+	switch method {
+	case RSASHA1, RSASHA256:
+		certificate, err := v.certificate(security, reference)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := certificate.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, newError(InvalidSecurityToken, "Certificate has no RSA key")
+		}
+		if method == RSASHA256 {
+			digest := sha256.Sum256(canonical.Bytes())
+			err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signatureValue)
+		} else {
+			digest := sha1.Sum(canonical.Bytes())
+			err = rsa.VerifyPKCS1v15(key, crypto.SHA1, digest[:], signatureValue)
+		}
+		if err != nil {
+			return nil, newError(FailedCheck, "Invalid SignatureValue")
+		}
+		return certificate, nil
+	case HMACSHA1, HMACSHA256:
+		if v.SymmetricKey == nil {
+			return nil, newError(InvalidSecurityToken, "No symmetric keys to verify the signature with")
+		}
+		key, ok := v.SymmetricKey(reference)
+		if !ok {
+			return nil, newError(InvalidSecurityToken, "Unknown symmetric key %s", reference)
+		}
+		if !hmac.Equal(macSignedInfo(method, key, canonical.Bytes()), signatureValue) {
+			return nil, newError(FailedCheck, "Invalid SignatureValue")
+		}
+		return nil, nil
+	}
+	return nil, newError(UnsupportedAlgorithm, "Unsupported SignatureMethod %s", method)
+}
+
+// canonicalizationMethod returns the Canonicalizer of an exc-c14n CanonicalizationMethod or Transform,
+// with the prefixes of its InclusiveNamespaces
+func canonicalizationMethod(method *soap.Element) (*nbfx.Canonicalizer, error) {
+	if method == nil {
+		return nil, newError(InvalidSecurity, "No CanonicalizationMethod")
+	}
+	uri, _ := method.AttrValue(xml.Name{Local: "Algorithm"})
+	if uri != ExcC14N {
+		return nil, newError(UnsupportedAlgorithm, "Unsupported canonicalization %s", uri)
+	}
+	c := &nbfx.Canonicalizer{}
+	if inclusive := method.Child(xml.Name{Space: ExcC14N, Local: "InclusiveNamespaces"}); inclusive != nil {
+		prefixes, _ := inclusive.AttrValue(xml.Name{Local: "PrefixList"})
+		c.InclusivePrefixes = strings.Fields(prefixes)
+	}
+	return c, nil
+}
+
+// verifyReference checks the digest of a Reference to an element by Id, returning the Id
+func verifyReference(document []byte, reference *soap.Element) (string, error) {
+	uri, _ := reference.AttrValue(xml.Name{Local: "URI"})
+	if !strings.HasPrefix(uri, "#") {
+		return "", newError(InvalidSecurity, "Unsupported Reference URI %s", uri)
+	}
+	id := uri[1:]
+	canonicalizer := &nbfx.Canonicalizer{}
+	if transforms := reference.Child(dsig("Transforms")); transforms != nil {
+		for _, transform := range transforms.Children {
+			var err error
+			canonicalizer, err = canonicalizationMethod(transform)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	var h hash.Hash
+	digestMethod := reference.Child(dsig("DigestMethod"))
+	if digestMethod == nil {
+		return "", newError(InvalidSecurity, "Reference %s has no DigestMethod", uri)
+	}
+	switch method, _ := digestMethod.AttrValue(xml.Name{Local: "Algorithm"}); method {
+	case SHA1:
+		h = sha1.New()
+	case SHA256:
+		h = sha256.New()
+	default:
+		return "", newError(UnsupportedAlgorithm, "Unsupported DigestMethod %s", method)
+	}
+	value := reference.Child(dsig("DigestValue"))
+	if value == nil {
+		return "", newError(InvalidSecurity, "Reference %s has no DigestValue", uri)
+	}
+	expected, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value.Text))
+	if err != nil {
+		return "", newError(InvalidSecurity, "Invalid DigestValue of Reference %s", uri)
+	}
+	digest, err := digestElement(document, byId(id), canonicalizer, h)
+	if err != nil {
+		return "", newError(InvalidSecurity, "Reference %s: %s", uri, err.Error())
+	}
+	if !hmac.Equal(digest, expected) {
+		return "", newError(FailedCheck, "Digest of Reference %s does not match", uri)
+	}
+	return id, nil
+}
+
+// keyReference returns the URI of the SecurityTokenReference in the KeyInfo of signature
+func keyReference(signature *soap.Element) string {
+	keyInfo := signature.Child(dsig("KeyInfo"))
+	if keyInfo == nil {
+		return ""
+	}
+	tokenReference := keyInfo.Child(secext("SecurityTokenReference"))
+	if tokenReference == nil {
+		return ""
+	}
+	if reference := tokenReference.Child(secext("Reference")); reference != nil {
+		uri, _ := reference.AttrValue(xml.Name{Local: "URI"})
+		return uri
+	}
+	return ""
+}
+
+// certificate returns the certificate of the BinarySecurityToken reference refers to, verified against the Roots
+func (v *Verifier) certificate(security *soap.Element, reference string) (*x509.Certificate, error) {
+	var token *soap.Element
+	for _, child := range security.Children {
+		if id, ok := idOf(child.Attr); ok && child.Name == secext("BinarySecurityToken") && "#"+id == reference {
+			token = child
+		}
+	}
+	if token == nil {
+		return nil, newError(InvalidSecurityToken, "No BinarySecurityToken %s", reference)
+	}
+	if valueType, _ := token.AttrValue(xml.Name{Local: "ValueType"}); valueType != X509v3 {
+		return nil, newError(InvalidSecurityToken, "Unsupported token type %s", valueType)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token.Text))
+	if err != nil {
+		return nil, newError(InvalidSecurityToken, "Invalid BinarySecurityToken")
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, newError(InvalidSecurityToken, "Invalid certificate: %s", err.Error())
+	}
+	opts := x509.VerifyOptions{Roots: v.Roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if v.Now != nil {
+		opts.CurrentTime = v.Now()
+	}
+	_, err = certificate.Verify(opts)
+	if err != nil {
+		return nil, newError(InvalidSecurityToken, "Untrusted certificate: %s", err.Error())
+	}
+	return certificate, nil
+}
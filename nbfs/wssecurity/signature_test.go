@@ -0,0 +1,184 @@
+package wssecurity
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+var (
+	testKeyOnce     sync.Once
+	testKey         *rsa.PrivateKey
+	testCertificate *x509.Certificate
+)
+
+// newTestCertificate returns a self-signed certificate and its key, generated once for all tests
+func newTestCertificate(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "msbingo test"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testCertificate, err = x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testKey = key
+	})
+	return testCertificate, testKey
+}
+
+type getData struct {
+	XMLName xml.Name `xml:"http://tempuri.org/ GetData"`
+	Value   int      `xml:"value"`
+}
+
+var actionName = xml.Name{Space: "http://www.w3.org/2005/08/addressing", Local: "Action"}
+
+// newSignedEnvelope creates a request with a Timestamp, an Action header and a body
+func newSignedEnvelope(t *testing.T, signer *Signer) *soap.Envelope {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	envelope.AddHeader(&soap.Element{Name: actionName, Text: "http://tempuri.org/IService/GetData"})
+	NewHeader("user", "secret").Apply(envelope)
+	err := envelope.SetBody(getData{Value: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = signer.Sign(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope
+}
+
+func certificateVerifier(certificate *x509.Certificate) *Verifier {
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	return &Verifier{Roots: roots}
+}
+
+func TestSignX509(t *testing.T) {
+	certificate, key := newTestCertificate(t)
+	for _, sha256 := range []bool{false, true} {
+		envelope := newSignedEnvelope(t, &Signer{Certificate: certificate, Key: key, SHA256: sha256, Headers: []xml.Name{actionName}})
+		read := roundTrip(t, envelope)
+		signer, err := certificateVerifier(certificate).Verify(read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !signer.Equal(certificate) {
+			t.Error("Expected the signing certificate")
+		}
+	}
+}
+
+func TestSignHMAC(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	envelope := newSignedEnvelope(t, &Signer{Key: key, KeyReference: "#sct", SHA256: true})
+	verifier := &Verifier{SymmetricKey: func(reference string) ([]byte, bool) {
+		return key, reference == "#sct"
+	}}
+	certificate, err := verifier.Verify(roundTrip(t, envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate != nil {
+		t.Error("Expected no certificate for a symmetric key")
+	}
+
+	other := &Verifier{SymmetricKey: func(string) ([]byte, bool) { return []byte("other"), true }}
+	_, err = other.Verify(roundTrip(t, envelope))
+	assertCode(t, err, FailedCheck)
+}
+
+func TestSignWithoutSecurityHeader(t *testing.T) {
+	_, key := newTestCertificate(t)
+	err := (&Signer{Key: key}).Sign(soap.NewEnvelope(soap.Soap12))
+	if err == nil {
+		t.Error("Expected error signing an envelope without a Security header")
+	}
+}
+
+func TestSignAssignsIds(t *testing.T) {
+	certificate, key := newTestCertificate(t)
+	envelope := newSignedEnvelope(t, &Signer{Certificate: certificate, Key: key, Headers: []xml.Name{actionName}})
+	if id, _ := idOf(envelope.Header.Get(actionName).Attr); id != "_1" {
+		t.Errorf("Expected Action to be given Id _1, got %s", id)
+	}
+	if id, _ := idOf(envelope.Body.Attr); id != "_2" {
+		t.Errorf("Expected Body to be given Id _2, got %s", id)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	certificate, key := newTestCertificate(t)
+	verifier := certificateVerifier(certificate)
+	signer := &Signer{Certificate: certificate, Key: key, Headers: []xml.Name{actionName}}
+
+	body := roundTrip(t, newSignedEnvelope(t, signer))
+	body.Body.Content[0].Children[0].Text = "43"
+	_, err := verifier.Verify(body)
+	assertCode(t, err, FailedCheck)
+
+	header := roundTrip(t, newSignedEnvelope(t, signer))
+	header.Header.Get(actionName).Text = "http://tempuri.org/IService/Delete"
+	_, err = verifier.Verify(header)
+	assertCode(t, err, FailedCheck)
+
+	signedInfo := roundTrip(t, newSignedEnvelope(t, signer))
+	reference := signedInfo.Header.Get(secext("Security")).Child(dsig("Signature")).Child(dsig("SignedInfo")).Child(dsig("Reference"))
+	reference.Child(dsig("DigestMethod")).Attr[0].Value = SHA256
+	_, err = verifier.Verify(signedInfo)
+	assertCode(t, err, FailedCheck)
+}
+
+func TestVerifyDuplicateId(t *testing.T) {
+	certificate, key := newTestCertificate(t)
+	envelope := roundTrip(t, newSignedEnvelope(t, &Signer{Certificate: certificate, Key: key}))
+	bodyId, _ := idOf(envelope.Body.Attr)
+	// a wrapped copy of the signed body, hoping the verifier digests it instead of the real one
+	envelope.AddHeader(&soap.Element{Name: xml.Name{Space: "urn:wrapper", Local: "Wrapper"}, Children: []*soap.Element{
+		{Name: xml.Name{Space: "urn:wrapper", Local: "Body"}, Attr: []xml.Attr{{Name: utility("Id"), Value: bodyId}}},
+	}})
+	_, err := certificateVerifier(certificate).Verify(envelope)
+	assertCode(t, err, InvalidSecurity)
+}
+
+func TestVerifyUntrustedCertificate(t *testing.T) {
+	certificate, key := newTestCertificate(t)
+	envelope := roundTrip(t, newSignedEnvelope(t, &Signer{Certificate: certificate, Key: key}))
+	_, err := (&Verifier{Roots: x509.NewCertPool()}).Verify(envelope)
+	assertCode(t, err, InvalidSecurityToken)
+
+	expired := &Verifier{Roots: certificateVerifier(certificate).Roots, Now: func() time.Time { return time.Now().Add(2 * time.Hour) }}
+	_, err = expired.Verify(envelope)
+	assertCode(t, err, InvalidSecurityToken)
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	NewHeader("user", "secret").Apply(envelope)
+	_, err := (&Verifier{}).Verify(roundTrip(t, envelope))
+	assertCode(t, err, InvalidSecurity)
+}
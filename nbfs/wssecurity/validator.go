@@ -0,0 +1,179 @@
+package wssecurity
+
+import (
+	"crypto/subtle"
+	"encoding/xml"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+)
+
+// Fault codes of failed Security header checks, in SecextNamespace
+const (
+	InvalidSecurity      = "InvalidSecurity"
+	FailedAuthentication = "FailedAuthentication"
+	MessageExpired       = "MessageExpired"
+	InvalidSecurityToken = "InvalidSecurityToken"
+	FailedCheck          = "FailedCheck"
+	UnsupportedAlgorithm = "UnsupportedAlgorithm"
+)
+
+// Error is a Security header that failed validation
+type Error struct {
+	// Code is the WS-Security fault code to reply with, such as FailedAuthentication
+	Code   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return e.Reason
+}
+
+// Fault returns the SOAP fault a service replies to the message with.
+// SOAP 1.2 faults have a Sender code with the WS-Security code as subcode.
+func (e *Error) Fault(version soap.Version) *soap.Fault {
+	if version == soap.Soap11 {
+		return &soap.Fault{Code: secext(e.Code), Reason: e.Reason}
+	}
+	return &soap.Fault{
+		Code:     xml.Name{Space: version.Namespace(), Local: "Sender"},
+		Subcodes: []xml.Name{secext(e.Code)},
+		Reason:   e.Reason,
+	}
+}
+
+func newError(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Validator checks the Security header of messages received by a service
+type Validator struct {
+	// Password returns the password of username, and false for unknown users.
+	// If nil, messages need no UsernameToken.
+	Password func(username string) (string, bool)
+	// RequireTimestamp rejects messages without a Timestamp, as WCF does by default
+	RequireTimestamp bool
+	// MaxClockSkew is the difference allowed between the clocks of client and service, DefaultMaxClockSkew if zero
+	MaxClockSkew time.Duration
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+	// Nonces records the Nonce of each PasswordDigest token until the token is stale, so that a
+	// captured token is rejected when it is sent again. If nil, the Validator keeps its own
+	// MemoryNonceCache, so a Validator must not be copied once used.
+	Nonces NonceCache
+
+	nonces MemoryNonceCache
+}
+
+// NonceCache remembers the Nonces of UsernameTokens a Validator accepted
+type NonceCache interface {
+	// Seen reports whether nonce was recorded and has not expired at now. If it was not,
+	// it records nonce until expires.
+	Seen(nonce string, now, expires time.Time) bool
+}
+
+// nonceSweepInterval is how often a MemoryNonceCache drops expired Nonces
+const nonceSweepInterval = time.Minute
+
+// MemoryNonceCache is a NonceCache for a single service process. Nonces are dropped once they
+// expire, so it holds the Nonces of the tokens received within their lifetime.
+// The zero value is ready to use.
+type MemoryNonceCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	sweep   time.Time
+}
+
+func (c *MemoryNonceCache) Seen(nonce string, now, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expires == nil {
+		c.expires = make(map[string]time.Time)
+	}
+	if now.After(c.sweep) {
+		for n, e := range c.expires {
+			if e.Before(now) {
+				delete(c.expires, n)
+			}
+		}
+		c.sweep = now.Add(nonceSweepInterval)
+	}
+	if e, ok := c.expires[nonce]; ok && !e.Before(now) {
+		return true
+	}
+	c.expires[nonce] = expires
+	return false
+}
+
+// Validate reads the Security header of envelope and checks its Timestamp and UsernameToken.
+// The errors of failed checks are *Error.
+func (v *Validator) Validate(envelope *soap.Envelope) (*Header, error) {
+	h, err := Read(envelope)
+	if err != nil {
+		return nil, newError(InvalidSecurity, "Invalid Security header: %s", err.Error())
+	}
+	if h == nil {
+		return nil, newError(InvalidSecurity, "Message has no Security header")
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	skew := v.MaxClockSkew
+	if skew == 0 {
+		skew = DefaultMaxClockSkew
+	}
+
+	if h.Timestamp == nil && v.RequireTimestamp {
+		return nil, newError(InvalidSecurity, "Security header has no Timestamp")
+	}
+	if ts := h.Timestamp; ts != nil {
+		if ts.Created.After(now.Add(skew)) {
+			return nil, newError(MessageExpired, "Timestamp created at %s is in the future", ts.Created.Format(timeLayout))
+		}
+		if !ts.Expires.IsZero() && ts.Expires.Add(skew).Before(now) {
+			return nil, newError(MessageExpired, "Message expired at %s", ts.Expires.Format(timeLayout))
+		}
+		if !ts.Expires.IsZero() && ts.Expires.Before(ts.Created) {
+			return nil, newError(InvalidSecurity, "Timestamp expires before it is created")
+		}
+	}
+
+	if v.Password == nil {
+		return h, nil
+	}
+	t := h.UsernameToken
+	if t == nil {
+		return nil, newError(InvalidSecurity, "Security header has no UsernameToken")
+	}
+	// unknown users and wrong passwords fail alike, so usernames cannot be probed
+	failed := newError(FailedAuthentication, "Unknown username or incorrect password")
+	password, ok := v.Password(t.Username)
+	if !ok {
+		return nil, failed
+	}
+	expected := password
+	if t.Digest {
+		if len(t.Nonce) == 0 || t.created == "" {
+			return nil, newError(InvalidSecurity, "PasswordDigest needs a Nonce and Created")
+		}
+		if t.Created.After(now.Add(skew)) || t.Created.Add(DefaultTimeToLive+skew).Before(now) {
+			return nil, newError(MessageExpired, "UsernameToken created at %s is stale", t.created)
+		}
+		expected = t.digest(password)
+	}
+	if subtle.ConstantTimeCompare([]byte(t.Password), []byte(expected)) != 1 {
+		return nil, failed
+	}
+	// only Nonces of authenticated tokens are recorded, so they cannot be used up by others
+	var nonces NonceCache = &v.nonces
+	if v.Nonces != nil {
+		nonces = v.Nonces
+	}
+	if t.Digest && nonces.Seen(string(t.Nonce), now, t.Created.Add(DefaultTimeToLive+skew)) {
+		return nil, newError(FailedAuthentication, "UsernameToken Nonce was already used")
+	}
+	return h, nil
+}
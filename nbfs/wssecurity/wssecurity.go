@@ -0,0 +1,243 @@
+// Package wssecurity provides WS-Security 1.0 Timestamp and UsernameToken headers for SOAP messages
+// sent as msbin1, as required by WCF bindings using TransportWithMessageCredential security
+package wssecurity
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// SecextNamespace is the namespace of the Security header and UsernameToken
+	SecextNamespace = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	// UtilityNamespace is the namespace of the Timestamp and of Id attributes
+	UtilityNamespace = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+	// PasswordText is the Password Type of a password sent as is
+	PasswordText = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText"
+	// PasswordDigest is the Password Type of a password sent as Base64(SHA-1(Nonce + Created + Password))
+	PasswordDigest = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest"
+	// Base64Binary is the EncodingType of a Nonce
+	Base64Binary = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary"
+
+	// DefaultTimeToLive is the lifetime WCF gives the Timestamps it sends
+	DefaultTimeToLive = 5 * time.Minute
+	// DefaultMaxClockSkew is the difference between the clocks of client and service WCF tolerates
+	DefaultMaxClockSkew = 5 * time.Minute
+)
+
+// timeLayout is the xs:dateTime format of WCF timestamps, in UTC with milliseconds
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+func secext(local string) xml.Name {
+	return xml.Name{Space: SecextNamespace, Local: local}
+}
+
+func utility(local string) xml.Name {
+	return xml.Name{Space: UtilityNamespace, Local: local}
+}
+
+// Timestamp is the lifetime of a message
+type Timestamp struct {
+	Id      string
+	Created time.Time
+	// Expires is optional, a zero time is not written
+	Expires time.Time
+}
+
+// UsernameToken holds the credentials of the sender of a message
+type UsernameToken struct {
+	Id       string
+	Username string
+	// Password is the password, or the Base64 digest when Digest is set and the token was read from a message
+	Password string
+	// Digest sends the password as a PasswordDigest of Nonce, Created and Password
+	Digest  bool
+	Nonce   []byte
+	Created time.Time
+
+	// created is the Created text read from a message, which the digest is computed over
+	created string
+}
+
+// Header is a wsse:Security header
+type Header struct {
+	Timestamp     *Timestamp
+	UsernameToken *UsernameToken
+}
+
+// NewHeader creates a Security header like WCF sends with TransportWithMessageCredential:
+// a Timestamp valid for DefaultTimeToLive and a UsernameToken with a PasswordText password
+func NewHeader(username, password string) Header {
+	now := time.Now().UTC()
+	return Header{
+		Timestamp: &Timestamp{Id: "_0", Created: now, Expires: now.Add(DefaultTimeToLive)},
+		UsernameToken: &UsernameToken{
+			Id:       newTokenId(),
+			Username: username,
+			Password: password,
+		},
+	}
+}
+
+// newTokenId returns a new token Id in the form WCF uses
+func newTokenId() string {
+	return "uuid-" + uuid.NewV4().String() + "-1"
+}
+
+// NewDigestHeader creates a Security header like NewHeader, sending the password as a
+// PasswordDigest with a random Nonce so it does not travel in the clear
+func NewDigestHeader(username, password string) (Header, error) {
+	h := NewHeader(username, password)
+	h.UsernameToken.Digest = true
+	h.UsernameToken.Nonce = make([]byte, 16)
+	_, err := rand.Read(h.UsernameToken.Nonce)
+	if err != nil {
+		return Header{}, err
+	}
+	h.UsernameToken.Created = h.Timestamp.Created
+	return h, nil
+}
+
+// createdText returns the Created text of the token as sent
+func (t *UsernameToken) createdText() string {
+	if t.created != "" {
+		return t.created
+	}
+	return t.Created.UTC().Format(timeLayout)
+}
+
+// digest returns Base64(SHA-1(Nonce + Created + password))
+func (t *UsernameToken) digest(password string) string {
+	h := sha1.New()
+	h.Write(t.Nonce)
+	h.Write([]byte(t.createdText()))
+	h.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Apply adds the Security header to envelope, marked mustUnderstand like WCF does.
+// The Timestamp comes first, so services with a strict security header layout accept it.
+func (h Header) Apply(envelope *soap.Envelope) {
+	security := &soap.Element{
+		Name: secext("Security"),
+		Attr: []xml.Attr{
+			{Name: xml.Name{Space: "xmlns", Local: "o"}, Value: SecextNamespace},
+			{Name: xml.Name{Space: envelope.Version.Namespace(), Local: "mustUnderstand"}, Value: "1"},
+		},
+	}
+	utilityPrefix := xml.Attr{Name: xml.Name{Space: "xmlns", Local: "u"}, Value: UtilityNamespace}
+	if ts := h.Timestamp; ts != nil {
+		timestamp := &soap.Element{Name: utility("Timestamp"), Attr: []xml.Attr{utilityPrefix}}
+		if ts.Id != "" {
+			timestamp.Attr = append(timestamp.Attr, xml.Attr{Name: utility("Id"), Value: ts.Id})
+		}
+		timestamp.Children = append(timestamp.Children, &soap.Element{Name: utility("Created"), Text: ts.Created.UTC().Format(timeLayout)})
+		if !ts.Expires.IsZero() {
+			timestamp.Children = append(timestamp.Children, &soap.Element{Name: utility("Expires"), Text: ts.Expires.UTC().Format(timeLayout)})
+		}
+		security.Children = append(security.Children, timestamp)
+	}
+	if t := h.UsernameToken; t != nil {
+		token := &soap.Element{Name: secext("UsernameToken")}
+		if t.Id != "" || t.Digest {
+			token.Attr = []xml.Attr{utilityPrefix}
+		}
+		if t.Id != "" {
+			token.Attr = append(token.Attr, xml.Attr{Name: utility("Id"), Value: t.Id})
+		}
+		token.Children = append(token.Children, &soap.Element{Name: secext("Username"), Text: t.Username})
+		if t.Digest {
+			token.Children = append(token.Children,
+				&soap.Element{Name: secext("Password"), Attr: []xml.Attr{{Name: xml.Name{Local: "Type"}, Value: PasswordDigest}}, Text: t.digest(t.Password)},
+				&soap.Element{Name: secext("Nonce"), Attr: []xml.Attr{{Name: xml.Name{Local: "EncodingType"}, Value: Base64Binary}}, Text: base64.StdEncoding.EncodeToString(t.Nonce)},
+				&soap.Element{Name: utility("Created"), Text: t.createdText()})
+		} else {
+			token.Children = append(token.Children, &soap.Element{Name: secext("Password"), Attr: []xml.Attr{{Name: xml.Name{Local: "Type"}, Value: PasswordText}}, Text: t.Password})
+		}
+		security.Children = append(security.Children, token)
+	}
+	envelope.AddHeader(security)
+}
+
+// Read returns the Security header of envelope, and nil if it has none
+func Read(envelope *soap.Envelope) (*Header, error) {
+	security := envelope.Header.Get(secext("Security"))
+	if security == nil {
+		return nil, nil
+	}
+	h := &Header{}
+	if timestamp := security.Child(utility("Timestamp")); timestamp != nil {
+		h.Timestamp = &Timestamp{}
+		h.Timestamp.Id, _ = timestamp.AttrValue(utility("Id"))
+		created := timestamp.Child(utility("Created"))
+		if created == nil {
+			return nil, errors.New("Timestamp has no Created")
+		}
+		var err error
+		h.Timestamp.Created, err = parseTime(created.Text)
+		if err != nil {
+			return nil, err
+		}
+		if expires := timestamp.Child(utility("Expires")); expires != nil {
+			h.Timestamp.Expires, err = parseTime(expires.Text)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if token := security.Child(secext("UsernameToken")); token != nil {
+		t := &UsernameToken{}
+		t.Id, _ = token.AttrValue(utility("Id"))
+		username := token.Child(secext("Username"))
+		if username == nil {
+			return nil, errors.New("UsernameToken has no Username")
+		}
+		t.Username = strings.TrimSpace(username.Text)
+		if password := token.Child(secext("Password")); password != nil {
+			t.Password = password.Text
+			passwordType, _ := password.AttrValue(xml.Name{Local: "Type"})
+			switch passwordType {
+			case "", PasswordText:
+			case PasswordDigest:
+				t.Digest = true
+				t.Password = strings.TrimSpace(t.Password)
+			default:
+				return nil, fmt.Errorf("Unsupported password type %s", passwordType)
+			}
+		}
+		if nonce := token.Child(secext("Nonce")); nonce != nil {
+			var err error
+			t.Nonce, err = base64.StdEncoding.DecodeString(strings.TrimSpace(nonce.Text))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid Nonce: %s", err.Error())
+			}
+		}
+		if created := token.Child(utility("Created")); created != nil {
+			var err error
+			t.created = strings.TrimSpace(created.Text)
+			t.Created, err = parseTime(t.created)
+			if err != nil {
+				return nil, err
+			}
+		}
+		h.UsernameToken = t
+	}
+	return h, nil
+}
+
+func parseTime(text string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(text))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %s", text)
+	}
+	return t, nil
+}
@@ -0,0 +1,281 @@
+package wssecurity
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/khoad/msbingo/nbfs"
+	"github.com/khoad/msbingo/nbfs/soap"
+	"github.com/khoad/msbingo/nbfx"
+)
+
+func roundTrip(t *testing.T, envelope *soap.Envelope) *soap.Envelope {
+	buf := &bytes.Buffer{}
+	err := envelope.Write(buf, nbfs.NewEncoderWithOptions(nbfx.EncoderOptions{Strategy: nbfx.WCFCompatible}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := soap.ReadEnvelope(buf, nbfs.NewDecoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+func passwords(username string) (string, bool) {
+	if username == "user" {
+		return "secret", true
+	}
+	return "", false
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	header := NewHeader("user", "secret")
+	envelope := soap.NewEnvelope(soap.Soap12)
+	header.Apply(envelope)
+
+	read, err := Read(roundTrip(t, envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read == nil || read.Timestamp == nil || read.UsernameToken == nil {
+		t.Fatalf("Expected Timestamp and UsernameToken, got %v", read)
+	}
+	if read.Timestamp.Id != "_0" || !read.Timestamp.Created.Equal(header.Timestamp.Created.Truncate(time.Millisecond)) ||
+		!read.Timestamp.Expires.Equal(header.Timestamp.Expires.Truncate(time.Millisecond)) {
+		t.Errorf("%v not equal to expected %v", read.Timestamp, header.Timestamp)
+	}
+	token := read.UsernameToken
+	if token.Id != header.UsernameToken.Id || token.Username != "user" || token.Password != "secret" || token.Digest {
+		t.Errorf("Unexpected UsernameToken %v", token)
+	}
+}
+
+func TestHeaderUsesDictionary(t *testing.T) {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	NewHeader("user", "secret").Apply(envelope)
+	buf := &bytes.Buffer{}
+	err := envelope.Write(buf, nbfs.NewEncoder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{SecextNamespace, UtilityNamespace, "Security", "Timestamp", "UsernameToken"} {
+		if bytes.Contains(buf.Bytes(), []byte(text)) {
+			t.Errorf("Expected %s to be encoded from the dictionary", text)
+		}
+	}
+}
+
+func TestApplyMustUnderstand(t *testing.T) {
+	envelope := soap.NewEnvelope(soap.Soap11)
+	NewHeader("user", "secret").Apply(envelope)
+	security := envelope.Header.Get(secext("Security"))
+	if value, _ := security.AttrValue(xml.Name{Space: soap.Soap11.Namespace(), Local: "mustUnderstand"}); value != "1" {
+		t.Error("Expected Security header to be mustUnderstand")
+	}
+	if security.Children[0].Name != utility("Timestamp") {
+		t.Error("Expected Timestamp first")
+	}
+}
+
+func TestReadNoHeader(t *testing.T) {
+	h, err := Read(soap.NewEnvelope(soap.Soap12))
+	if h != nil || err != nil {
+		t.Errorf("Expected no header and no error, got %v, %v", h, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	envelope := soap.NewEnvelope(soap.Soap12)
+	NewHeader("user", "secret").Apply(envelope)
+	v := &Validator{Password: passwords, RequireTimestamp: true}
+	h, err := v.Validate(roundTrip(t, envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.UsernameToken.Username != "user" {
+		t.Errorf("Unexpected username %s", h.UsernameToken.Username)
+	}
+}
+
+func TestValidateDigest(t *testing.T) {
+	header, err := NewDigestHeader("user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := soap.NewEnvelope(soap.Soap12)
+	header.Apply(envelope)
+	read := roundTrip(t, envelope)
+
+	token := read.Header.Get(secext("Security")).Child(secext("UsernameToken"))
+	if password := token.Child(secext("Password")); password.Text == "secret" {
+		t.Error("Expected password to be sent as a digest")
+	}
+	_, err = (&Validator{Password: passwords}).Validate(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = (&Validator{Password: func(string) (string, bool) { return "other", true }}).Validate(read)
+	assertCode(t, err, FailedAuthentication)
+}
+
+func TestValidateDigestReplay(t *testing.T) {
+	header, err := NewDigestHeader("user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := soap.NewEnvelope(soap.Soap12)
+	header.Apply(envelope)
+	read := roundTrip(t, envelope)
+
+	now := header.UsernameToken.Created
+	v := &Validator{Password: passwords, Nonces: &MemoryNonceCache{}, Now: func() time.Time { return now }}
+	if _, err = v.Validate(read); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(DefaultTimeToLive)
+	_, err = v.Validate(read)
+	assertCode(t, err, FailedAuthentication)
+
+	// a token with a wrong password does not use up the Nonce
+	other, _ := NewDigestHeader("user", "secret")
+	forged := soap.NewEnvelope(soap.Soap12)
+	other.Apply(forged)
+	forgedToken := forged.Header.Get(secext("Security")).Child(secext("UsernameToken"))
+	forgedToken.Child(secext("Password")).Text = "forged"
+	_, err = v.Validate(roundTrip(t, forged))
+	assertCode(t, err, FailedAuthentication)
+	genuine := soap.NewEnvelope(soap.Soap12)
+	other.Apply(genuine)
+	if _, err = v.Validate(roundTrip(t, genuine)); err != nil {
+		t.Errorf("Expected the Nonce of a rejected token to stay unused, got %v", err)
+	}
+}
+
+func TestValidateDigestReplayWithoutCache(t *testing.T) {
+	header, err := NewDigestHeader("user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := soap.NewEnvelope(soap.Soap12)
+	header.Apply(envelope)
+	read := roundTrip(t, envelope)
+
+	v := &Validator{Password: passwords}
+	if _, err = v.Validate(read); err != nil {
+		t.Fatal(err)
+	}
+	_, err = v.Validate(read)
+	assertCode(t, err, FailedAuthentication)
+}
+
+func TestMemoryNonceCache(t *testing.T) {
+	c := &MemoryNonceCache{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if c.Seen("a", now, now.Add(time.Minute)) || !c.Seen("a", now.Add(time.Minute), now.Add(2*time.Minute)) {
+		t.Error("Expected a Nonce to be seen until it expires")
+	}
+	if c.Seen("a", now.Add(time.Minute+time.Second), now.Add(3*time.Minute)) {
+		t.Error("Expected an expired Nonce to be accepted again")
+	}
+	c.Seen("b", now, now.Add(time.Second))
+	c.Seen("c", now.Add(3*nonceSweepInterval), now.Add(4*nonceSweepInterval))
+	if _, ok := c.expires["b"]; ok {
+		t.Error("Expected expired Nonces to be dropped")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	// Base64(SHA-1(Nonce + Created + Password)) as computed by the Username Token Profile 1.0
+	token := &UsernameToken{
+		Nonce:   []byte("\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10"),
+		created: "2003-07-16T01:24:32Z",
+	}
+	expected := "Ss9tPeo8vESAkbcLKELNJaVW4Iw="
+	if actual := token.digest("secret"); actual != expected {
+		t.Errorf("%s not equal to expected %s", actual, expected)
+	}
+}
+
+func assertCode(t *testing.T, err error, code string) {
+	t.Helper()
+	securityErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+	if securityErr.Code != code {
+		t.Errorf("Code %s not equal to expected %s", securityErr.Code, code)
+	}
+}
+
+func TestValidateFailures(t *testing.T) {
+	now := time.Now()
+	expired := soap.NewEnvelope(soap.Soap12)
+	Header{Timestamp: &Timestamp{Created: now.Add(-time.Hour), Expires: now.Add(-55 * time.Minute)}}.Apply(expired)
+	future := soap.NewEnvelope(soap.Soap12)
+	Header{Timestamp: &Timestamp{Created: now.Add(time.Hour)}}.Apply(future)
+	noTimestamp := soap.NewEnvelope(soap.Soap12)
+	Header{UsernameToken: &UsernameToken{Username: "user", Password: "secret"}}.Apply(noTimestamp)
+	wrongPassword := soap.NewEnvelope(soap.Soap12)
+	NewHeader("user", "guess").Apply(wrongPassword)
+	unknownUser := soap.NewEnvelope(soap.Soap12)
+	NewHeader("nobody", "secret").Apply(unknownUser)
+	noToken := soap.NewEnvelope(soap.Soap12)
+	Header{Timestamp: &Timestamp{Created: now}}.Apply(noToken)
+
+	tests := []struct {
+		name     string
+		envelope *soap.Envelope
+		code     string
+	}{
+		{"no header", soap.NewEnvelope(soap.Soap12), InvalidSecurity},
+		{"expired", expired, MessageExpired},
+		{"future", future, MessageExpired},
+		{"no timestamp", noTimestamp, InvalidSecurity},
+		{"wrong password", wrongPassword, FailedAuthentication},
+		{"unknown user", unknownUser, FailedAuthentication},
+		{"no token", noToken, InvalidSecurity},
+	}
+	v := &Validator{Password: passwords, RequireTimestamp: true}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := v.Validate(roundTrip(t, test.envelope))
+			assertCode(t, err, test.code)
+		})
+	}
+}
+
+func TestValidateClockSkew(t *testing.T) {
+	created := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	envelope := soap.NewEnvelope(soap.Soap12)
+	Header{Timestamp: &Timestamp{Created: created, Expires: created.Add(DefaultTimeToLive)}}.Apply(envelope)
+	v := &Validator{Now: func() time.Time { return created.Add(DefaultTimeToLive + time.Minute) }}
+	_, err := v.Validate(envelope)
+	if err != nil {
+		t.Errorf("Expected message within the clock skew to be valid, got %s", err.Error())
+	}
+	v.MaxClockSkew = time.Second
+	_, err = v.Validate(envelope)
+	assertCode(t, err, MessageExpired)
+}
+
+func TestErrorFault(t *testing.T) {
+	err := &Error{Code: FailedAuthentication, Reason: "Unknown username or incorrect password"}
+	fault := err.Fault(soap.Soap12)
+	if fault.Code.Local != "Sender" || len(fault.Subcodes) != 1 || fault.Subcodes[0] != secext(FailedAuthentication) {
+		t.Errorf("Unexpected SOAP 1.2 fault %v", fault)
+	}
+	if fault := err.Fault(soap.Soap11); fault.Code != secext(FailedAuthentication) {
+		t.Errorf("Unexpected SOAP 1.1 fault %v", fault)
+	}
+
+	envelope := soap.NewEnvelope(soap.Soap12)
+	envelope.Body.Fault = fault
+	faultErr := roundTrip(t, envelope).Err()
+	if faultErr == nil || !strings.Contains(faultErr.Error(), "FailedAuthentication") {
+		t.Errorf("Expected FailedAuthentication fault, got %v", faultErr)
+	}
+}
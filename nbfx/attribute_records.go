@@ -33,6 +33,19 @@ func (r *attributeRecordBase) encodeAttribute(e *encoder, attr xml.Attr) error {
 	return errors.New(fmt.Sprint("NotImplemented: encodeAttribute on", r))
 }
 
+// readAttributeText reads the text record holding the value of an attribute
+func readAttributeText(d *decoder) (string, error) {
+	id, err := readByte(d.bin)
+	if err != nil {
+		return "", err
+	}
+	rec, ok := records[id].(textRecordDecoder)
+	if !ok {
+		return "", fmt.Errorf("Invalid attribute value record %#x", id)
+	}
+	return rec.readText(d)
+}
+
 //(0x04)
 type shortAttributeRecord struct {
 	attributeRecordBase
@@ -47,12 +60,7 @@ func (r *shortAttributeRecord) decodeAttribute(d *decoder) (xml.Attr, error) {
 	if err != nil {
 		return xml.Attr{}, err
 	}
-	record, err := getNextRecord(d)
-	if err != nil {
-		return xml.Attr{}, err
-	}
-	textReader := record.(textRecordDecoder)
-	text, err := textReader.readText(d)
+	text, err := readAttributeText(d)
 	if err != nil {
 		return xml.Attr{}, err
 	}
@@ -70,7 +78,7 @@ func (r *shortAttributeRecord) encodeAttribute(e *encoder, attr xml.Attr) error
 		return err
 	}
 
-	rec, err := e.getTextRecordFromText(attr.Value, false)
+	rec, err := e.getAttributeTextRecord(attr)
 	if err != nil {
 		return err
 	}
@@ -97,12 +105,7 @@ func (r *attributeRecord) decodeAttribute(d *decoder) (xml.Attr, error) {
 	if err != nil {
 		return xml.Attr{}, err
 	}
-	rec, err := getNextRecord(d)
-	if err != nil {
-		return xml.Attr{}, err
-	}
-	textReader := rec.(textRecordDecoder)
-	text, err := textReader.readText(d)
+	text, err := readAttributeText(d)
 	if err != nil {
 		return xml.Attr{}, err
 	}
@@ -126,7 +129,7 @@ func (r *attributeRecord) encodeAttribute(e *encoder, attr xml.Attr) error {
 		return err
 	}
 
-	rec, err := e.getTextRecordFromText(attr.Value, false)
+	rec, err := e.getAttributeTextRecord(attr)
 	if err != nil {
 		return err
 	}
@@ -149,12 +152,7 @@ func (r *shortDictionaryAttributeRecord) decodeAttribute(d *decoder) (xml.Attr,
 	if err != nil {
 		return xml.Attr{}, err
 	}
-	rec, err := getNextRecord(d)
-	if err != nil {
-		return xml.Attr{}, err
-	}
-	textReader := rec.(textRecordDecoder)
-	text, err := textReader.readText(d)
+	text, err := readAttributeText(d)
 	if err != nil {
 		return xml.Attr{}, err
 	}
@@ -173,7 +171,7 @@ func (r *shortDictionaryAttributeRecord) encodeAttribute(e *encoder, attr xml.At
 		return err
 	}
 
-	rec, err := e.getTextRecordFromText(attr.Value, false)
+	rec, err := e.getAttributeTextRecord(attr)
 	if err != nil {
 		return err
 	}
@@ -200,12 +198,7 @@ func (r *dictionaryAttributeRecord) decodeAttribute(d *decoder) (xml.Attr, error
 	if err != nil {
 		return xml.Attr{}, err
 	}
-	rec, err := getNextRecord(d)
-	if err != nil {
-		return xml.Attr{}, err
-	}
-	textReader := rec.(textRecordDecoder)
-	text, err := textReader.readText(d)
+	text, err := readAttributeText(d)
 	if err != nil {
 		return xml.Attr{}, err
 	}
@@ -229,7 +222,7 @@ func (r *dictionaryAttributeRecord) encodeAttribute(e *encoder, attr xml.Attr) e
 		return err
 	}
 
-	rec, err := e.getTextRecordFromText(attr.Value, false)
+	rec, err := e.getAttributeTextRecord(attr)
 	if err != nil {
 		return err
 	}
@@ -371,15 +364,7 @@ func (r *prefixDictionaryAttributeAZRecord) decodeAttribute(d *decoder) (xml.Att
 	if err != nil {
 		return xml.Attr{}, err
 	}
-	record, err := getNextRecord(d)
-	if err != nil {
-		return xml.Attr{}, err
-	}
-	textRecord := record.(textRecordDecoder)
-	if textRecord == nil {
-		return xml.Attr{}, errors.New("Expected TextRecord")
-	}
-	text, err := textRecord.readText(d)
+	text, err := readAttributeText(d)
 	if err != nil {
 		return xml.Attr{}, err
 	}
@@ -395,7 +380,7 @@ func (r *prefixDictionaryAttributeAZRecord) encodeAttribute(e *encoder, attr xml
 	if err != nil {
 		return err
 	}
-	textRecord, err := e.getTextRecordFromText(attr.Value, false)
+	textRecord, err := e.getAttributeTextRecord(attr)
 	if err != nil {
 		return err
 	}
@@ -413,15 +398,7 @@ func (r *prefixAttributeAZRecord) decodeAttribute(d *decoder) (xml.Attr, error)
 	if err != nil {
 		return xml.Attr{}, err
 	}
-	record, err := getNextRecord(d)
-	if err != nil {
-		return xml.Attr{}, err
-	}
-	textRecord := record.(textRecordDecoder)
-	if textRecord == nil {
-		return xml.Attr{}, errors.New("Expected TextRecord")
-	}
-	text, err := textRecord.readText(d)
+	text, err := readAttributeText(d)
 	if err != nil {
 		return xml.Attr{}, err
 	}
@@ -437,7 +414,7 @@ func (r *prefixAttributeAZRecord) encodeAttribute(e *encoder, attr xml.Attr) err
 	if err != nil {
 		return err
 	}
-	textRecord, err := e.getTextRecordFromText(attr.Value, false)
+	textRecord, err := e.getAttributeTextRecord(attr)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,121 @@
+package nbfx
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// binReader reads NBFX primitives straight out of a byte slice.
+//
+// Slices returned by next are views into the underlying buffer rather than
+// copies, so callers must convert or copy them before the buffer is reused.
+// A binReader with a src refills the buffer as it is read, reusing it, so
+// views are only valid until the next read.
+type binReader struct {
+	buf []byte
+	off int
+	src io.Reader
+	err error
+	// minimal rejects MultiByteInt31s written with more bytes than needed
+	minimal bool
+}
+
+// minBinReaderFill is the least a streaming binReader reads from its src at once
+const minBinReaderFill = 4096
+
+func newBinReader(b []byte) *binReader {
+	return &binReader{buf: b}
+}
+
+// newStreamBinReader creates a binReader holding only the bytes of src that are being read
+func newStreamBinReader(src io.Reader) *binReader {
+	return &binReader{src: src}
+}
+
+// fill reads from src until n bytes are buffered, dropping the bytes already read.
+// It reports whether n bytes are available. The buffer grows as bytes arrive rather than
+// to n at once, so a huge length declared by short input fails without a huge allocation.
+func (b *binReader) fill(n int) bool {
+	if b.src == nil || b.err != nil {
+		return false
+	}
+	unread := copy(b.buf[:cap(b.buf)], b.buf[b.off:])
+	b.buf, b.off = b.buf[:unread], 0
+	for len(b.buf) < n {
+		if len(b.buf) == cap(b.buf) {
+			size := 2 * cap(b.buf)
+			if size > n {
+				size = n
+			}
+			if size < minBinReaderFill {
+				size = minBinReaderFill
+			}
+			buf := make([]byte, len(b.buf), size)
+			copy(buf, b.buf)
+			b.buf = buf
+		}
+		read, err := b.src.Read(b.buf[len(b.buf):cap(b.buf)])
+		b.buf = b.buf[:len(b.buf)+read]
+		if err != nil {
+			b.err = err
+			break
+		}
+	}
+	return len(b.buf) >= n
+}
+
+func (b *binReader) len() int {
+	return len(b.buf) - b.off
+}
+
+func (b *binReader) readByte() (byte, error) {
+	if b.off >= len(b.buf) && !b.fill(1) {
+		return 0, b.eof()
+	}
+	c := b.buf[b.off]
+	b.off++
+	return c, nil
+}
+
+func (b *binReader) next(n uint32) ([]byte, error) {
+	if uint64(n) > uint64(b.len()) && (uint64(n) > math.MaxInt32 || !b.fill(int(n))) {
+		b.off = len(b.buf)
+		return nil, b.eof()
+	}
+	p := b.buf[b.off : b.off+int(n)]
+	b.off += int(n)
+	return p, nil
+}
+
+// eof is the error of a read past the end, which is io.EOF unless reading the src failed
+func (b *binReader) eof() error {
+	if b.err != nil && b.err != io.EOF && b.err != io.ErrUnexpectedEOF {
+		return b.err
+	}
+	return io.EOF
+}
+
+func (b *binReader) readUint16() (uint16, error) {
+	p, err := b.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(p), nil
+}
+
+func (b *binReader) readUint32() (uint32, error) {
+	p, err := b.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(p), nil
+}
+
+func (b *binReader) readUint64() (uint64, error) {
+	p, err := b.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(p), nil
+}
@@ -0,0 +1,374 @@
+package nbfx
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Canonicalizer writes the Exclusive XML Canonicalization (exc-c14n) of XML documents,
+// the form WCF digests and signs messages in
+type Canonicalizer struct {
+	// WithComments keeps comments, as the exc-c14n#WithComments algorithm does
+	WithComments bool
+	// InclusivePrefixes are the prefixes of the InclusiveNamespaces PrefixList, which are rendered
+	// wherever they are in scope rather than only where they are used. "#default" is the default namespace.
+	InclusivePrefixes []string
+}
+
+// Canonicalize writes the canonical form of the XML document read from r to w
+func (c *Canonicalizer) Canonicalize(w io.Writer, r io.Reader) error {
+	decoder := xml.NewDecoder(r)
+	writer := newCanonicalWriter(w, c)
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		err = writer.EncodeToken(token)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// CanonicalizeElement writes the canonical form of the first element of the document read from r
+// that match selects, as the Reference to a document subset is digested when signing. match is called
+// with the namespace-resolved name and attributes of each element until it returns true.
+// Namespaces declared on the ancestors of the element are rendered on it where it uses them.
+func (c *Canonicalizer) CanonicalizeElement(w io.Writer, r io.Reader, match func(name xml.Name, attr []xml.Attr) bool) error {
+	decoder := xml.NewDecoder(r)
+	writer := newCanonicalWriter(w, c)
+	writer.match = match
+	for !writer.done {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return errors.New("No element to canonicalize")
+		} else if err != nil {
+			return err
+		}
+		err = writer.EncodeToken(token)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// tokenWriter is where the decoder writes the XML of the records it reads
+type tokenWriter interface {
+	EncodeToken(t xml.Token) error
+	Flush() error
+}
+
+// canonicalFrame is an open element with the namespaces declared and rendered on it
+type canonicalFrame struct {
+	qname    string
+	declared []nsBinding
+	rendered []nsBinding
+}
+
+// canonicalWriter is a tokenWriter producing exc-c14n output. Names may be raw prefixed names,
+// either as "p:local" in Local or with the prefix in Space, as the decoder and RawToken give them.
+type canonicalWriter struct {
+	w         *bufio.Writer
+	opts      *Canonicalizer
+	stack     []canonicalFrame
+	seenRoot  bool
+	inclusive map[string]bool
+
+	// match selects the element to write, when only one is written.
+	// apex is the depth of that element once it is found, and done is set after its end.
+	match func(name xml.Name, attr []xml.Attr) bool
+	apex  int
+	done  bool
+}
+
+func newCanonicalWriter(w io.Writer, opts *Canonicalizer) *canonicalWriter {
+	c := &canonicalWriter{w: bufio.NewWriter(w), opts: opts, inclusive: map[string]bool{}}
+	for _, prefix := range opts.InclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		c.inclusive[prefix] = true
+	}
+	return c
+}
+
+func rawName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+func splitQName(qname string) (string, string) {
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[:i], qname[i+1:]
+	}
+	return "", qname
+}
+
+// lookup finds the namespace bound to prefix by the declared or the rendered bindings of the open elements
+func (c *canonicalWriter) lookup(prefix string, rendered bool) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		bindings := c.stack[i].declared
+		if rendered {
+			bindings = c.stack[i].rendered
+		}
+		for j := len(bindings) - 1; j >= 0; j-- {
+			if bindings[j].prefix == prefix {
+				return bindings[j].uri, true
+			}
+		}
+	}
+	return "", false
+}
+
+type canonicalAttr struct {
+	qname string
+	space string
+	local string
+	value string
+}
+
+// resolve returns the namespace-resolved name of an element or attribute.
+// Unprefixed attributes are in no namespace rather than the default one.
+func (c *canonicalWriter) resolve(qname string, isElement bool) (xml.Name, error) {
+	prefix, local := splitQName(qname)
+	if prefix == "" && !isElement {
+		return xml.Name{Local: local}, nil
+	}
+	uri, ok := c.lookup(prefix, false)
+	if !ok && prefix != "" {
+		return xml.Name{}, fmt.Errorf("Undeclared prefix %s in %s", prefix, qname)
+	}
+	return xml.Name{Space: uri, Local: local}, nil
+}
+
+// skipToken tracks the namespaces declared outside the element being looked for, writing nothing
+// until match selects an element
+func (c *canonicalWriter) skipToken(token xml.Token) error {
+	switch t := token.(type) {
+	case xml.StartElement:
+		frame := canonicalFrame{qname: rawName(t.Name)}
+		var attrs []xml.Attr
+		for _, attr := range t.Attr {
+			qname := rawName(attr.Name)
+			if qname == "xmlns" {
+				frame.declared = append(frame.declared, nsBinding{"", attr.Value})
+			} else if strings.HasPrefix(qname, "xmlns:") {
+				frame.declared = append(frame.declared, nsBinding{qname[len("xmlns:"):], attr.Value})
+			} else {
+				attrs = append(attrs, xml.Attr{Name: xml.Name{Local: qname}, Value: attr.Value})
+			}
+		}
+		c.stack = append(c.stack, frame)
+		name, err := c.resolve(frame.qname, true)
+		if err != nil {
+			return err
+		}
+		for i := range attrs {
+			attrs[i].Name, err = c.resolve(attrs[i].Name.Local, false)
+			if err != nil {
+				return err
+			}
+		}
+		if c.match(name, attrs) {
+			c.stack = c.stack[:len(c.stack)-1]
+			c.apex = len(c.stack) + 1
+			return c.writeStartElement(t)
+		}
+	case xml.EndElement:
+		if len(c.stack) == 0 {
+			return fmt.Errorf("Unexpected end element %s", rawName(t.Name))
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+	}
+	return nil
+}
+
+func (c *canonicalWriter) EncodeToken(token xml.Token) error {
+	if c.match != nil && c.apex == 0 {
+		return c.skipToken(token)
+	}
+	switch t := token.(type) {
+	case xml.StartElement:
+		return c.writeStartElement(t)
+	case xml.EndElement:
+		if len(c.stack) == 0 {
+			return fmt.Errorf("Unexpected end element %s", rawName(t.Name))
+		}
+		frame := c.stack[len(c.stack)-1]
+		c.stack = c.stack[:len(c.stack)-1]
+		c.w.WriteString("</" + frame.qname + ">")
+		if len(c.stack) < c.apex {
+			c.done = true
+		}
+	case xml.CharData:
+		// text outside the document element is not part of the canonical form
+		if len(c.stack) > 0 {
+			writeCanonicalText(c.w, string(t))
+		}
+	case xml.Comment:
+		if c.opts.WithComments {
+			c.writeOutsideRoot("<!--" + string(t) + "-->")
+		}
+	case xml.ProcInst:
+		if t.Target != "xml" {
+			pi := "<?" + t.Target
+			if len(t.Inst) > 0 {
+				pi += " " + string(t.Inst)
+			}
+			c.writeOutsideRoot(pi + "?>")
+		}
+	}
+	return nil
+}
+
+func (c *canonicalWriter) Flush() error {
+	return c.w.Flush()
+}
+
+// writeOutsideRoot writes a comment or processing instruction, separating the ones
+// before and after the document element from it with a line feed
+func (c *canonicalWriter) writeOutsideRoot(node string) {
+	if len(c.stack) > 0 {
+		c.w.WriteString(node)
+	} else if c.seenRoot {
+		c.w.WriteString("\n" + node)
+	} else {
+		c.w.WriteString(node + "\n")
+	}
+}
+
+func (c *canonicalWriter) writeStartElement(t xml.StartElement) error {
+	c.seenRoot = true
+	frame := canonicalFrame{qname: rawName(t.Name)}
+	var attrs []canonicalAttr
+	for _, attr := range t.Attr {
+		qname := rawName(attr.Name)
+		if qname == "xmlns" {
+			frame.declared = append(frame.declared, nsBinding{"", attr.Value})
+		} else if strings.HasPrefix(qname, "xmlns:") {
+			frame.declared = append(frame.declared, nsBinding{qname[len("xmlns:"):], attr.Value})
+		} else {
+			prefix, local := splitQName(qname)
+			attrs = append(attrs, canonicalAttr{qname: qname, space: prefix, local: local, value: attr.Value})
+		}
+	}
+	c.stack = append(c.stack, frame)
+	top := &c.stack[len(c.stack)-1]
+
+	// the prefixes visibly used by the element and its attributes, and the inclusive ones
+	elementPrefix, _ := splitQName(frame.qname)
+	used := map[string]bool{elementPrefix: true}
+	for i := range attrs {
+		if attrs[i].space == "" {
+			continue
+		}
+		used[attrs[i].space] = true
+		uri, ok := c.lookup(attrs[i].space, false)
+		if !ok {
+			return fmt.Errorf("Undeclared prefix %s in %s", attrs[i].space, attrs[i].qname)
+		}
+		attrs[i].space = uri
+	}
+	for prefix := range c.inclusive {
+		if _, ok := c.lookup(prefix, false); ok {
+			used[prefix] = true
+		}
+	}
+	delete(used, "xml")
+
+	var render []nsBinding
+	for prefix := range used {
+		uri, declared := c.lookup(prefix, false)
+		if !declared && prefix != "" {
+			return fmt.Errorf("Undeclared prefix %s in %s", prefix, frame.qname)
+		}
+		renderedUri, rendered := c.lookup(prefix, true)
+		if prefix == "" && uri == "" {
+			// xmlns="" is only needed to undo a default namespace rendered on an ancestor
+			if rendered && renderedUri != "" {
+				render = append(render, nsBinding{"", ""})
+			}
+		} else if !rendered || renderedUri != uri {
+			render = append(render, nsBinding{prefix, uri})
+		}
+	}
+	sort.Slice(render, func(i, j int) bool { return render[i].prefix < render[j].prefix })
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+	top.rendered = render
+
+	c.w.WriteString("<" + frame.qname)
+	for _, ns := range render {
+		if ns.prefix == "" {
+			c.w.WriteString(` xmlns="`)
+		} else {
+			c.w.WriteString(" xmlns:" + ns.prefix + `="`)
+		}
+		writeCanonicalAttrValue(c.w, ns.uri)
+		c.w.WriteByte('"')
+	}
+	for _, attr := range attrs {
+		c.w.WriteString(" " + attr.qname + `="`)
+		writeCanonicalAttrValue(c.w, attr.value)
+		c.w.WriteByte('"')
+	}
+	c.w.WriteByte('>')
+	return nil
+}
+
+func writeCanonicalText(w *bufio.Writer, text string) {
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; b {
+		case '&':
+			w.WriteString("&amp;")
+		case '<':
+			w.WriteString("&lt;")
+		case '>':
+			w.WriteString("&gt;")
+		case '\r':
+			w.WriteString("&#xD;")
+		default:
+			w.WriteByte(b)
+		}
+	}
+}
+
+func writeCanonicalAttrValue(w *bufio.Writer, value string) {
+	for i := 0; i < len(value); i++ {
+		switch b := value[i]; b {
+		case '&':
+			w.WriteString("&amp;")
+		case '<':
+			w.WriteString("&lt;")
+		case '"':
+			w.WriteString("&quot;")
+		case '\t':
+			w.WriteString("&#x9;")
+		case '\n':
+			w.WriteString("&#xA;")
+		case '\r':
+			w.WriteString("&#xD;")
+		default:
+			w.WriteByte(b)
+		}
+	}
+}
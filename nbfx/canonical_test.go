@@ -0,0 +1,95 @@
+package nbfx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func testCanonicalize(t *testing.T, c *Canonicalizer, expected, xmlString string) {
+	buf := &bytes.Buffer{}
+	err := c.Canonicalize(buf, strings.NewReader(xmlString))
+	if err != nil {
+		t.Error("Unexpected error: " + err.Error())
+	}
+	assertStringEqual(t, buf.String(), expected)
+}
+
+func TestCanonicalizeExclusiveNamespaces(t *testing.T) {
+	testCanonicalize(t, &Canonicalizer{},
+		`<n0:local xmlns:n0="foo:bar"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2></n0:local>`,
+		"<?xml version=\"1.0\"?>\n<!-- comment -->\n<n0:local xmlns:n0=\"foo:bar\" xmlns:n3=\"ftp://example.org\"><n1:elem2 xmlns:n1=\"http://example.net\" xml:lang=\"en\"><n3:stuff/></n1:elem2></n0:local>")
+}
+
+func TestCanonicalizeAttributeOrder(t *testing.T) {
+	testCanonicalize(t, &Canonicalizer{},
+		`<a xmlns:a2="urn:a" xmlns:b="urn:b" c="tab&#x9;" x="&#xD;&quot;&lt;" a2:z="2" b:y="1"></a>`,
+		`<a xmlns:b="urn:b" xmlns:a2="urn:a" b:y="1" a2:z="2" x="&#xD;&quot;&lt;" c="tab&#9;"/>`)
+}
+
+func TestCanonicalizeDefaultNamespace(t *testing.T) {
+	testCanonicalize(t, &Canonicalizer{},
+		`<a xmlns="urn:x"><b xmlns=""><c></c></b><d></d></a>`,
+		`<a xmlns="urn:x"><b xmlns=""><c/></b><d xmlns="urn:x"/></a>`)
+	testCanonicalize(t, &Canonicalizer{},
+		`<a><b></b></a>`,
+		`<a xmlns=""><b xmlns=""/></a>`)
+}
+
+func TestCanonicalizeText(t *testing.T) {
+	testCanonicalize(t, &Canonicalizer{},
+		`<a>&lt;&amp;&gt;&#xD;" '</a>`,
+		`<a>&lt;&amp;&gt;&#xD;" '</a>`)
+}
+
+func TestCanonicalizeComments(t *testing.T) {
+	xmlString := "<!-- before --><a><!-- inside --></a><!-- after -->"
+	testCanonicalize(t, &Canonicalizer{}, `<a></a>`, xmlString)
+	testCanonicalize(t, &Canonicalizer{WithComments: true}, "<!-- before -->\n<a><!-- inside --></a>\n<!-- after -->", xmlString)
+}
+
+func TestCanonicalizeInclusivePrefixes(t *testing.T) {
+	xmlString := `<a xmlns:i="urn:i" xmlns="urn:d"><b>i:x</b></a>`
+	testCanonicalize(t, &Canonicalizer{}, `<a xmlns="urn:d"><b>i:x</b></a>`, xmlString)
+	testCanonicalize(t, &Canonicalizer{InclusivePrefixes: []string{"i"}}, `<a xmlns="urn:d" xmlns:i="urn:i"><b>i:x</b></a>`, xmlString)
+}
+
+func TestCanonicalizeUndeclaredPrefix(t *testing.T) {
+	err := (&Canonicalizer{}).Canonicalize(&bytes.Buffer{}, strings.NewReader(`<p:a></p:a>`))
+	if err == nil {
+		t.Error("Expected an error for an undeclared prefix")
+	}
+}
+
+func TestCanonicalizeElement(t *testing.T) {
+	xmlString := `<s:Envelope xmlns:s="urn:s" xmlns:u="urn:u" xmlns="urn:d"><s:Header><h u:Id="h"/></s:Header><s:Body u:Id="b"><p:x xmlns:p="urn:p"><y/></p:x></s:Body></s:Envelope>`
+	byId := func(id string) func(xml.Name, []xml.Attr) bool {
+		return func(name xml.Name, attr []xml.Attr) bool {
+			for _, a := range attr {
+				if a.Name == (xml.Name{Space: "urn:u", Local: "Id"}) && a.Value == id {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	buf := &bytes.Buffer{}
+	err := (&Canonicalizer{}).CanonicalizeElement(buf, strings.NewReader(xmlString), byId("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, buf.String(), `<s:Body xmlns:s="urn:s" xmlns:u="urn:u" u:Id="b"><p:x xmlns:p="urn:p"><y xmlns="urn:d"></y></p:x></s:Body>`)
+
+	buf.Reset()
+	err = (&Canonicalizer{}).CanonicalizeElement(buf, strings.NewReader(xmlString), byId("h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, buf.String(), `<h xmlns="urn:d" xmlns:u="urn:u" u:Id="h"></h>`)
+
+	err = (&Canonicalizer{}).CanonicalizeElement(buf, strings.NewReader(xmlString), byId("missing"))
+	if err == nil {
+		t.Error("Expected an error when no element matches")
+	}
+}
@@ -0,0 +1,146 @@
+package nbfx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// newXmlDecoder creates the xml.Decoder an encoder reads its input with. UTF-16 input, found by
+// its byte order mark or by a '<' next to a zero byte, is transcoded to UTF-8, and the encoding
+// of the XML declaration is honoured for UTF-16, ISO-8859-1 and US-ASCII.
+func newXmlDecoder(reader io.Reader) *xml.Decoder {
+	in := bufio.NewReader(reader)
+	var order binary.ByteOrder
+	head, _ := in.Peek(3)
+	switch {
+	case len(head) >= 2 && head[0] == 0xFE && head[1] == 0xFF:
+		in.Discard(2)
+		order = binary.BigEndian
+	case len(head) >= 2 && head[0] == 0xFF && head[1] == 0xFE:
+		in.Discard(2)
+		order = binary.LittleEndian
+	case len(head) >= 2 && head[0] == 0 && head[1] == '<':
+		order = binary.BigEndian
+	case len(head) >= 2 && head[0] == '<' && head[1] == 0:
+		order = binary.LittleEndian
+	case len(head) == 3 && head[0] == 0xEF && head[1] == 0xBB && head[2] == 0xBF:
+		in.Discard(3)
+	}
+	var decoder *xml.Decoder
+	if order != nil {
+		decoder = xml.NewDecoder(&utf16Reader{r: in, order: order})
+	} else {
+		decoder = xml.NewDecoder(in)
+	}
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-16", "utf-16le", "utf-16be", "unicode":
+			if order == nil {
+				return nil, fmt.Errorf("Input declared as %s is not UTF-16", charset)
+			}
+			return input, nil
+		case "iso-8859-1", "iso8859-1", "latin1", "l1":
+			if order != nil {
+				return nil, fmt.Errorf("UTF-16 input declared as %s", charset)
+			}
+			return &latin1Reader{r: input}, nil
+		case "us-ascii", "ascii":
+			if order != nil {
+				return nil, fmt.Errorf("UTF-16 input declared as %s", charset)
+			}
+			return input, nil
+		}
+		return nil, fmt.Errorf("Unsupported encoding %s", charset)
+	}
+	return decoder
+}
+
+// utf16Reader transcodes UTF-16 to UTF-8, replacing unpaired surrogates with U+FFFD
+type utf16Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	unit  [2]byte
+	// next is a code unit read after a high surrogate that didn't pair with it
+	next    uint16
+	hasNext bool
+	out     []byte
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.out) < len(p) {
+		r, err := u.readRune()
+		if err != nil {
+			if len(u.out) > 0 {
+				break
+			}
+			return 0, err
+		}
+		u.out = utf8.AppendRune(u.out, r)
+	}
+	n := copy(p, u.out)
+	u.out = u.out[:copy(u.out, u.out[n:])]
+	return n, nil
+}
+
+func (u *utf16Reader) readUnit() (uint16, error) {
+	if u.hasNext {
+		u.hasNext = false
+		return u.next, nil
+	}
+	if _, err := io.ReadFull(u.r, u.unit[:]); err != nil {
+		return 0, err
+	}
+	return u.order.Uint16(u.unit[:]), nil
+}
+
+func (u *utf16Reader) readRune() (rune, error) {
+	c, err := u.readUnit()
+	if err != nil {
+		return 0, err
+	}
+	if !utf16.IsSurrogate(rune(c)) {
+		return rune(c), nil
+	}
+	low, err := u.readUnit()
+	if err == io.EOF {
+		return utf8.RuneError, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if r := utf16.DecodeRune(rune(c), rune(low)); r != utf8.RuneError {
+		return r, nil
+	}
+	u.next, u.hasNext = low, true
+	return utf8.RuneError, nil
+}
+
+// latin1Reader transcodes ISO-8859-1 to UTF-8
+type latin1Reader struct {
+	r   io.Reader
+	buf []byte
+	out []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	if len(l.out) == 0 {
+		if cap(l.buf) < len(p) {
+			l.buf = make([]byte, len(p))
+		}
+		n, err := l.r.Read(l.buf[:len(p)])
+		for _, b := range l.buf[:n] {
+			l.out = utf8.AppendRune(l.out, rune(b))
+		}
+		if n == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, l.out)
+	l.out = l.out[:copy(l.out, l.out[n:])]
+	return n, nil
+}
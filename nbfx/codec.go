@@ -4,13 +4,18 @@
 package nbfx
 
 import (
+	"bytes"
 	"encoding/base64"
 	"io"
+	"sync"
 )
 
 // Encoder is the interface for encoding NBFX
 type Encoder interface {
 	Encode(io.Reader) ([]byte, error)
+	// EncodeTo writes the records to the writer as it encodes them, so on error
+	// the writer may have been given the start of the message
+	EncodeTo(io.Writer, io.Reader) error
 }
 
 // Decoder is the interface for decoding NBFX
@@ -23,6 +28,24 @@ const maskMbi31 = uint32(0x80) //0x80 = 128
 
 var b64 = base64.StdEncoding.WithPadding(base64.StdPadding)
 
+// buffers larger than this are left for the garbage collector rather than pooled
+const maxPooledBufferSize = 64 << 20
+
+var bufferPool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
 const (
 	endElement                        byte = 0x01
 	comment                           byte = 0x02
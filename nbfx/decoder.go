@@ -1,68 +1,79 @@
 package nbfx
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math"
-	. "math/big"
-	"regexp"
+	"strconv"
 	"strings"
-	"time"
-
-	"github.com/satori/go.uuid"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type decoder struct {
 	dict         map[uint32]string
+	opts         DecoderOptions
 	elementStack stack
-	bin          io.Reader
-	xml          *xml.Encoder
-}
-
-func (d *decoder) addDictionaryString(index uint32, value string) {
-	if _, ok := d.dict[index]; ok {
-		return
-	}
-	d.dict[index] = value
+	bin          *binReader
+	xml          tokenWriter
+	charData     []byte
+	// ns tracks the namespace declarations in scope when decoding strictly
+	ns nsScope
 }
 
 // NewDecoder creates a new NBFX Decoder
 func NewDecoder() Decoder {
-	return NewDecoderWithStrings(nil)
+	return NewDecoderWithDictionary(nil)
 }
 
 // NewDecoderWithStrings creates a new NBFX Decoder with a dictionary (like an NBFS dictionary)
 func NewDecoderWithStrings(dictionaryStrings map[uint32]string) Decoder {
-	decoder := &decoder{dict: map[uint32]string{}}
-	if dictionaryStrings != nil {
-		for k, v := range dictionaryStrings {
-			decoder.addDictionaryString(k, v)
-		}
+	return NewDecoderWithDictionary(NewDictionary(dictionaryStrings))
+}
+
+// NewDecoderWithDictionary creates a new NBFX Decoder sharing a precomputed Dictionary
+func NewDecoderWithDictionary(dictionary *Dictionary) Decoder {
+	return NewDecoderWithOptions(dictionary, DecoderOptions{})
+}
+
+// NewDecoderWithOptions creates a new NBFX Decoder sharing a precomputed Dictionary,
+// with options controlling the XML it writes
+func NewDecoderWithOptions(dictionary *Dictionary, opts DecoderOptions) Decoder {
+	if dictionary == nil {
+		dictionary = NewDictionary(nil)
 	}
-	return decoder
+	return &decoder{dict: dictionary.strings, opts: opts}
 }
 
 func (d *decoder) Decode(reader io.Reader) (string, error) {
-	// Use ioutil to read data from reader because if we try to read
+	// Read all data from reader up front because if we try to read
 	//  this manually, we can run into edge cases where the data we get
 	//  back is unreliable and can contain extra unnecessary information
 	//  as observed when reading through http response body containing
 	//  extra zeros in sets of four.
-	// This also seems to increase memory allocation efficiency
+	// This also lets records be decoded straight out of the buffer
+	//  without copying each primitive.
 	// It is challenging to write a test for this bug as we haven't fully
 	//  understood what the root cause for the extra zeros is.
-	bytesRead, err := ioutil.ReadAll(reader)
+	binBuf := getBuffer()
+	defer putBuffer(binBuf)
+	_, err := binBuf.ReadFrom(reader)
 	if err != nil {
 		return "", err
 	}
-	d.bin = bytes.NewBuffer(bytesRead)
-	xmlBuf := &bytes.Buffer{}
-	d.xml = xml.NewEncoder(xmlBuf)
+	d.bin = newBinReader(binBuf.Bytes())
+	d.elementStack.reset()
+	d.ns.reset()
+	xmlBuf := getBuffer()
+	defer putBuffer(xmlBuf)
+	if d.opts.Canonical {
+		d.xml = newCanonicalWriter(xmlBuf, &Canonicalizer{WithComments: d.opts.WithComments})
+	} else {
+		d.xml = xml.NewEncoder(xmlBuf)
+	}
 	rec, err := getNextRecord(d)
 	for err == nil && rec != nil {
 		if rec.isStartElement() || rec.isEndElement() {
@@ -72,44 +83,90 @@ func (d *decoder) Decode(reader io.Reader) (string, error) {
 			textReader := rec.(textRecordDecoder)
 			_, err = textReader.decodeText(d, textReader)
 			rec = nil
+		} else if rec.isAttribute() {
+			err = fmt.Errorf("%s outside an element record", rec.getName())
 		} else {
 			err = errors.New(fmt.Sprint("NotSupported: Decode record", rec))
 		}
+		if err == io.EOF && d.opts.Strict {
+			// only the first byte of a record may be at the end of the document
+			err = io.ErrUnexpectedEOF
+		}
 		if err == nil && rec == nil {
 			rec, err = getNextRecord(d)
 		}
 	}
+	if (err == nil || err == io.EOF) && d.opts.Strict && d.elementStack.size > 0 {
+		err = io.ErrUnexpectedEOF
+	}
 	d.xml.Flush()
 	if err != nil && err != io.EOF {
+		if d.opts.Strict {
+			return "", err
+		}
 		return xmlBuf.String(), err
 	}
 	return xmlBuf.String(), nil
 }
 
-func readMultiByteInt31(reader io.Reader) (uint32, error) {
-	b, err := readByte(reader)
-	if uint32(b) < maskMbi31 {
-		return uint32(b), err
+// checkStartElement declares the namespaces of element and checks that its prefixes are declared
+// and its attributes unique, when decoding strictly
+func (d *decoder) checkStartElement(element xml.StartElement) error {
+	attrs := make([]xml.Attr, len(element.Attr))
+	for i, attr := range element.Attr {
+		attrs[i] = xml.Attr{Name: splitRawName(attr.Name.Local), Value: attr.Value}
+	}
+	d.ns.push(attrs)
+	if prefix := splitRawName(element.Name.Local).Space; prefix != "" {
+		if _, ok := d.ns.lookup(prefix); !ok {
+			return fmt.Errorf("Prefix %s of element %s is not declared", prefix, element.Name.Local)
+		}
 	}
-	nextB, err := readMultiByteInt31(reader)
-	return maskMbi31*(nextB-1) + uint32(b), err
+	seen := make(map[xml.Name]bool, len(attrs))
+	for i, attr := range attrs {
+		name := attr.Name
+		if name.Space != "xmlns" && name != (xml.Name{Local: "xmlns"}) {
+			if _, ok := d.ns.lookup(name.Space); !ok {
+				return fmt.Errorf("Prefix %s of attribute %s is not declared", name.Space, element.Attr[i].Name.Local)
+			}
+			name = d.ns.resolve(name, true)
+		}
+		if seen[name] {
+			return fmt.Errorf("Duplicate attribute %s on element %s", element.Attr[i].Name.Local, element.Name.Local)
+		}
+		seen[name] = true
+	}
+	return nil
 }
 
-func readByte(reader io.Reader) (byte, error) {
-	sb := make([]byte, 1)
-	b, err := reader.Read(sb)
-	if b > 0 {
-		err = nil
+func readMultiByteInt31(reader *binReader) (uint32, error) {
+	var val uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := reader.readByte()
+		if err != nil {
+			return val, err
+		}
+		val |= uint32(b&0x7F) << shift
+		if uint32(b) < maskMbi31 {
+			if b == 0 && shift > 0 && reader.minimal {
+				return val, errors.New("MultiByteInt31 written with more bytes than needed")
+			}
+			return val, nil
+		}
 	}
-	return sb[0], err
+	return val, errors.New("MultiByteInt31 longer than 5 bytes")
 }
 
-func readStringBytes(reader io.Reader, len uint32) (string, error) {
-	buf, err := readBytes(reader, len)
-	return buf.String(), err
+func readByte(reader *binReader) (byte, error) {
+	return reader.readByte()
 }
 
-func readString(reader io.Reader) (string, error) {
+func readStringBytes(reader *binReader, len uint32) (string, error) {
+	buf, err := reader.next(len)
+	return string(buf), err
+}
+
+func readString(reader *binReader) (string, error) {
 	length, err := readMultiByteInt31(reader)
 	if err != nil {
 		return "", err
@@ -118,257 +175,166 @@ func readString(reader io.Reader) (string, error) {
 }
 
 func readBytes8Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 1)
-	if err != nil {
-		return "", err
-	}
-	var val uint8
-	err = binary.Read(buf, binary.LittleEndian, &val)
+	val, err := d.bin.readByte()
 	if err != nil {
 		return "", err
 	}
-	buf, err = readBytes(d.bin, uint32(val))
-	return b64.EncodeToString(buf.Bytes()), err
+	return readBase64Bytes(d.bin, uint32(val))
 }
 
 func readBytes16Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 2)
+	val, err := d.bin.readUint16()
 	if err != nil {
 		return "", err
 	}
-	var val uint16
-	err = binary.Read(buf, binary.LittleEndian, &val)
-	if err != nil {
-		return "", err
-	}
-	buf, err = readBytes(d.bin, uint32(val))
-	return b64.EncodeToString(buf.Bytes()), err
+	return readBase64Bytes(d.bin, uint32(val))
 }
 
 func readBytes32Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 4)
+	val, err := d.bin.readUint32()
 	if err != nil {
 		return "", err
 	}
-	var val uint32
-	err = binary.Read(buf, binary.LittleEndian, &val)
+	return readBase64Bytes(d.bin, val)
+}
+
+func readBase64Bytes(reader *binReader, len uint32) (string, error) {
+	buf, err := reader.next(len)
 	if err != nil {
 		return "", err
 	}
-	buf, err = readBytes(d.bin, val)
-	return b64.EncodeToString(buf.Bytes()), err
+	return b64.EncodeToString(buf), nil
 }
 
 func readChars8Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 1)
+	val, err := d.bin.readByte()
 	if err != nil {
 		return "", err
 	}
-	var val uint8
-	binary.Read(buf, binary.LittleEndian, &val)
-
 	return readStringBytes(d.bin, uint32(val))
 }
 
 func readChars16Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 2)
+	val, err := d.bin.readUint16()
 	if err != nil {
 		return "", err
 	}
-	var val uint16
-	binary.Read(buf, binary.LittleEndian, &val)
-
 	return readStringBytes(d.bin, uint32(val))
 }
 
 func readChars32Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 4)
+	val, err := d.bin.readUint32()
 	if err != nil {
 		return "", err
 	}
-	var val uint32
-	binary.Read(buf, binary.LittleEndian, &val)
-
 	return readStringBytes(d.bin, val)
 }
 
 func readUnicodeChars8Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 1)
+	val, err := d.bin.readByte()
 	if err != nil {
 		return "", err
 	}
-	var val uint8
-	binary.Read(buf, binary.LittleEndian, &val)
-	val /= 2
-
 	return readUnicodeStringBytes(d.bin, uint32(val))
 }
 
 func readUnicodeChars16Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 2)
+	val, err := d.bin.readUint16()
 	if err != nil {
 		return "", err
 	}
-	var val uint16
-	binary.Read(buf, binary.LittleEndian, &val)
-	val /= 2
-
 	return readUnicodeStringBytes(d.bin, uint32(val))
 }
 
 func readUnicodeChars32Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 4)
+	val, err := d.bin.readUint32()
 	if err != nil {
 		return "", err
 	}
-	var val uint32
-	binary.Read(buf, binary.LittleEndian, &val)
-	val /= 2
-
 	return readUnicodeStringBytes(d.bin, val)
 }
 
-func readUnicodeStringBytes(r io.Reader, len uint32) (string, error) {
-	runes := []rune{}
-	for i := uint32(0); i < len; i++ {
-		runeBuf, err := readBytes(r, 2)
-		if err != nil {
-			return string(runes), err
-		}
-		var runeInt uint16
-		binary.Read(runeBuf, binary.LittleEndian, &runeInt)
-		theRune := rune(runeInt)
-		runes = append(runes, theRune)
-	}
-	return string(runes), nil
-}
-
-func readBytes(reader io.Reader, numBytes uint32) (*bytes.Buffer, error) {
-	var err error
-	sb := make([]byte, numBytes)
-	var b int
-	b, err = reader.Read(sb)
-	if b > 0 {
-		err = nil
+// readUnicodeStringBytes reads len bytes of UTF-16LE and returns them as a UTF-8 string
+func readUnicodeStringBytes(r *binReader, numBytes uint32) (string, error) {
+	if numBytes%2 != 0 {
+		// reading one byte less would leave the last one to be taken for a record
+		return "", fmt.Errorf("UnicodeChars text of odd length %d", numBytes)
 	}
+	buf, err := r.next(numBytes)
 	if err != nil {
-		return nil, err
-	}
-
-	buf := bytes.Buffer{}
-	_, err = buf.Write(sb)
-	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	if uint32(b) < numBytes {
-		nextBuf, err := readBytes(reader, numBytes-uint32(b))
-		if err != nil {
-			return &buf, err
-		}
-		_, err = buf.Write(nextBuf.Bytes())
-		if err != nil {
-			return &buf, err
+	var sb strings.Builder
+	sb.Grow(len(buf) / 2)
+	for i := 0; i < len(buf); i += 2 {
+		r1 := rune(binary.LittleEndian.Uint16(buf[i:]))
+		if utf16.IsSurrogate(r1) && i+3 < len(buf) {
+			r2 := rune(binary.LittleEndian.Uint16(buf[i+2:]))
+			if dec := utf16.DecodeRune(r1, r2); dec != utf8.RuneError {
+				sb.WriteRune(dec)
+				i += 2
+				continue
+			}
 		}
+		sb.WriteRune(r1)
 	}
-
-	return &buf, nil
+	return sb.String(), nil
 }
 
 func readInt8Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 1)
+	b, err := d.bin.readByte()
 	if err != nil {
 		return "", err
 	}
-	var val int8
-	binary.Read(buf, binary.LittleEndian, &val)
-	return fmt.Sprintf("%d", val), nil
+	return strconv.FormatInt(int64(int8(b)), 10), nil
 }
 
 func readInt16Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 2)
+	val, err := d.bin.readUint16()
 	if err != nil {
 		return "", err
 	}
-	var val int16
-	binary.Read(buf, binary.LittleEndian, &val)
-	return fmt.Sprintf("%d", val), nil
+	return strconv.FormatInt(int64(int16(val)), 10), nil
 }
 
 func readInt32Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 4)
+	val, err := d.bin.readUint32()
 	if err != nil {
 		return "", err
 	}
-	var val int32
-	binary.Read(buf, binary.LittleEndian, &val)
-	return fmt.Sprintf("%d", val), nil
+	return strconv.FormatInt(int64(int32(val)), 10), nil
 }
 
 func readInt64Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 8)
+	val, err := d.bin.readUint64()
 	if err != nil {
 		return "", err
 	}
-	var val int64
-	binary.Read(buf, binary.LittleEndian, &val)
-	return fmt.Sprintf("%d", val), nil
+	return strconv.FormatInt(int64(val), 10), nil
 }
 
 func readUInt64Text(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 8)
+	val, err := d.bin.readUint64()
 	if err != nil {
 		return "", err
 	}
-	var val uint64
-	binary.Read(buf, binary.LittleEndian, &val)
-	return fmt.Sprintf("%d", val), nil
+	return strconv.FormatUint(val, 10), nil
 }
 
 func readFloatText(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 4)
+	bits, err := d.bin.readUint32()
 	if err != nil {
 		return "", err
 	}
-	var val float32
-	binary.Read(buf, binary.LittleEndian, &val)
-	if val == float32(math.Inf(1)) {
-		return "INF", nil
-	} else if val == float32(math.Inf(-1)) {
-		return "-INF", nil
-	}
-	return fmt.Sprintf("%v", val), nil
+	return FormatFloat(float64(math.Float32frombits(bits)), 32), nil
 }
 
 func readDoubleText(d *decoder) (string, error) {
-	var err error
-	buf, err := readBytes(d.bin, 8)
+	bits, err := d.bin.readUint64()
 	if err != nil {
 		return "", err
 	}
-	var val float64
-	binary.Read(buf, binary.LittleEndian, &val)
-	if val == math.Inf(1) {
-		return "INF", nil
-	} else if val == math.Inf(-1) {
-		return "-INF", nil
-	}
-	return fmt.Sprintf("%v", val), nil
+	return FormatFloat(math.Float64frombits(bits), 64), nil
 }
 
 func readListText(d *decoder) (string, error) {
@@ -395,115 +361,67 @@ func readListText(d *decoder) (string, error) {
 }
 
 func readDecimalText(d *decoder) (string, error) {
-	d.bin.Read(make([]byte, 2)) // wReserved - ignored
-
-	// scale - range 0 to 28
-	buf, err := readBytes(d.bin, 1)
+	val, err := readDecimal(d)
 	if err != nil {
 		return "", err
 	}
-	var scale byte
-	binary.Read(buf, binary.LittleEndian, &scale)
+	return val.String(), nil
+}
 
-	// sign: 0 = positive, 128 (0x80) = negative
-	buf, err = readBytes(d.bin, 1)
-	if err != nil {
-		return "", err
+func readDecimal(d *decoder) (Decimal, error) {
+	// wReserved - ignored
+	if _, err := d.bin.next(2); err != nil {
+		return Decimal{}, err
 	}
-	var sign byte
-	binary.Read(buf, binary.LittleEndian, &sign)
 
-	// Hi32
-	buf, err = readBytes(d.bin, 4)
+	// scale - range 0 to 28
+	scale, err := d.bin.readByte()
 	if err != nil {
-		return "", err
+		return Decimal{}, err
+	}
+	if scale > maxDecimalScale {
+		return Decimal{}, fmt.Errorf("Invalid decimal scale %d", scale)
 	}
-	var hi32 uint32
-	binary.Read(buf, binary.LittleEndian, &hi32)
 
-	// Lo64
-	buf, err = readBytes(d.bin, 8)
+	// sign: 0 = positive, 128 (0x80) = negative
+	sign, err := d.bin.readByte()
 	if err != nil {
-		return "", err
+		return Decimal{}, err
 	}
-	var lo64 uint64
-	binary.Read(buf, binary.LittleEndian, &lo64)
-
-	// Goal: (Hi32 * 2^64 + Lo64) / 10^scale
-
-	// 2^64
-	var limit64 Int
-	limit64.Exp(NewInt(2), NewInt(64), nil)
 
-	var bigFirstPartInt Int
-	// Hi32 * 2^64
-	bigFirstPartInt.Mul(NewInt(int64(hi32)), &limit64)
-
-	// Hi32 * 2^64 + Lo64
-	bigFirstPartInt.Add(&bigFirstPartInt, new(Int).SetUint64(lo64))
-
-	// (Hi32 * 2^64 + Lo64) / 10^scale
-	numText := fmt.Sprint(&bigFirstPartInt)
-	if scale > 0 {
-		decIdx := len(numText) - int(scale)
-		numText = numText[:decIdx] + "." + numText[decIdx:]
+	hi32, err := d.bin.readUint32()
+	if err != nil {
+		return Decimal{}, err
 	}
-	if sign == 0x80 {
-		numText = "-" + numText
+	lo64, err := d.bin.readUint64()
+	if err != nil {
+		return Decimal{}, err
 	}
-
-	return numText, nil
+	return Decimal{Hi32: hi32, Lo64: lo64, Scale: scale, Negative: sign == 0x80}, nil
 }
 
 func readDateTimeText(d *decoder) (string, error) {
-	buf, err := readBytes(d.bin, 8)
+	val, err := readDateTime(d)
 	if err != nil {
 		return "", err
 	}
+	return val.String(), nil
+}
 
-	bin := buf.Bytes()
-	tz := (bin[7] & 0xC0) >> 6
-
-	// Masking
-	bin[7] &= 0x3F
-	// Create a new buffer on the new masked bin
-	buf = bytes.NewBuffer(bin)
-
-	var maskedUIntDate uint64
-	binary.Read(buf, binary.LittleEndian, &maskedUIntDate)
-
-	// cNanos for cent-nanos (NBFX spec states the number is the 100 nanoseconds that have elapsed since 1.1.0001)
-	var cNanos uint64 = maskedUIntDate
-	var sec int64 = int64(cNanos / 1e7)
-	var nsec int64 = int64(cNanos % 1e9)
-
-	const (
-		secondsPerDay        = 24 * 60 * 60
-		unixToInternal int64 = (1969*365 + 1969/4 - 1969/100 + 1969/400) * secondsPerDay
-		internalToUnix int64 = -unixToInternal
-	)
-	t := time.Unix(sec+internalToUnix, nsec)
-
-	switch tz {
-	case 0: // not specified, nothing is added
-		return fmt.Sprint(t.UTC().Format("2006-01-02T15:04:05.9999999")), nil
-	case 1: // UTC, add "Z"
-		// TODO: This won't return what we want
-		return fmt.Sprint(t.UTC()), nil
-	case 2: // Local, add offset
-		// TODO: This won't return what we want
-		return fmt.Sprint(t.Local()), nil
+func readDateTime(d *decoder) (DateTime, error) {
+	bin, err := d.bin.readUint64()
+	if err != nil {
+		return DateTime{}, err
 	}
-
-	return "", fmt.Errorf("Unrecognized TZ %v", tz)
+	return dateTimeFromBinary(bin)
 }
 
 func readUniqueIdText(d *decoder) (string, error) {
-	result, err := readUuidText(d)
+	id, err := readGuid(d)
 	if err != nil {
 		return "", err
 	}
-	return urnPrefix + result, nil
+	return UniqueID(id).String(), nil
 }
 
 const urnPrefix string = "urn:uuid:"
@@ -525,78 +443,86 @@ func flipBytes(bin []byte) []byte {
 	return bin
 }
 
-func flipUuidByteOrder(bin []byte) ([]byte, error) {
-	part1 := flipBytes(bin[0:4])
-	part2 := flipBytes(bin[4:6])
-	part3 := flipBytes(bin[6:8])
-	part4 := bin[8:]
-
-	//concatenate parts 1-4
-	return append(part1, append(part2, append(part3, part4...)...)...), nil
+// flipUuidByteOrder converts between the mixed-endian .NET Guid layout and RFC 4122 byte order in place
+func flipUuidByteOrder(bin []byte) {
+	flipBytes(bin[0:4])
+	flipBytes(bin[4:6])
+	flipBytes(bin[6:8])
 }
 
 func writeUniqueIdText(e *encoder, text string) error {
-	id, err := uuid.FromString(text)
-	bin := id.Bytes()
-	bin, err = flipUuidByteOrder(bin)
-	if err != nil {
-		return err
-	}
-	_, err = e.bin.Write(bin)
+	id, err := ParseUniqueID(text)
 	if err != nil {
 		return err
 	}
-	return nil
+	return writeGuid(e, Guid(id))
 }
 
 func readUuidText(d *decoder) (string, error) {
-	bytes := make([]byte, 16)
-
-	_, err := d.bin.Read(bytes)
+	id, err := readGuid(d)
 	if err != nil {
 		return "", err
 	}
+	return id.String(), nil
+}
 
-	bytes, err = flipUuidByteOrder(bytes)
+func readGuid(d *decoder) (Guid, error) {
+	bin, err := d.bin.next(16)
 	if err != nil {
-		return "", err
+		return Guid{}, err
 	}
+	return GuidFromByteArray(bin)
+}
 
-	val, err := uuid.FromBytes(bytes)
-	if err != nil {
-		return "", err
-	}
+const hexDigits = "0123456789abcdef"
 
-	return val.String(), nil
+// formatUuid renders id in the canonical 8-4-4-4-12 lowercase form
+func formatUuid(id [16]byte) string {
+	var text [36]byte
+	j := 0
+	for i, b := range id {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			text[j] = '-'
+			j++
+		}
+		text[j] = hexDigits[b>>4]
+		text[j+1] = hexDigits[b&0x0F]
+		j += 2
+	}
+	return string(text[:])
 }
 
 func isUuid(text string) bool {
 	if len(text) != 36 {
 		return false
 	}
-	match, err := regexp.MatchString("[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}", text)
-	if err != nil {
-		return false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+				return false
+			}
+		}
 	}
-	return match
+	return true
 }
 
 func readTimeSpanText(d *decoder) (string, error) {
-	buf, err := readBytes(d.bin, 8)
+	val, err := readTimeSpan(d)
 	if err != nil {
 		return "", err
 	}
-	var val int64
-	binary.Read(buf, binary.LittleEndian, &val)
-	timeSpan := fmt.Sprint(time.Duration(val) * time.Nanosecond * 100)
-	if strings.HasPrefix(timeSpan, "-") {
-		timeSpan = strings.Replace(timeSpan, "-", "-PT", 1)
-	} else {
-		timeSpan = "PT" + timeSpan
-	}
-	timeSpan = strings.ToUpper(timeSpan)
-	timeSpan = strings.Replace(timeSpan, "0S", "", 1)
-	return timeSpan, nil
+	return val.String(), nil
+}
+
+func readTimeSpan(d *decoder) (TimeSpan, error) {
+	val, err := d.bin.readUint64()
+	return TimeSpan(val), err
 }
 
 func readBoolText(d *decoder) (string, error) {
@@ -622,7 +548,7 @@ func readQNameDictionaryText(d *decoder) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%s:%s", prefix, name), nil
+	return prefix + ":" + name, nil
 }
 
 func readDictionaryString(d *decoder) (string, error) {
@@ -633,5 +559,5 @@ func readDictionaryString(d *decoder) (string, error) {
 	if val, ok := d.dict[key]; ok {
 		return val, nil
 	}
-	return fmt.Sprintf("str%d", key), nil
+	return "str" + strconv.FormatUint(uint64(key), 10), nil
 }
@@ -0,0 +1,15 @@
+package nbfx
+
+// DecoderOptions controls the XML a Decoder writes
+type DecoderOptions struct {
+	// Canonical writes the Exclusive XML Canonicalization (exc-c14n) of the message instead of
+	// plain XML, so it can be digested for a signature without parsing it again
+	Canonical bool
+	// WithComments keeps comments in canonical output, as exc-c14n#WithComments does.
+	// Comments are always kept when Canonical is not set.
+	WithComments bool
+	// Strict fails on a document that is not complete and well-formed instead of writing what
+	// could be decoded: a record or element cut short fails with io.ErrUnexpectedEOF, and
+	// duplicate attributes and undeclared prefixes are reported
+	Strict bool
+}
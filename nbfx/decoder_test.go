@@ -3,7 +3,9 @@ package nbfx
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"math"
+	"strings"
 	"testing"
 )
 
@@ -501,6 +503,16 @@ func TestDecodeExampleUnicodeChars32TextWithEndElement(t *testing.T) {
 		"<U32>32</U32>")
 }
 
+func TestDecodeUnicodeCharsOddLength(t *testing.T) {
+	bin := []byte{0x40, 0x01, 0x55, 0xB7, 0x05, 0x75, 0x00, 0x6E, 0x00, 0x69, 0x01}
+	if _, err := NewDecoder().Decode(bytes.NewReader(bin)); err == nil {
+		t.Error("Expected error for UnicodeChars8Text of odd length")
+	}
+	if _, err := Parse(bytes.NewReader(bin), nil); err == nil {
+		t.Error("Expected Parse error for UnicodeChars8Text of odd length")
+	}
+}
+
 func TestDecodeExampleQNameDictionaryText(t *testing.T) {
 	testDecode(t,
 		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x06, 0xF0, 0x06, 0xBC, 0x08, 0x8E, 0x07, 0x01},
@@ -514,7 +526,8 @@ func TestDecodeExampleQNameDictionaryTextWithEndElement(t *testing.T) {
 }
 
 func TestDecodeExampleUnicodeChars16TextWithChinese(t *testing.T) {
-	testDecode(t,
+	// the example ends with a stray 0x5F byte, which the Decoder ignores and Parse rejects
+	testDecodeOnly(t,
 		[]byte{0x40, 0x0c, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0xb7, 0x08, 0x91, 0x4E, 0x62, 0x88, 0x2D, 0x4E, 0x66, 0x5B, 0x5F},
 		"<PositionName>云衢中学</PositionName>")
 }
@@ -572,8 +585,7 @@ func TestReadDoubleTextSpecialValues(t *testing.T) {
 func testReadFloatTextSpecialValue(t *testing.T, num float32, expected string) {
 	buf := &bytes.Buffer{}
 	binary.Write(buf, binary.LittleEndian, &num)
-	r := bytes.NewReader(buf.Bytes())
-	d := &decoder{bin: r}
+	d := &decoder{bin: newBinReader(buf.Bytes())}
 	actual, err := readFloatText(d)
 	if err != nil {
 		t.Error(err.Error())
@@ -584,8 +596,7 @@ func testReadFloatTextSpecialValue(t *testing.T, num float32, expected string) {
 func testReadDoubleTextSpecialValue(t *testing.T, num float64, expected string) {
 	buf := &bytes.Buffer{}
 	binary.Write(buf, binary.LittleEndian, &num)
-	r := bytes.NewReader(buf.Bytes())
-	d := &decoder{bin: r}
+	d := &decoder{bin: newBinReader(buf.Bytes())}
 	actual, err := readDoubleText(d)
 	if err != nil {
 		t.Error(err.Error())
@@ -593,7 +604,107 @@ func testReadDoubleTextSpecialValue(t *testing.T, num float64, expected string)
 	assertStringEqual(t, actual, expected)
 }
 
+func TestDecodeCanonical(t *testing.T) {
+	bin, err := NewEncoder().Encode(strings.NewReader(`<a xmlns:b="urn:b" xmlns:c="urn:c" z="1" b:y="2"><!--note--><b:d>x &amp; y</b:d><e/></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := NewDecoderWithOptions(nil, DecoderOptions{Canonical: true})
+	actual, err := decoder.Decode(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, actual, `<a xmlns:b="urn:b" z="1" b:y="2"><b:d>x &amp; y</b:d><e></e></a>`)
+
+	decoder = NewDecoderWithOptions(nil, DecoderOptions{Canonical: true, WithComments: true})
+	actual, err = decoder.Decode(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, actual, `<a xmlns:b="urn:b" z="1" b:y="2"><!--note--><b:d>x &amp; y</b:d><e></e></a>`)
+}
+
+func TestDecodeStrict(t *testing.T) {
+	xmlText := `<s:a xmlns:s="urn:s" xmlns:b="urn:b" b:k="v"><b:c>1</b:c><d>text</d><e/><arr>2</arr><arr>3</arr></s:a>`
+	bin, err := NewEncoder().Encode(strings.NewReader(xmlText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict := NewDecoderWithOptions(nil, DecoderOptions{Strict: true})
+	actual, err := strict.Decode(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, actual, `<s:a xmlns:s="urn:s" xmlns:b="urn:b" b:k="v"><b:c>1</b:c><d>text</d><e></e><arr>2</arr><arr>3</arr></s:a>`)
+	for i := 1; i < len(bin); i++ {
+		actual, err = strict.Decode(bytes.NewReader(bin[:i]))
+		if err != io.ErrUnexpectedEOF || actual != "" {
+			t.Errorf("Expected io.ErrUnexpectedEOF for the first %d bytes, got %q, %v", i, actual, err)
+		}
+	}
+
+	// the Array record example of [MC-NBFX]
+	actual, err = strict.Decode(bytes.NewReader([]byte{0x03, 0x40, 0x03, 0x61, 0x72, 0x72, 0x01, 0x8B, 0x03, 0x33, 0x33, 0x88, 0x88, 0xDD, 0xDD}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, actual, "<arr>13107</arr><arr>-30584</arr><arr>-8739</arr>")
+}
+
+func TestDecodeStrictErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		bin  []byte
+	}{
+		{"duplicate attribute", []byte{0x40, 0x01, 0x61, 0x04, 0x01, 0x62, 0x98, 0x00, 0x04, 0x01, 0x62, 0x98, 0x00, 0x01}},
+		{"duplicate namespaced attribute", []byte{0x40, 0x01, 0x61, 0x09, 0x01, 0x70, 0x01, 0x75, 0x09, 0x01, 0x71, 0x01, 0x75,
+			0x05, 0x01, 0x70, 0x01, 0x62, 0x98, 0x00, 0x05, 0x01, 0x71, 0x01, 0x62, 0x98, 0x00, 0x01}},
+		{"undeclared element prefix", []byte{0x41, 0x01, 0x70, 0x01, 0x61, 0x01}},
+		{"undeclared attribute prefix", []byte{0x40, 0x01, 0x61, 0x05, 0x01, 0x70, 0x01, 0x62, 0x98, 0x00, 0x01}},
+		{"prefix declared on a sibling", []byte{0x40, 0x01, 0x61, 0x09, 0x01, 0x70, 0x01, 0x75, 0x01, 0x41, 0x01, 0x70, 0x01, 0x62, 0x01}},
+	}
+	strict := NewDecoderWithOptions(nil, DecoderOptions{Strict: true})
+	for _, test := range tests {
+		actual, err := strict.Decode(bytes.NewReader(test.bin))
+		if err == nil || actual != "" {
+			t.Errorf("Expected error for %s, got %q", test.name, actual)
+		}
+		if _, err = NewDecoder().Decode(bytes.NewReader(test.bin)); err != nil {
+			t.Errorf("Expected %s to decode without Strict, got %v", test.name, err)
+		}
+	}
+
+	// reported with or without Strict, rather than panicking
+	for name, bin := range map[string][]byte{
+		"EndElement without an open element": {0x40, 0x01, 0x61, 0x01, 0x01},
+		"text ending no element":             {0x99, 0x01, 0x78},
+		"attribute outside an element":       {0x04, 0x01, 0x61, 0x98, 0x00},
+		"attribute after text":               {0x40, 0x01, 0x61, 0x98, 0x00, 0x04, 0x01, 0x62, 0x98, 0x00, 0x01},
+		"EndElement as attribute value":      {0x40, 0x01, 0x61, 0x04, 0x01, 0x62, 0x01},
+		"EndElement as array value":          {0x03, 0x40, 0x01, 0x61, 0x01, 0x01, 0x01, 0x00},
+	} {
+		for _, decoder := range []Decoder{NewDecoder(), strict} {
+			if _, err := decoder.Decode(bytes.NewReader(bin)); err == nil {
+				t.Errorf("Expected error for %s", name)
+			}
+		}
+	}
+	_, err := strict.Decode(bytes.NewReader([]byte{0x40, 0x01, 0x61, 0x04, 0x01, 0x62, 0x01}))
+	if err == nil || !strings.Contains(err.Error(), "Invalid attribute value record 0x1") {
+		t.Errorf("Expected invalid attribute value error, got %v", err)
+	}
+}
+
+// testDecode checks that bin decodes to expected, also when pushed in fragments, and that Parse
+// and Write keep it byte for byte
 func testDecode(t *testing.T, bin []byte, expected string) {
+	t.Helper()
+	testDecodeOnly(t, bin, expected)
+	testPushDecode(t, bin, expected)
+	testDocumentRoundTrip(t, bin)
+}
+
+func testDecodeOnly(t *testing.T, bin []byte, expected string) {
 	decoder := NewDecoder()
 	actual, err := decoder.Decode(bytes.NewReader(bin))
 	if err != nil {
@@ -603,7 +714,7 @@ func testDecode(t *testing.T, bin []byte, expected string) {
 }
 
 func testReadMultiByteInt31(t *testing.T, bin []byte, expected uint32) {
-	reader := bytes.NewReader(bin)
+	reader := newBinReader(bin)
 	actual, err := readMultiByteInt31(reader)
 	if err != nil {
 		t.Error("Error: " + err.Error())
@@ -614,3 +725,45 @@ func testReadMultiByteInt31(t *testing.T, bin []byte, expected uint32) {
 		return
 	}
 }
+
+func BenchmarkDecodeSmallEnvelope(b *testing.B) {
+	bin := []byte{0x56, 0x02, 0x0B, 0x01, 0x61, 0x06, 0x0B, 0x01, 0x73, 0x04, 0x56, 0x08, 0x44, 0x0A, 0x1E, 0x00, 0x82, 0x99, 0x06, 0x61, 0x63, 0x74, 0x69, 0x6F, 0x6E, 0x01, 0x56, 0x0E, 0x40, 0x09, 0x49, 0x6E, 0x76, 0x65, 0x6E, 0x74, 0x6F, 0x72, 0x79, 0x81, 0x01, 0x01}
+	benchmarkDecode(b, bin)
+}
+
+func BenchmarkDecodeLargePayload(b *testing.B) {
+	benchmarkDecode(b, largeBinaryPayload())
+}
+
+func benchmarkDecode(b *testing.B, bin []byte) {
+	decoder := NewDecoder()
+	b.SetBytes(int64(len(bin)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decoder.Decode(bytes.NewReader(bin)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// largeBinaryPayload builds a multi-megabyte document of repeated typed records
+// followed by a single large Bytes32Text record.
+func largeBinaryPayload() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0x40, 0x04, 0x72, 0x6F, 0x6F, 0x74})
+	for i := 0; i < 50000; i++ {
+		buf.Write([]byte{0x40, 0x04, 0x69, 0x74, 0x65, 0x6D, 0x04, 0x02, 0x69, 0x64, 0x8C})
+		binary.Write(buf, binary.LittleEndian, int32(i))
+		buf.Write([]byte{0x99, 0x05, 0x68, 0x65, 0x6C, 0x6C, 0x6F})
+	}
+	blob := make([]byte, 4<<20)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+	buf.Write([]byte{0x40, 0x04, 0x62, 0x6C, 0x6F, 0x62, 0xA3})
+	binary.Write(buf, binary.LittleEndian, int32(len(blob)))
+	buf.Write(blob)
+	buf.WriteByte(0x01)
+	return buf.Bytes()
+}
@@ -0,0 +1,25 @@
+package nbfx
+
+// Dictionary is a static string dictionary (like the NBFS dictionary) with
+// lookups precomputed in both directions so it can be shared by any number
+// of encoders and decoders without being rebuilt for each one
+type Dictionary struct {
+	strings map[uint32]string
+	keys    map[string]uint32
+}
+
+// NewDictionary creates a Dictionary from a map of dictionary keys to strings.
+// When a string appears under more than one key, the smallest key is used for encoding.
+func NewDictionary(dictionaryStrings map[uint32]string) *Dictionary {
+	dictionary := &Dictionary{
+		strings: make(map[uint32]string, len(dictionaryStrings)),
+		keys:    make(map[string]uint32, len(dictionaryStrings)),
+	}
+	for k, v := range dictionaryStrings {
+		dictionary.strings[k] = v
+		if existing, ok := dictionary.keys[v]; !ok || k < existing {
+			dictionary.keys[v] = k
+		}
+	}
+	return dictionary
+}
@@ -0,0 +1,777 @@
+package nbfx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Document is an NBFX document as the records it was written in. Each node keeps its record type,
+// the dictionary keys of its strings and its value in the type of its record, so Write writes a
+// parsed Document back byte for byte, and changing one node leaves the records of the others as
+// they were. This lets a message be forwarded with one header changed without decoding it to XML
+// and encoding it again.
+type Document struct {
+	Nodes      []Node
+	dictionary *Dictionary
+}
+
+// Node is a node of a Document: an *Element, *Text, *Comment or *Array
+type Node interface {
+	write(e *encoder) error
+}
+
+// Element is an element record with its attributes and content.
+// Record is the type of the element record, which sets how the name is written: the
+// PrefixElementA-Z records imply Prefix, and the dictionary records write NameKey, the key of Name.
+type Element struct {
+	Record     byte
+	Prefix     string
+	Name       string
+	NameKey    uint32
+	Attributes []*Attribute
+	Children   []Node
+}
+
+// Attribute is an attribute record. Its Record, Prefix, Name and NameKey are like an Element's.
+// Namespace declarations, the xmlns records, have no Value: they declare Namespace, with its key
+// in NamespaceKey when the record is a dictionary record, for the prefix Name, or the default
+// namespace when Name is "xmlns" and Prefix is empty.
+type Attribute struct {
+	Record       byte
+	Prefix       string
+	Name         string
+	NameKey      uint32
+	Value        *Text
+	Namespace    string
+	NamespaceKey uint32
+}
+
+// Text is a text record with its value in the Go type of the record:
+//   - ZeroText and OneText: int64 0 and 1, FalseText and TrueText: false and true
+//   - Int8/16/32/64Text: int64, UInt64Text: uint64, BoolText: bool
+//   - FloatText: float32, DoubleText: float64
+//   - DecimalText: Decimal, DateTimeText: DateTime, TimeSpanText: TimeSpan
+//   - UuidText: Guid, UniqueIdText: UniqueID
+//   - Chars8/16/32Text, UnicodeChars8/16/32Text and EmptyText: string
+//   - Bytes8/16/32Text: []byte
+//   - DictionaryText: DictionaryString, QNameDictionaryText: DictionaryQName
+//   - StartListText: []*Text, the items of the list ending with its EndListText record, which has no value
+//
+// A WithEndElement Record ends the element the Text is the last child of.
+type Text struct {
+	Record byte
+	Value  interface{}
+}
+
+// Comment is a comment record
+type Comment struct {
+	Text string
+}
+
+// Array is an Array record: Element repeated for each of Values, which are all of the text record
+// Record. Record is a WithEndElement record such as Int32TextWithEndElement, as written by .NET.
+type Array struct {
+	Element *Element
+	Record  byte
+	Values  []interface{}
+}
+
+// DictionaryString is a string written as its key in the dictionary
+type DictionaryString struct {
+	Key   uint32
+	Value string
+}
+
+// String returns the string of the key
+func (s DictionaryString) String() string {
+	return s.Value
+}
+
+// DictionaryQName is the value of a QNameDictionaryText record, a prefix of one letter from a to z
+// and a name in the dictionary
+type DictionaryQName struct {
+	Prefix string
+	Name   DictionaryString
+}
+
+// String returns the QName as prefix:name
+func (q DictionaryQName) String() string {
+	return q.Prefix + ":" + q.Name.Value
+}
+
+// NewDocument creates an empty Document using dictionary for the dictionary strings of the nodes
+// it creates. The dictionary may be nil.
+func NewDocument(dictionary *Dictionary) *Document {
+	if dictionary == nil {
+		dictionary = NewDictionary(nil)
+	}
+	return &Document{dictionary: dictionary}
+}
+
+// Parse reads the NBFX document in r. The dictionary may be nil, and keys missing from it give
+// strings like "str8" as the Decoder does. Parse fails with io.ErrUnexpectedEOF when r ends
+// inside a record or an element, and on lengths and keys written with more bytes than needed,
+// which Write could not reproduce.
+func Parse(r io.Reader, dictionary *Dictionary) (*Document, error) {
+	p, err := newRecordParser(r, dictionary)
+	if err != nil {
+		return nil, err
+	}
+	p.d.bin.minimal = true
+	doc := NewDocument(dictionary)
+	var open []*Element
+	for {
+		rec, err := p.next()
+		if err == io.EOF {
+			if len(open) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return doc, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var parent *Element
+		if len(open) > 0 {
+			parent = open[len(open)-1]
+		}
+		switch rec := rec.(type) {
+		case *Attribute:
+			parent.Attributes = append(parent.Attributes, rec)
+			continue
+		case *EndElement:
+			if parent == nil {
+				return nil, errors.New("EndElement record without an open element")
+			}
+			open = open[:len(open)-1]
+			continue
+		}
+		if parent == nil {
+			doc.Nodes = append(doc.Nodes, rec.(Node))
+		} else {
+			parent.Children = append(parent.Children, rec.(Node))
+		}
+		switch rec := rec.(type) {
+		case *Element:
+			open = append(open, rec)
+		case *Text:
+			if rec.Record&1 == 1 {
+				if parent == nil {
+					return nil, fmt.Errorf("%s record without an open element", rec.RecordName())
+				}
+				open = open[:len(open)-1]
+			}
+		}
+	}
+}
+
+// Write writes the records of the Document to w
+func (d *Document) Write(w io.Writer) error {
+	e := &encoder{bin: getBuffer()}
+	defer putBuffer(e.bin)
+	err := writeContent(e, d.Nodes, false)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(e.bin.Bytes())
+	return err
+}
+
+// Root returns the first element of the Document, or nil
+func (d *Document) Root() *Element {
+	for _, node := range d.Nodes {
+		if el, ok := node.(*Element); ok {
+			return el
+		}
+	}
+	return nil
+}
+
+// DictionaryString returns s as a DictionaryString when it is in the Document's dictionary
+func (d *Document) DictionaryString(s string) (DictionaryString, bool) {
+	key, ok := d.dictionaryKey(s)
+	return DictionaryString{Key: key, Value: s}, ok
+}
+
+// dictionaryKey returns the key of s, taking "str8" to be key 8 as the Encoder does
+func (d *Document) dictionaryKey(s string) (uint32, bool) {
+	if key, ok := d.dictionary.keys[s]; ok {
+		return key, true
+	}
+	return specialDictionaryKey(s)
+}
+
+// NewElement creates an element named prefix:local with the record the Encoder would choose for it
+func (d *Document) NewElement(prefix, local string) *Element {
+	key, isDict := d.dictionaryKey(local)
+	id := nameRecordId(prefix, isDict, shortElement, prefixDictionaryElementA, prefixElementA)
+	return &Element{Record: id, Prefix: prefix, Name: local, NameKey: key}
+}
+
+// NewAttribute creates an attribute named prefix:local with the record the Encoder would choose for it
+func (d *Document) NewAttribute(prefix, local string, value *Text) *Attribute {
+	key, isDict := d.dictionaryKey(local)
+	id := nameRecordId(prefix, isDict, shortAttribute, prefixDictionaryAttributeA, prefixAttributeA)
+	return &Attribute{Record: id, Prefix: prefix, Name: local, NameKey: key, Value: value}
+}
+
+// NewXmlnsAttribute creates a declaration of ns for prefix, or of the default namespace when
+// prefix is empty
+func (d *Document) NewXmlnsAttribute(prefix, ns string) *Attribute {
+	key, isDict := d.dictionaryKey(ns)
+	attr := &Attribute{Record: shortXmlnsAttribute, Name: "xmlns", Namespace: ns, NamespaceKey: key}
+	if prefix != "" {
+		attr.Record, attr.Prefix, attr.Name = xmlnsAttribute, "xmlns", prefix
+	}
+	if isDict {
+		attr.Record += shortDictionaryXmlnsAttribute - shortXmlnsAttribute
+	}
+	return attr
+}
+
+// nameRecordId returns the id of the element or attribute record for a name, given the ids of
+// the short record and the A records of its kind
+func nameRecordId(prefix string, isDict bool, short, dictA, letterA byte) byte {
+	if len(prefix) == 1 && prefix[0] >= 'a' && prefix[0] <= 'z' {
+		if isDict {
+			return dictA + prefix[0] - 'a'
+		}
+		return letterA + prefix[0] - 'a'
+	}
+	id := short
+	if prefix != "" {
+		id++
+	}
+	if isDict {
+		id += 2
+	}
+	return id
+}
+
+// NewText creates a Text of value with the record of its Go type, as the Writer would write it.
+// Integers of any size use the smallest integer record that holds them.
+func NewText(value interface{}) (*Text, error) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return &Text{emptyText, v}, nil
+		}
+		id, err := getCharsTextRecordId(v)
+		return &Text{id, v}, err
+	case bool:
+		if v {
+			return &Text{trueText, v}, nil
+		}
+		return &Text{falseText, v}, nil
+	case int:
+		return NewText(int64(v))
+	case int32:
+		return NewText(int64(v))
+	case int64:
+		switch v {
+		case 0:
+			return &Text{zeroText, v}, nil
+		case 1:
+			return &Text{oneText, v}, nil
+		}
+		return &Text{getIntTextRecordId(v), v}, nil
+	case uint64:
+		return &Text{uInt64Text, v}, nil
+	case float32:
+		return &Text{floatText, v}, nil
+	case float64:
+		return &Text{doubleText, v}, nil
+	case Decimal:
+		return &Text{decimalText, v}, nil
+	case DateTime:
+		return &Text{dateTimeText, v}, nil
+	case TimeSpan:
+		return &Text{timeSpanText, v}, nil
+	case Guid:
+		return &Text{uuidText, v}, nil
+	case UniqueID:
+		return &Text{uniqueIdText, v}, nil
+	case []byte:
+		switch {
+		case len(v) <= math.MaxUint8:
+			return &Text{bytes8Text, v}, nil
+		case len(v) <= math.MaxUint16:
+			return &Text{bytes16Text, v}, nil
+		}
+		return &Text{bytes32Text, v}, nil
+	case DictionaryString:
+		return &Text{dictionaryText, v}, nil
+	case DictionaryQName:
+		return &Text{qNameDictionaryText, v}, nil
+	}
+	return nil, fmt.Errorf("No text record for a %T value", value)
+}
+
+// String returns the text as the Decoder writes it
+func (t *Text) String() string {
+	switch v := t.Value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case float32:
+		return FormatFloat(float64(v), 32)
+	case float64:
+		return FormatFloat(v, 64)
+	case []byte:
+		return b64.EncodeToString(v)
+	case []*Text:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if item.Record&^1 != endListText {
+				items = append(items, item.String())
+			}
+		}
+		return strings.Join(items, " ")
+	case fmt.Stringer:
+		return v.String()
+	}
+	return ""
+}
+
+// Elements returns the child elements of el
+func (el *Element) Elements() []*Element {
+	var elements []*Element
+	for _, node := range el.Children {
+		if child, ok := node.(*Element); ok {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// Element returns the first child element of el named prefix:local, or nil
+func (el *Element) Element(prefix, local string) *Element {
+	for _, node := range el.Children {
+		if child, ok := node.(*Element); ok && child.Prefix == prefix && child.Name == local {
+			return child
+		}
+	}
+	return nil
+}
+
+// Attr returns the attribute of el named prefix:local, or nil
+func (el *Element) Attr(prefix, local string) *Attribute {
+	for _, attr := range el.Attributes {
+		if attr.Prefix == prefix && attr.Name == local {
+			return attr
+		}
+	}
+	return nil
+}
+
+// Text returns the text of the Text children of el
+func (el *Element) Text() string {
+	var sb strings.Builder
+	for _, node := range el.Children {
+		if text, ok := node.(*Text); ok {
+			sb.WriteString(text.String())
+		}
+	}
+	return sb.String()
+}
+
+// SetText replaces the content of el with text. Text ends el with its WithEndElement record when
+// the content it replaces did, so the records after el are unchanged.
+func (el *Element) SetText(text *Text) {
+	if last, ok := lastText(el.Children); ok && last.Record&1 == 1 && text.Record&^1 != startListText {
+		text.Record |= 1
+	}
+	el.Children = []Node{text}
+}
+
+func lastText(nodes []Node) (*Text, bool) {
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	text, ok := nodes[len(nodes)-1].(*Text)
+	return text, ok
+}
+
+// Append adds nodes to the end of the content of el
+func (el *Element) Append(nodes ...Node) {
+	el.Children = append(el.Children, nodes...)
+}
+
+// Remove removes node from the content of el and reports whether it was there
+func (el *Element) Remove(node Node) bool {
+	for i, child := range el.Children {
+		if child == node {
+			el.Children = append(el.Children[:i], el.Children[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Replace replaces old in the content of el with node and reports whether old was there
+func (el *Element) Replace(old, node Node) bool {
+	for i, child := range el.Children {
+		if child == old {
+			el.Children[i] = node
+			return true
+		}
+	}
+	return false
+}
+
+// writeContent writes nodes, the content of an element when inElement is set. The element is
+// ended by the record of its last child when that is a WithEndElement text record, or by an
+// EndElement record.
+func writeContent(e *encoder, nodes []Node, inElement bool) error {
+	for i, node := range nodes {
+		var err error
+		if text, ok := node.(*Text); ok {
+			id := text.Record &^ 1
+			endsElement := inElement && i == len(nodes)-1 && text.Record&1 == 1
+			if endsElement {
+				id = text.Record
+			}
+			err = text.writeRecord(e, id)
+			if err == nil && endsElement {
+				return nil
+			}
+		} else {
+			err = node.write(e)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if inElement {
+		return e.bin.WriteByte(endElement)
+	}
+	return nil
+}
+
+func (el *Element) write(e *encoder) error {
+	err := el.writeStart(e)
+	if err != nil {
+		return err
+	}
+	return writeContent(e, el.Children, true)
+}
+
+// writeStart writes the element record and attributes of el
+func (el *Element) writeStart(e *encoder) error {
+	if el.Record < shortElement || el.Record >= prefixElementA+26 {
+		return fmt.Errorf("Invalid element record %#x", el.Record)
+	}
+	err := writeName(e, el.Record, shortElement, prefixDictionaryElementA, prefixElementA, el.Prefix, el.Name, el.NameKey)
+	if err != nil {
+		return err
+	}
+	for _, attr := range el.Attributes {
+		if err = attr.write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeName writes the element or attribute record id with its name, given the ids of the short
+// record and the A records of its kind
+func writeName(e *encoder, id, short, dictA, letterA byte, prefix, name string, key uint32) error {
+	err := e.bin.WriteByte(id)
+	if err != nil {
+		return err
+	}
+	isDict := id == short+2 || id == short+3
+	switch {
+	case id >= letterA && id < letterA+26:
+		err = checkPrefix(prefix, id-letterA)
+	case id >= dictA && id < dictA+26:
+		err = checkPrefix(prefix, id-dictA)
+		isDict = true
+	case id == short+1 || id == short+3:
+		_, err = writeString(e, prefix)
+	case prefix != "":
+		err = fmt.Errorf("Record %s has no prefix, found %q", records[id].getName(), prefix)
+	}
+	if err != nil {
+		return err
+	}
+	if isDict {
+		_, err = writeMultiByteInt31(e, key)
+	} else {
+		_, err = writeString(e, name)
+	}
+	return err
+}
+
+func checkPrefix(prefix string, letter byte) error {
+	if prefix != string(rune('a'+letter)) {
+		return fmt.Errorf("Record for prefix %c does not match prefix %q", 'a'+letter, prefix)
+	}
+	return nil
+}
+
+func (attr *Attribute) write(e *encoder) error {
+	if attr.Record < shortAttribute || attr.Record >= shortElement {
+		return fmt.Errorf("Invalid attribute record %#x", attr.Record)
+	}
+	if attr.Record < shortXmlnsAttribute || attr.Record > dictionaryXmlnsAttribute {
+		if attr.Value == nil {
+			return fmt.Errorf("Attribute %s has no value", attr.Name)
+		}
+		err := writeName(e, attr.Record, shortAttribute, prefixDictionaryAttributeA, prefixAttributeA, attr.Prefix, attr.Name, attr.NameKey)
+		if err != nil {
+			return err
+		}
+		return attr.Value.writeRecord(e, attr.Value.Record&^1)
+	}
+
+	err := e.bin.WriteByte(attr.Record)
+	if err != nil {
+		return err
+	}
+	if attr.Record == xmlnsAttribute || attr.Record == dictionaryXmlnsAttribute {
+		_, err = writeString(e, attr.Name)
+		if err != nil {
+			return err
+		}
+	}
+	if attr.Record == shortDictionaryXmlnsAttribute || attr.Record == dictionaryXmlnsAttribute {
+		_, err = writeMultiByteInt31(e, attr.NamespaceKey)
+	} else {
+		_, err = writeString(e, attr.Namespace)
+	}
+	return err
+}
+
+func (t *Text) write(e *encoder) error {
+	return t.writeRecord(e, t.Record&^1)
+}
+
+// writeRecord writes t as the text record id, which is its Record or the Record without the
+// WithEndElement bit
+func (t *Text) writeRecord(e *encoder, id byte) error {
+	if rec, err := getRecord(id); err != nil || !rec.isText() {
+		return fmt.Errorf("Invalid text record %#x", id)
+	}
+	err := e.bin.WriteByte(id)
+	if err != nil {
+		return err
+	}
+	return writeValue(e, id&^1, t.Value)
+}
+
+func (c *Comment) write(e *encoder) error {
+	return records[comment].(textRecordEncoder).encodeText(e, nil, c.Text)
+}
+
+func (a *Array) write(e *encoder) error {
+	if a.Element == nil {
+		return errors.New("Array has no element")
+	}
+	if !isArrayValueRecord(a.Record) {
+		return fmt.Errorf("Invalid array value record %#x", a.Record)
+	}
+	err := e.bin.WriteByte(array)
+	if err != nil {
+		return err
+	}
+	if err = a.Element.writeStart(e); err != nil {
+		return err
+	}
+	if err = e.bin.WriteByte(endElement); err != nil {
+		return err
+	}
+	if err = e.bin.WriteByte(a.Record); err != nil {
+		return err
+	}
+	if _, err = writeMultiByteInt31(e, uint32(len(a.Values))); err != nil {
+		return err
+	}
+	for _, v := range a.Values {
+		if err = writeValue(e, a.Record&^1, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// impliedValues are the values of the text records that have no content
+var impliedValues = map[byte]interface{}{zeroText: int64(0), oneText: int64(1), falseText: false, trueText: true, emptyText: "", endListText: nil}
+
+// writeValue writes value as the content of the text record id, without the WithEndElement bit
+func writeValue(e *encoder, id byte, value interface{}) error {
+	ok := false
+	var err error
+	switch id {
+	case zeroText, oneText, falseText, trueText, emptyText, endListText:
+		ok = value == impliedValues[id]
+	case boolText:
+		var b bool
+		if b, ok = value.(bool); ok {
+			var bit byte
+			if b {
+				bit = 1
+			}
+			err = e.bin.WriteByte(bit)
+		}
+	case int8Text, int16Text, int32Text, int64Text:
+		var i int64
+		if i, ok = value.(int64); ok {
+			err = writeInt(e, id, i)
+		}
+	case uInt64Text:
+		var u uint64
+		if u, ok = value.(uint64); ok {
+			err = writeUint64(e, u)
+		}
+	case floatText:
+		var f float32
+		if f, ok = value.(float32); ok {
+			err = writeUint32(e, math.Float32bits(f))
+		}
+	case doubleText:
+		var f float64
+		if f, ok = value.(float64); ok {
+			err = writeUint64(e, math.Float64bits(f))
+		}
+	case decimalText:
+		var d Decimal
+		if d, ok = value.(Decimal); ok {
+			err = writeDecimal(e, d)
+		}
+	case dateTimeText:
+		var dt DateTime
+		if dt, ok = value.(DateTime); ok {
+			err = writeDateTime(e, dt)
+		}
+	case timeSpanText:
+		var ts TimeSpan
+		if ts, ok = value.(TimeSpan); ok {
+			err = writeUint64(e, uint64(ts))
+		}
+	case uuidText:
+		var g Guid
+		if g, ok = value.(Guid); ok {
+			err = writeGuid(e, g)
+		}
+	case uniqueIdText:
+		var u UniqueID
+		if u, ok = value.(UniqueID); ok {
+			err = writeGuid(e, Guid(u))
+		}
+	case chars8Text, chars16Text, chars32Text:
+		var s string
+		if s, ok = value.(string); ok {
+			err = writeLength(e, id, len(s))
+			if err == nil {
+				_, err = e.bin.WriteString(s)
+			}
+		}
+	case unicodeChars8Text, unicodeChars16Text, unicodeChars32Text:
+		var s string
+		if s, ok = value.(string); ok {
+			units := utf16.Encode([]rune(s))
+			err = writeLength(e, id, 2*len(units))
+			for _, unit := range units {
+				if err != nil {
+					break
+				}
+				err = writeUint16(e, unit)
+			}
+		}
+	case bytes8Text, bytes16Text, bytes32Text:
+		var b []byte
+		if b, ok = value.([]byte); ok {
+			err = writeLength(e, id, len(b))
+			if err == nil {
+				_, err = e.bin.Write(b)
+			}
+		}
+	case dictionaryText:
+		var s DictionaryString
+		if s, ok = value.(DictionaryString); ok {
+			_, err = writeMultiByteInt31(e, s.Key)
+		}
+	case qNameDictionaryText:
+		var q DictionaryQName
+		if q, ok = value.(DictionaryQName); ok {
+			if len(q.Prefix) != 1 || q.Prefix[0] < 'a' || q.Prefix[0] > 'z' {
+				return fmt.Errorf("QNameDictionaryText prefix must be a letter from a to z, found %q", q.Prefix)
+			}
+			err = e.bin.WriteByte(q.Prefix[0] - 'a')
+			if err == nil {
+				_, err = writeMultiByteInt31(e, q.Name.Key)
+			}
+		}
+	case startListText:
+		var items []*Text
+		if items, ok = value.([]*Text); ok {
+			if len(items) == 0 || items[len(items)-1].Record&^1 != endListText {
+				return errors.New("List must end with an EndListText record")
+			}
+			for _, item := range items {
+				if err = item.writeRecord(e, item.Record); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%s record can't hold the %T value %v", records[id].getName(), value, value)
+	}
+	return err
+}
+
+// writeInt writes i as the content of the integer record id, which must hold it
+func writeInt(e *encoder, id byte, i int64) error {
+	switch id {
+	case int8Text:
+		if i < math.MinInt8 || i > math.MaxInt8 {
+			break
+		}
+		return e.bin.WriteByte(byte(i))
+	case int16Text:
+		if i < math.MinInt16 || i > math.MaxInt16 {
+			break
+		}
+		return writeUint16(e, uint16(i))
+	case int32Text:
+		if i < math.MinInt32 || i > math.MaxInt32 {
+			break
+		}
+		return writeUint32(e, uint32(i))
+	default:
+		return writeUint64(e, uint64(i))
+	}
+	return fmt.Errorf("%d is out of the range of %s", i, records[id].getName())
+}
+
+// writeLength writes n, the length in bytes of the content of the Chars, UnicodeChars or Bytes
+// record id, which must hold it
+func writeLength(e *encoder, id byte, n int) error {
+	switch id {
+	case chars8Text, unicodeChars8Text, bytes8Text:
+		if n <= math.MaxUint8 {
+			return e.bin.WriteByte(byte(n))
+		}
+	case chars16Text, unicodeChars16Text, bytes16Text:
+		if n <= math.MaxUint16 {
+			return writeUint16(e, uint16(n))
+		}
+	default:
+		if n <= math.MaxInt32 {
+			return writeUint32(e, uint32(n))
+		}
+	}
+	return fmt.Errorf("%d bytes are too long for %s", n, records[id].getName())
+}
@@ -0,0 +1,168 @@
+package nbfx
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// testDocumentRoundTrip checks that a parsed Document writes bin back unchanged
+func testDocumentRoundTrip(t *testing.T, bin []byte) {
+	t.Helper()
+	doc, err := Parse(bytes.NewReader(bin), nil)
+	if err != nil {
+		t.Errorf("Parse %x: %v", bin, err)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err = doc.Write(buf); err != nil {
+		t.Errorf("Write %x: %v", bin, err)
+		return
+	}
+	assertBinEqual(t, buf.Bytes(), bin)
+}
+
+var documentDictionary = NewDictionary(map[uint32]string{2: "Envelope", 4: "urn:s", 6: "Header", 8: "To", 10: "Body", 12: "urn:a"})
+
+// writeDocumentMessage writes a message with the header a:To as the Writer does
+func writeDocumentMessage(t *testing.T, to string) []byte {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, documentDictionary)
+	for _, err := range []error{
+		w.WriteStartElement("s", "Envelope", "urn:s"),
+		w.WriteXmlnsAttribute("a", "urn:a"),
+		w.WriteStartElement("s", "Header", "urn:s"),
+		w.WriteStartElement("a", "To", "urn:a"),
+		w.WriteString(to),
+		w.WriteEndElement(),
+		w.WriteStartElement("a", "MessageID", "urn:a"),
+		w.WriteUniqueID(UniqueID{1, 2, 3}),
+		w.WriteEndElement(),
+		w.WriteEndElement(),
+		w.WriteStartElement("s", "Body", "urn:s"),
+		w.WriteComment("body"),
+		w.WriteStartElement("", "n", "urn:n"),
+		w.WriteAttribute("a", "k", "urn:a", "v"),
+		w.WriteDouble(0.5),
+		w.WriteEndElement(),
+		w.WriteArray("", "i", "", []int32{1, 2}),
+		w.WriteEndElement(),
+		w.WriteEndElement(),
+		w.Flush(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDocumentEditHeader(t *testing.T) {
+	bin := writeDocumentMessage(t, "http://old")
+	doc, err := Parse(bytes.NewReader(bin), documentDictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := doc.Root().Element("s", "Header")
+	if header == nil || header.Record != prefixDictionaryElementA+'s'-'a' || header.NameKey != 6 {
+		t.Fatalf("Unexpected header %+v", header)
+	}
+	to := header.Element("a", "To")
+	assertStringEqual(t, to.Text(), "http://old")
+	id := header.Element("a", "MessageID").Children[0].(*Text)
+	assertEqual(t, id.Value, UniqueID{1, 2, 3})
+	body := doc.Root().Element("s", "Body")
+	assertEqual(t, body.Elements()[0].Attr("a", "k").Value.String(), "v")
+	assertEqual(t, body.Children[2].(*Array).Values[1], int64(2))
+
+	text, err := NewText("http://new/service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to.SetText(text)
+	buf := &bytes.Buffer{}
+	if err = doc.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	assertBinEqual(t, buf.Bytes(), writeDocumentMessage(t, "http://new/service"))
+}
+
+func TestDocumentNewNodes(t *testing.T) {
+	doc := NewDocument(documentDictionary)
+	envelope := doc.NewElement("s", "Envelope")
+	envelope.Attributes = append(envelope.Attributes, doc.NewXmlnsAttribute("s", "urn:s"), doc.NewXmlnsAttribute("", "urn:d"))
+	body := doc.NewElement("s", "Body")
+	envelope.Append(body)
+	item := doc.NewElement("", "item")
+	count, _ := NewText(int64(300))
+	item.Attributes = append(item.Attributes, doc.NewAttribute("", "count", count))
+	name, _ := doc.DictionaryString("To")
+	value, _ := NewText(name)
+	item.SetText(value)
+	body.Append(item, &Comment{"end"})
+	doc.Nodes = append(doc.Nodes, envelope)
+
+	buf := &bytes.Buffer{}
+	if err := doc.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("Envelope")) || bytes.Contains(buf.Bytes(), []byte("urn:s")) {
+		t.Errorf("Expected dictionary records in %x", buf.Bytes())
+	}
+	decoded, err := NewDecoderWithDictionary(documentDictionary).Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, decoded, `<s:Envelope xmlns:s="urn:s" xmlns="urn:d"><s:Body><item count="300">To</item><!--end--></s:Body></s:Envelope>`)
+
+	if body.Remove(item) == false || body.Remove(item) == true || len(body.Children) != 1 {
+		t.Errorf("Unexpected children after Remove %v", body.Children)
+	}
+	if !body.Replace(body.Children[0], item) || body.Children[0] != item {
+		t.Error("Expected Replace to replace the comment")
+	}
+}
+
+func TestDocumentErrors(t *testing.T) {
+	bin := writeDocumentMessage(t, "http://old")
+	for i := 1; i < len(bin); i++ {
+		if _, err := Parse(bytes.NewReader(bin[:i]), documentDictionary); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF for the first %d bytes, got %v", i, err)
+		}
+	}
+	if _, err := Parse(bytes.NewReader([]byte{0x40, 0x01, 0x61, 0x01, 0x01}), nil); err == nil {
+		t.Error("Expected error for an EndElement without an open element")
+	}
+	if _, err := Parse(bytes.NewReader([]byte{0x40, 0x01, 0x61, 0x98, 0x00, 0x04, 0x01, 0x62, 0x98, 0x00, 0x01}), nil); err == nil {
+		t.Error("Expected error for an attribute after text")
+	}
+	// Write could not reproduce lengths and keys padded with zero groups
+	for _, bin := range [][]byte{
+		{0x40, 0x81, 0x00, 0x61, 0x01},
+		{0x42, 0x84, 0x00, 0x01},
+		{0x40, 0x01, 0x61, 0xAA, 0x84, 0x80, 0x00, 0x01},
+		{0x03, 0x40, 0x01, 0x61, 0x01, 0x8B, 0x81, 0x00, 0x05, 0x00},
+	} {
+		if _, err := Parse(bytes.NewReader(bin), nil); err == nil {
+			t.Errorf("Expected error for non-minimal MultiByteInt31 in %x", bin)
+		}
+		if err := Walk(bytes.NewReader(bin), func(Record) error { return nil }); err != nil {
+			t.Errorf("Expected Walk to accept %x, got %v", bin, err)
+		}
+	}
+
+	doc := NewDocument(nil)
+	root := doc.NewElement("", "a")
+	doc.Nodes = append(doc.Nodes, root)
+	for _, text := range []*Text{{int8Text, int64(300)}, {doubleText, "1.5"}, {trueText, false}, {startListText, []*Text{}}} {
+		root.Children = []Node{text}
+		if err := doc.Write(&bytes.Buffer{}); err == nil {
+			t.Errorf("Expected error writing %#x %v", text.Record, text.Value)
+		}
+	}
+	root.Children = nil
+	root.Prefix = "p"
+	if err := doc.Write(&bytes.Buffer{}); err == nil {
+		t.Error("Expected error for a prefix on a ShortElement record")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 )
 
 func init() {
@@ -56,6 +57,15 @@ func (r *elementRecordBase) readElementAttributes(element xml.StartElement, d *d
 			rec = nil
 		}
 	}
+	// an element at the end of the input is written without content, unless decoding strictly
+	if err != nil && (err != io.EOF || d.opts.Strict) {
+		return nil, err
+	}
+	if d.opts.Strict {
+		if err = d.checkStartElement(element); err != nil {
+			return nil, err
+		}
+	}
 
 	err = d.xml.EncodeToken(element)
 	if err != nil {
@@ -107,7 +117,7 @@ func (r *prefixDictionaryElementAZRecord) decodeElement(d *decoder) (record, err
 }
 
 func (r *prefixDictionaryElementAZRecord) encodeElement(e *encoder, element xml.StartElement) error {
-	_, err := e.bin.Write([]byte{r.id})
+	err := e.bin.WriteByte(r.id)
 	if err != nil {
 		return err
 	}
@@ -138,7 +148,7 @@ func (r *shortDictionaryElementRecord) decodeElement(d *decoder) (record, error)
 }
 
 func (r *shortDictionaryElementRecord) encodeElement(e *encoder, element xml.StartElement) error {
-	_, err := e.bin.Write([]byte{r.id})
+	err := e.bin.WriteByte(r.id)
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,7 @@ package nbfx
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -9,101 +10,167 @@ import (
 	"math"
 	"strconv"
 	"strings"
-
-	"encoding/binary"
-
-	"github.com/satori/go.uuid"
 )
 
 type encoder struct {
-	dict        map[string]uint32
-	xml         *xml.Decoder
-	bin         *bytes.Buffer
-	tokenBuffer queue
+	dict      map[string]uint32
+	xml       *xml.Decoder
+	bin       *bytes.Buffer
+	nextToken xml.Token
+	hasNext   bool
+	scratch   [8]byte
+	textBuf   []byte
+	bytesBuf  []byte
+	opts      EncoderOptions
+	ns        nsScope
+	path      []xml.Name
+	// space holds whether xml:space="preserve" applies to each open element
+	space []bool
+	// out, when set, is given the records in bin whenever they reach encodeFlushSize
+	out io.Writer
 }
 
-func (e *encoder) addDictionaryString(index uint32, value string) {
-	if _, ok := e.dict[value]; ok {
-		return
-	}
-	e.dict[value] = index
-}
+// encodeFlushSize is the number of bytes EncodeTo buffers before writing them out
+const encodeFlushSize = 32 << 10
 
-// NewEncoder creates a new NBFX Encoder
+// NewEncoder creates a new NBFX Encoder. Names and text like "str8", which the Decoder writes for
+// keys missing from its dictionary, are encoded as dictionary key 8, so a peer whose dictionary
+// has that key reads its string instead. Set EncoderOptions.StringsOnly to keep such text as
+// characters.
 func NewEncoder() Encoder {
-	return NewEncoderWithStrings(nil)
+	return NewEncoderWithDictionary(nil)
 }
 
 // NewEncoderWithStrings creates a new NBFX Encoder with a dictionary (like an NBFS dictionary)
 func NewEncoderWithStrings(dictionaryStrings map[uint32]string) Encoder {
-	encoder := &encoder{dict: map[string]uint32{}}
-	if dictionaryStrings != nil {
-		for k, v := range dictionaryStrings {
-			encoder.addDictionaryString(k, v)
-		}
+	return NewEncoderWithDictionary(NewDictionary(dictionaryStrings))
+}
+
+// NewEncoderWithDictionary creates a new NBFX Encoder sharing a precomputed Dictionary
+func NewEncoderWithDictionary(dictionary *Dictionary) Encoder {
+	return NewEncoderWithOptions(dictionary, EncoderOptions{})
+}
+
+// NewEncoderWithOptions creates a new NBFX Encoder sharing a precomputed Dictionary,
+// with options controlling how text records are chosen
+func NewEncoderWithOptions(dictionary *Dictionary, opts EncoderOptions) Encoder {
+	if dictionary == nil {
+		dictionary = NewDictionary(nil)
 	}
-	return encoder
+	return &encoder{dict: dictionary.keys, opts: opts}
 }
 
 func (e *encoder) popToken() (xml.Token, error) {
-	var token xml.Token
-	var err error
-	if e.tokenBuffer.length > 0 {
-		token = e.tokenBuffer.dequeue().(xml.Token)
-		return token, err
+	if e.hasNext {
+		token := e.nextToken
+		e.nextToken, e.hasNext = nil, false
+		return token, nil
 	}
-	token, err = e.xml.RawToken()
-	token = xml.CopyToken(token) // make the token immutable (see doc for xml.Decoder.Token())
-	return token, err
+	// RawToken's CharData and Comment bytes are only valid until the next
+	// call, so callers take a string copy before reading further
+	return e.xml.RawToken()
 }
 
 func (e *encoder) pushToken(token xml.Token) {
-	e.tokenBuffer.enqueue(token)
+	e.nextToken, e.hasNext = token, true
 }
 
 func (e *encoder) Encode(reader io.Reader) ([]byte, error) {
-	e.bin = &bytes.Buffer{}
-	e.xml = xml.NewDecoder(reader)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	err := e.encode(buf, reader)
+	return append([]byte(nil), buf.Bytes()...), err
+}
+
+func (e *encoder) EncodeTo(writer io.Writer, reader io.Reader) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	e.out = writer
+	defer func() { e.out = nil }()
+	err := e.encode(buf, reader)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(buf.Bytes())
+	return err
+}
+
+func (e *encoder) encode(buf *bytes.Buffer, reader io.Reader) error {
+	e.bin = buf
+	e.xml = newXmlDecoder(reader)
+	e.nextToken, e.hasNext = nil, false
+	e.ns.reset()
+	e.path = e.path[:0]
+	e.space = e.space[:0]
+	defer func() { e.bin, e.xml = nil, nil }()
 	token, err := e.popToken()
 	for err == nil && token != nil {
-		record, err := e.getRecordFromToken(token)
+		err = e.encodeToken(token)
 		if err != nil {
-			return e.bin.Bytes(), err
-		}
-		if record.isStartElement() {
-			elementWriter := record.(elementRecordEncoder)
-			err = elementWriter.encodeElement(e, token.(xml.StartElement))
-		} else if record.isText() {
-			textWriter := record.(textRecordEncoder)
-			if _, ok := token.(xml.Comment); ok {
-				err = textWriter.encodeText(e, textWriter, string(token.(xml.Comment)))
-			} else {
-				err = textWriter.encodeText(e, textWriter, string(token.(xml.CharData)))
-			}
-		} else if record.isEndElement() {
-			elementWriter := record.(elementRecordEncoder)
-			err = elementWriter.encodeElement(e, xml.StartElement{})
-		} else {
-			err = errors.New(fmt.Sprint("NotSupported: Encoding record", record))
+			return fmt.Errorf("Error writing Token %s :: %s", token, err.Error())
 		}
-		if err != nil {
-			return e.bin.Bytes(), fmt.Errorf("Error writing Token %s :: %s", token, err.Error())
+		if e.out != nil && buf.Len() >= encodeFlushSize {
+			if _, err = e.out.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
 		}
 		token, err = e.popToken()
 	}
-	return e.bin.Bytes(), nil
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
 }
 
-func (e *encoder) getRecordFromToken(token xml.Token) (record, error) {
-	switch token.(type) {
+func (e *encoder) encodeToken(token xml.Token) error {
+	switch t := token.(type) {
 	case xml.StartElement:
-		return e.getStartElementRecordFromToken(token.(xml.StartElement))
+		e.startScope(t)
+		record, err := e.getStartElementRecordFromToken(t)
+		if err != nil {
+			return err
+		}
+		elementWriter := record.(elementRecordEncoder)
+		return elementWriter.encodeElement(e, t)
 	case xml.CharData:
-		return e.getTextRecordFromToken(token.(xml.CharData))
+		text := string(t)
+		whitespace := isWhitespace(text)
+		if whitespace && e.dropsWhitespace() {
+			return nil
+		}
+		kind := e.textKind(xml.Name{})
+		if whitespace && kind == TextAuto {
+			// whitespace is never a number or a dictionary string
+			kind = TextChars
+		}
+		record, withEndElement, err := e.getTextRecordFromToken(text, kind)
+		if err != nil {
+			return err
+		}
+		if withEndElement {
+			e.endScope()
+		}
+		textWriter := record.(textRecordEncoder)
+		return textWriter.encodeText(e, textWriter, text)
 	case xml.EndElement:
-		return records[endElement], nil
+		e.endScope()
+		elementWriter := records[endElement].(elementRecordEncoder)
+		return elementWriter.encodeElement(e, xml.StartElement{})
 	case xml.Comment:
-		return records[comment], nil
+		textWriter := records[comment].(textRecordEncoder)
+		return textWriter.encodeText(e, textWriter, string(t))
+	case xml.ProcInst:
+		// the XML declaration only describes the text encoding, which NBFX fixes as UTF-8
+		if t.Target == "xml" || e.opts.ProcInst == NodeDrop {
+			return nil
+		}
+		return fmt.Errorf("NBFX can't represent the processing instruction %s", t.Target)
+	case xml.Directive:
+		if e.opts.Directive == NodeDrop {
+			return nil
+		}
+		return fmt.Errorf("NBFX can't represent the directive <!%s>", string(t))
 	}
 
 	tokenXmlBytes, err := xml.Marshal(token)
@@ -113,91 +180,214 @@ func (e *encoder) getRecordFromToken(token xml.Token) (record, error) {
 	} else {
 		tokenXml = string(tokenXmlBytes)
 	}
-	return nil, errors.New(fmt.Sprint("Unknown token", tokenXml))
+	return errors.New(fmt.Sprint("Unknown token", tokenXml))
+}
+
+// tracksPath reports whether element paths are needed while encoding. Namespace declarations
+// are always tracked, as QName text is only written with a prefix that is in scope.
+func (e *encoder) tracksPath() bool {
+	return e.opts.TypeHint != nil
+}
+
+func (e *encoder) startScope(element xml.StartElement) {
+	e.ns.push(element.Attr)
+	if e.tracksPath() {
+		e.path = append(e.path, e.ns.resolve(element.Name, false))
+	}
+	if e.opts.Whitespace == WhitespaceXmlSpace {
+		preserve := len(e.space) > 0 && e.space[len(e.space)-1]
+		for _, attr := range element.Attr {
+			if attr.Name.Space == "xml" && attr.Name.Local == "space" {
+				preserve = attr.Value == "preserve"
+			}
+		}
+		e.space = append(e.space, preserve)
+	}
+}
+
+func (e *encoder) endScope() {
+	e.ns.pop()
+	if e.tracksPath() && len(e.path) > 0 {
+		e.path = e.path[:len(e.path)-1]
+	}
+	if len(e.space) > 0 {
+		e.space = e.space[:len(e.space)-1]
+	}
 }
 
-func (e *encoder) getTextRecordFromToken(cd xml.CharData) (record, error) {
+// dropsWhitespace reports whether whitespace text at the current position is left out
+func (e *encoder) dropsWhitespace() bool {
+	switch e.opts.Whitespace {
+	case WhitespaceDrop:
+		return true
+	case WhitespaceXmlSpace:
+		return len(e.space) == 0 || !e.space[len(e.space)-1]
+	}
+	return false
+}
+
+func isWhitespace(text string) bool {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// textKind asks the TypeHint for the kind of the current element's text, or of its attribute attr
+func (e *encoder) textKind(attr xml.Name) TextKind {
+	// text outside the document element, such as a trailing newline, has no element to hint for
+	if e.opts.TypeHint == nil || len(e.path) == 0 {
+		return TextAuto
+	}
+	if attr.Local != "" {
+		attr = e.ns.resolve(attr, true)
+	}
+	return e.opts.TypeHint(e.path, attr)
+}
+
+func (e *encoder) getAttributeTextRecord(attr xml.Attr) (record, error) {
+	return e.getTextRecordFromText(attr.Value, e.textKind(attr.Name), false)
+}
+
+func (e *encoder) getTextRecordFromToken(text string, kind TextKind) (record, bool, error) {
 	withEndElement := false
-	next, _ := e.popToken()
+	next, err := e.popToken()
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
 	switch next.(type) {
 	case xml.EndElement:
 		withEndElement = true
 	}
-	text := string(cd)
-	if !withEndElement {
+	if !withEndElement && next != nil {
 		e.pushToken(next)
 	}
-	return e.getTextRecordFromText(text, withEndElement)
+	record, err := e.getTextRecordFromText(text, kind, withEndElement)
+	return record, withEndElement, err
 }
 
-func (e *encoder) getTextRecordFromText(text string, withEndElement bool) (record, error) {
-	var id byte
-	id = 0x00
-	if text == "" {
-		id = emptyText
-	} else if text == "0" {
-		id = zeroText
-	} else if text == "1" {
-		id = oneText
-	} else if text == "false" {
-		id = falseText
-	} else if text == "true" {
-		id = trueText
-	} else if strings.Contains(text, " ") {
-		id = startListText
-	} else if isUuid(text) {
-		id = uuidText
-	} else if isUniqueId(text) {
-		id = uniqueIdText
-	} else if i, err := strconv.ParseInt(text, 10, 0); err == nil {
-		if math.MinInt8 <= i && i <= math.MaxInt8 {
-			id = int8Text
-		} else if math.MinInt16 <= i && i <= math.MaxInt16 {
-			id = int16Text
-		} else if math.MinInt32 <= i && i <= math.MaxInt32 {
-			id = int32Text
-		} else if math.MinInt64 <= i && i <= math.MaxInt64 {
-			id = int64Text
-		} else {
-			return nil, fmt.Errorf("Unknown integer record %v", i)
-		}
-	} else if _, err := strconv.ParseUint(text, 10, 0); err == nil {
-		id = uInt64Text
-	} else if _, err := strconv.ParseFloat(text, 64); err == nil {
-		if isFloat32(text) {
-			id = floatText
-		} else {
-			id = doubleText
-		}
-	} else if bSlice, err := b64.DecodeString(text); err == nil {
-		lenBytes := len(bSlice)
-		if lenBytes <= math.MaxUint8 {
-			id = bytes8Text
-		} else if lenBytes < math.MaxUint16 {
-			id = bytes16Text
-		} else if lenBytes < math.MaxUint32 {
-			id = bytes32Text
-		} else {
-			return nil, fmt.Errorf("Base64 text too long, didn't encode: %v", text)
-		}
-	} else {
-		if _, ok := e.dict[text]; ok || hasSpecialDictionaryPrefix(text) {
-			id = dictionaryText
-		} else if isQNameDictionaryText(text) {
-			id = qNameDictionaryText
-		} else {
-			lenText := len(text)
-			if lenText <= math.MaxUint8 {
-				id = chars8Text
-			} else if lenText < math.MaxUint16 {
-				id = chars16Text
-			} else if lenText < math.MaxUint32 {
-				id = chars32Text
-			} else {
-				return nil, fmt.Errorf("Text too long, didn't encode: %v", text)
+// textTraits summarises a single pass over a text value, so that
+// getTextRecordFromText only runs the parsers that can succeed
+type textTraits struct {
+	isInteger bool // optional sign followed by decimal digits
+	isNumber  bool // only characters found in decimal and exponent notation
+	isBase64  bool // base64 alphabet with a valid padded length
+}
+
+func scanText(text string) textTraits {
+	traits := textTraits{isInteger: true, isNumber: true, isBase64: len(text)%4 == 0}
+	digits, padding := 0, 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case '0' <= c && c <= '9':
+			digits++
+			if padding > 0 {
+				traits.isBase64 = false
+			}
+		case c == '+' || c == '-':
+			if i > 0 {
+				traits.isInteger = false
 			}
+			if c == '-' || padding > 0 {
+				traits.isBase64 = false
+			}
+		case c == '.':
+			traits.isInteger = false
+			traits.isBase64 = false
+		case c == 'e' || c == 'E':
+			traits.isInteger = false
+			if padding > 0 {
+				traits.isBase64 = false
+			}
+		case c == '=':
+			traits.isInteger = false
+			traits.isNumber = false
+			padding++
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '/':
+			traits.isInteger = false
+			traits.isNumber = false
+			if padding > 0 {
+				traits.isBase64 = false
+			}
+		default:
+			traits.isInteger = false
+			traits.isNumber = false
+			traits.isBase64 = false
 		}
 	}
+	if digits == 0 {
+		traits.isInteger = false
+		traits.isNumber = isSpecialFloat(text)
+	}
+	if padding > 2 || traits.isBase64 && !isCanonicalBase64Padding(text, padding) {
+		traits.isBase64 = false
+	}
+	return traits
+}
+
+// isCanonicalBase64Padding reports whether the unused bits before any padding are zero,
+// as otherwise the text would not survive decoding and re-encoding
+func isCanonicalBase64Padding(text string, padding int) bool {
+	switch padding {
+	case 1:
+		return base64Index(text[len(text)-2])&0x3 == 0
+	case 2:
+		return base64Index(text[len(text)-3])&0xF == 0
+	}
+	return true
+}
+
+func base64Index(c byte) byte {
+	switch {
+	case 'A' <= c && c <= 'Z':
+		return c - 'A'
+	case 'a' <= c && c <= 'z':
+		return c - 'a' + 26
+	case '0' <= c && c <= '9':
+		return c - '0' + 52
+	case c == '+':
+		return 62
+	}
+	return 63
+}
+
+// isSpecialFloat reports whether text is an infinity or NaN as XmlConvert writes them
+func isSpecialFloat(text string) bool {
+	return text == "INF" || text == "-INF" || text == "NaN"
+}
+
+// base64DecodedLen returns the number of bytes encoded by a padded base64 string
+func base64DecodedLen(text string) int {
+	n := len(text) / 4 * 3
+	if strings.HasSuffix(text, "==") {
+		return n - 2
+	} else if strings.HasSuffix(text, "=") {
+		return n - 1
+	}
+	return n
+}
+
+func (e *encoder) getTextRecordFromText(text string, kind TextKind, withEndElement bool) (record, error) {
+	if kind == TextAuto && e.opts.StringsOnly {
+		kind = TextChars
+	}
+	var id byte
+	var err error
+	if kind == TextAuto && e.opts.Strategy == WCFCompatible {
+		id, err = e.getWCFTextRecordId(text)
+	} else if kind == TextAuto {
+		id, err = e.getTextRecordId(text)
+	} else {
+		id, err = e.getHintedTextRecordId(text, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
 	if id != 0 && withEndElement {
 		id += 1
 	}
@@ -207,21 +397,238 @@ func (e *encoder) getTextRecordFromText(text string, withEndElement bool) (recor
 	return nil, fmt.Errorf("Unknown text record id %#X for %s withEndElement %v", id, text, withEndElement)
 }
 
-func isQNameDictionaryText(text string) bool {
-	if text[1] != ':' {
+func (e *encoder) getTextRecordId(text string) (byte, error) {
+	switch text {
+	case "":
+		return emptyText, nil
+	case "0":
+		return zeroText, nil
+	case "1":
+		return oneText, nil
+	case "false":
+		return falseText, nil
+	case "true":
+		return trueText, nil
+	}
+	traits := scanText(text)
+	if isUuid(text) {
+		return uuidText, nil
+	}
+	if isUniqueId(text) {
+		return uniqueIdText, nil
+	}
+	if traits.isInteger && isCanonicalInt(text) {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return getIntTextRecordId(i), nil
+		}
+		if _, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return uInt64Text, nil
+		}
+	}
+	if traits.isNumber {
+		if isCanonicalFloat(text, 32) {
+			return floatText, nil
+		}
+		if isCanonicalFloat(text, 64) {
+			return doubleText, nil
+		}
+	}
+	if traits.isBase64 {
+		return getBytesTextRecordId(text)
+	}
+	if e.isDictionaryString(text) {
+		return dictionaryText, nil
+	}
+	if e.isQNameDictionaryText(text) {
+		return qNameDictionaryText, nil
+	}
+	return getCharsTextRecordId(text)
+}
+
+func (e *encoder) getWCFTextRecordId(text string) (byte, error) {
+	switch text {
+	case "":
+		return emptyText, nil
+	case "false":
+		return falseText, nil
+	case "true":
+		return trueText, nil
+	}
+	if isCanonicalInt(text) {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			if i == 0 {
+				return zeroText, nil
+			} else if i == 1 {
+				return oneText, nil
+			}
+			return getIntTextRecordId(i), nil
+		}
+	}
+	if e.isDictionaryString(text) {
+		return dictionaryText, nil
+	}
+	return getCharsTextRecordId(text)
+}
+
+// isCanonicalInt reports whether text is an integer written the way .NET writes one,
+// without a plus sign or leading zeros
+func isCanonicalInt(text string) bool {
+	digits := text
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || digits[0] == '0' && (len(digits) > 1 || len(text) > 1) {
 		return false
 	}
-	prefix := text[0]
-	return 'a' <= prefix && prefix <= 'z'
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return false
+		}
+	}
+	return true
 }
 
-func isFloat32(s string) bool {
-	f3264, err := strconv.ParseFloat(s, 32)
-	if err != nil {
+func (e *encoder) getHintedTextRecordId(text string, kind TextKind) (byte, error) {
+	switch kind {
+	case TextChars:
+		if text == "" {
+			return emptyText, nil
+		}
+		return getCharsTextRecordId(text)
+	case TextBool:
+		if text == "true" {
+			return trueText, nil
+		} else if text == "false" {
+			return falseText, nil
+		}
+	case TextInt:
+		if !isCanonicalInt(text) {
+			break
+		}
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			if i == 0 {
+				return zeroText, nil
+			} else if i == 1 {
+				return oneText, nil
+			}
+			return getIntTextRecordId(i), nil
+		}
+	case TextUInt64:
+		if !isCanonicalInt(text) {
+			break
+		}
+		if _, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return uInt64Text, nil
+		}
+	case TextFloat:
+		if _, err := ParseFloat(text, 32); err == nil {
+			return floatText, nil
+		}
+	case TextDouble:
+		if _, err := ParseFloat(text, 64); err == nil {
+			return doubleText, nil
+		}
+	case TextDecimal:
+		if _, err := ParseDecimal(text); err == nil {
+			return decimalText, nil
+		}
+	case TextDateTime:
+		if _, err := ParseDateTime(text); err == nil {
+			return dateTimeText, nil
+		}
+	case TextTimeSpan:
+		if _, err := ParseTimeSpan(text); err == nil {
+			return timeSpanText, nil
+		}
+	case TextBytes:
+		if scanText(text).isBase64 {
+			return getBytesTextRecordId(text)
+		}
+	case TextUuid:
+		if isUuid(text) {
+			return uuidText, nil
+		}
+	case TextUniqueId:
+		if isUniqueId(text) {
+			return uniqueIdText, nil
+		}
+	case TextDictionary:
+		if e.isDictionaryString(text) {
+			return dictionaryText, nil
+		}
+	case TextQNameDictionary:
+		prefix := ""
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			prefix = text[:i]
+		}
+		if _, ok := e.ns.lookup(prefix); !ok {
+			return 0, fmt.Errorf("Undeclared prefix %s in QName %s", prefix, text)
+		}
+		if e.isQNameDictionaryText(text) {
+			return qNameDictionaryText, nil
+		}
+		// QNames without a single letter prefix or a dictionary name are written as characters
+		return getCharsTextRecordId(text)
+	case TextList:
+		return startListText, nil
+	default:
+		return 0, fmt.Errorf("Unknown %v for %s", kind, text)
+	}
+	return 0, fmt.Errorf("Cannot encode %q as %v text", text, kind)
+}
+
+func getIntTextRecordId(i int64) byte {
+	if math.MinInt8 <= i && i <= math.MaxInt8 {
+		return int8Text
+	} else if math.MinInt16 <= i && i <= math.MaxInt16 {
+		return int16Text
+	} else if math.MinInt32 <= i && i <= math.MaxInt32 {
+		return int32Text
+	}
+	return int64Text
+}
+
+func getBytesTextRecordId(text string) (byte, error) {
+	lenBytes := base64DecodedLen(text)
+	if lenBytes <= math.MaxUint8 {
+		return bytes8Text, nil
+	} else if lenBytes <= math.MaxUint16 {
+		return bytes16Text, nil
+	} else if lenBytes <= math.MaxInt32 {
+		return bytes32Text, nil
+	}
+	return 0, fmt.Errorf("Base64 text too long, didn't encode: %v", text)
+}
+
+func getCharsTextRecordId(text string) (byte, error) {
+	lenText := len(text)
+	if lenText <= math.MaxUint8 {
+		return chars8Text, nil
+	} else if lenText <= math.MaxUint16 {
+		return chars16Text, nil
+	} else if lenText <= math.MaxInt32 {
+		return chars32Text, nil
+	}
+	return 0, fmt.Errorf("Text too long, didn't encode: %v", text)
+}
+
+func (e *encoder) isDictionaryString(text string) bool {
+	_, ok := e.dict[text]
+	return ok || hasSpecialDictionaryPrefix(text)
+}
+
+// isQNameDictionaryText reports whether text is a QName QNameDictionaryText can hold: a single
+// letter prefix that is declared in scope and a dictionary local name
+func (e *encoder) isQNameDictionaryText(text string) bool {
+	if len(text) < 3 || text[1] != ':' {
+		return false
+	}
+	prefix := text[0]
+	if prefix < 'a' || 'z' < prefix || !e.isDictionaryString(text[2:]) {
 		return false
 	}
-	f32 := float32(f3264)
-	return fmt.Sprint(f32) == s
+	_, ok := e.ns.lookup(text[:1])
+	return ok
 }
 
 func (e *encoder) getStartElementRecordFromToken(startElement xml.StartElement) (record, error) {
@@ -293,7 +700,8 @@ func (e *encoder) getAttributeRecordFromToken(attr xml.Attr) (record, error) {
 		}
 	} else {
 		if isXmlns {
-			if isNameIndexAssigned || valueHasStrPrefix {
+			// the prefix is written as a string, only the namespace can come from the dictionary
+			if _, ok := e.dict[attr.Value]; ok || valueHasStrPrefix {
 				return records[dictionaryXmlnsAttribute], nil
 			} else {
 				return records[xmlnsAttribute], nil
@@ -308,17 +716,29 @@ func (e *encoder) getAttributeRecordFromToken(attr xml.Attr) (record, error) {
 	}
 }
 
+// hasSpecialDictionaryPrefix reports whether str is a "strN" reference to dictionary key N,
+// the form the decoder writes for keys missing from its dictionary
 func hasSpecialDictionaryPrefix(str string) bool {
-	return strings.HasPrefix(str, "str")
+	_, ok := specialDictionaryKey(str)
+	return ok
+}
+
+// specialDictionaryKey returns N for a "strN" reference. N must be written as the decoder writes
+// it, without leading zeros, and fit in a MultiByteInt31, so other text is never rewritten.
+func specialDictionaryKey(str string) (uint32, bool) {
+	if len(str) <= 3 || !strings.HasPrefix(str, "str") || str[3] == '0' && len(str) > 4 || str[3] == '+' {
+		return 0, false
+	}
+	key, err := strconv.ParseUint(str[3:], 10, 31)
+	return uint32(key), err == nil
 }
 
 func writeString(e *encoder, str string) (int, error) {
-	var strBytes = []byte(str)
-	lenByteLen, err := writeMultiByteInt31(e, uint32(len(strBytes)))
+	lenByteLen, err := writeMultiByteInt31(e, uint32(len(str)))
 	if err != nil {
 		return lenByteLen, err
 	}
-	strByteLen, err := e.bin.Write(strBytes)
+	strByteLen, err := e.bin.WriteString(str)
 	return lenByteLen + strByteLen, err
 }
 
@@ -340,46 +760,105 @@ func writeMultiByteInt31(e *encoder, num uint32) (int, error) {
 	return n + 1, err
 }
 
+func writeUint16(e *encoder, val uint16) error {
+	binary.LittleEndian.PutUint16(e.scratch[:2], val)
+	_, err := e.bin.Write(e.scratch[:2])
+	return err
+}
+
+func writeUint32(e *encoder, val uint32) error {
+	binary.LittleEndian.PutUint32(e.scratch[:4], val)
+	_, err := e.bin.Write(e.scratch[:4])
+	return err
+}
+
+func writeUint64(e *encoder, val uint64) error {
+	binary.LittleEndian.PutUint64(e.scratch[:8], val)
+	_, err := e.bin.Write(e.scratch[:8])
+	return err
+}
+
 func writeChars8Text(e *encoder, text string) error {
-	bytes := []byte(text)
-	writeMultiByteInt31(e, uint32(len(bytes)))
-	_, err := e.bin.Write(bytes)
+	err := e.bin.WriteByte(uint8(len(text)))
+	if err != nil {
+		return err
+	}
+	_, err = e.bin.WriteString(text)
 	return err
 }
 
 func writeChars16Text(e *encoder, text string) error {
-	bytes := []byte(text)
-	err := binary.Write(e.bin, binary.LittleEndian, uint16(len(bytes)))
+	err := writeUint16(e, uint16(len(text)))
 	if err != nil {
 		return err
 	}
-	_, err = e.bin.Write(bytes)
+	_, err = e.bin.WriteString(text)
 	return err
 }
 
 func writeChars32Text(e *encoder, text string) error {
-	bytes := []byte(text)
 	// PER SPEC: int32 NOT uint32
-	err := binary.Write(e.bin, binary.LittleEndian, int32(len(bytes)))
+	err := writeUint32(e, uint32(int32(len(text))))
 	if err != nil {
 		return err
 	}
-	_, err = e.bin.Write(bytes)
+	_, err = e.bin.WriteString(text)
 	return err
 }
 
+// decodeBase64 decodes text into scratch space owned by the encoder, which
+// is only valid until the next call
+func (e *encoder) decodeBase64(text string) ([]byte, error) {
+	e.textBuf = append(e.textBuf[:0], text...)
+	if n := b64.DecodedLen(len(text)); cap(e.bytesBuf) < n {
+		e.bytesBuf = make([]byte, n)
+	}
+	n, err := b64.Decode(e.bytesBuf[:cap(e.bytesBuf)], e.textBuf)
+	if err != nil {
+		return nil, err
+	}
+	return e.bytesBuf[:n], nil
+}
+
 func writeUuidText(e *encoder, text string) error {
-	id, err := uuid.FromString(text)
-	bin := id.Bytes()
-	bin, err = flipUuidByteOrder(bin)
+	id, err := ParseGuid(text)
 	if err != nil {
 		return err
 	}
-	_, err = e.bin.Write(bin)
+	return writeGuid(e, id)
+}
+
+func writeGuid(e *encoder, id Guid) error {
+	_, err := e.bin.Write(id.ByteArray())
+	return err
+}
+
+func writeDecimal(e *encoder, d Decimal) error {
+	if d.Scale > maxDecimalScale {
+		return fmt.Errorf("Invalid decimal scale %d", d.Scale)
+	}
+	var sign byte
+	if d.Negative {
+		sign = 0x80
+	}
+	// wReserved, scale and sign, then the 96 bit integer as Hi32 and Lo64
+	err := writeUint32(e, uint32(d.Scale)<<16|uint32(sign)<<24)
 	if err != nil {
 		return err
 	}
-	return nil
+	err = writeUint32(e, d.Hi32)
+	if err != nil {
+		return err
+	}
+	return writeUint64(e, d.Lo64)
+}
+
+func writeDateTime(e *encoder, dt DateTime) error {
+	bin, err := dt.binary()
+	if err != nil {
+		return err
+	}
+	return writeUint64(e, bin)
 }
 
 func writeDictionaryString(e *encoder, str string) error {
@@ -388,14 +867,9 @@ func writeDictionaryString(e *encoder, str string) error {
 		if err != nil {
 			return err
 		}
-	} else if hasSpecialDictionaryPrefix(str) {
-		// capture "8" in "str8" and write "8"
-		numString := str[3:]
-		numInt, err := strconv.Atoi(numString)
-		if err != nil {
-			return err
-		}
-		_, err = writeMultiByteInt31(e, uint32(numInt))
+	} else if key, ok := specialDictionaryKey(str); ok {
+		// "str8" is written as key 8
+		_, err := writeMultiByteInt31(e, key)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,181 @@
+package nbfx
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// TextKind selects the text record used to encode a text value
+type TextKind int
+
+const (
+	// TextAuto lets the encoder pick a record from the text itself
+	TextAuto TextKind = iota
+	// TextChars encodes the text as characters (EmptyText or Chars8/16/32Text)
+	TextChars
+	// TextBool encodes "true" or "false" as TrueText or FalseText
+	TextBool
+	// TextInt encodes a signed integer with the smallest of ZeroText, OneText and Int8/16/32/64Text
+	TextInt
+	// TextUInt64 encodes an unsigned integer as UInt64Text
+	TextUInt64
+	// TextFloat encodes a number as FloatText
+	TextFloat
+	// TextDouble encodes a number as DoubleText
+	TextDouble
+	// TextBytes encodes base64 text as Bytes8/16/32Text
+	TextBytes
+	// TextUuid encodes a uuid as UuidText
+	TextUuid
+	// TextUniqueId encodes a "urn:uuid:" uuid as UniqueIdText
+	TextUniqueId
+	// TextDictionary encodes a dictionary string as DictionaryText
+	TextDictionary
+	// TextQNameDictionary encodes a "p:name" QName with a dictionary name as QNameDictionaryText,
+	// and other QNames as characters. The prefix must be declared in scope.
+	TextQNameDictionary
+	// TextList encodes whitespace separated items as a StartListText ... EndListText list,
+	// collapsing the whitespace between them as in an xsd:list. Lists are only written for
+	// text hinted this way.
+	TextList
+	// TextDecimal encodes a number without an exponent as DecimalText
+	TextDecimal
+	// TextDateTime encodes a date and time as DateTimeText, keeping whether it is UTC, local or unspecified
+	TextDateTime
+	// TextTimeSpan encodes an xsd:duration as TimeSpanText
+	TextTimeSpan
+)
+
+var textKindNames = [...]string{"Auto", "Chars", "Bool", "Int", "UInt64", "Float", "Double", "Bytes", "Uuid", "UniqueId", "Dictionary", "QNameDictionary", "List", "Decimal", "DateTime", "TimeSpan"}
+
+func (k TextKind) String() string {
+	if 0 <= k && int(k) < len(textKindNames) {
+		return textKindNames[k]
+	}
+	return fmt.Sprintf("TextKind(%d)", int(k))
+}
+
+// TypeHintFunc chooses the TextKind for a text value.
+//
+// path holds the namespace-resolved names of the open elements, outermost first,
+// and is only valid for the duration of the call. It is never empty: text outside
+// the document element is encoded as TextAuto without calling the hint. attr is the namespace-resolved
+// attribute name, or the zero Name for the text content of the innermost element.
+// Returning TextAuto defers to the encoder.
+type TypeHintFunc func(path []xml.Name, attr xml.Name) TextKind
+
+// Strategy selects how an Encoder picks a text record when several would decode to the same text
+type Strategy int
+
+const (
+	// Compact picks the smallest record, preferring typed records over characters on ties
+	Compact Strategy = iota
+	// WCFCompatible picks the records .NET's XmlBinaryWriter writes for the same XML: names and
+	// namespaces found in the dictionary use dictionary records, text followed by an end element
+	// uses the WithEndElement record, canonical integers and booleans use the sized numeric and
+	// bool records, and all other text is EmptyText or Chars8/16/32Text by UTF-8 length.
+	// Use a TypeHint for values .NET writes typed, such as byte arrays and Guids.
+	WCFCompatible
+)
+
+// WhitespaceMode selects how an Encoder handles text made only of spaces, tabs and line breaks,
+// such as the indentation of pretty-printed XML
+type WhitespaceMode int
+
+const (
+	// WhitespacePreserve encodes whitespace text as characters
+	WhitespacePreserve WhitespaceMode = iota
+	// WhitespaceDrop leaves whitespace text out as insignificant
+	WhitespaceDrop
+	// WhitespaceXmlSpace drops whitespace text except inside elements with xml:space="preserve",
+	// where it is encoded as characters. xml:space="default" drops it again.
+	WhitespaceXmlSpace
+)
+
+// NodePolicy selects what an Encoder does with XML nodes NBFX can't represent
+type NodePolicy int
+
+const (
+	// NodeError fails the encoding
+	NodeError NodePolicy = iota
+	// NodeDrop leaves the node out of the encoding
+	NodeDrop
+)
+
+// EncoderOptions controls how an Encoder chooses text records
+type EncoderOptions struct {
+	// Strategy picks records for text the TypeHint leaves as TextAuto
+	Strategy Strategy
+	// StringsOnly encodes every text value the TypeHint leaves as TextAuto as characters,
+	// so values are never reinterpreted as numbers, base64 or dictionary strings
+	StringsOnly bool
+	// TypeHint, when set, is asked for the TextKind of every text value and attribute value
+	TypeHint TypeHintFunc
+	// Whitespace handles text made only of whitespace. Text with other characters is always kept.
+	Whitespace WhitespaceMode
+	// ProcInst handles processing instructions other than the XML declaration, which is always skipped
+	ProcInst NodePolicy
+	// Directive handles DOCTYPE and other <!...> directives
+	Directive NodePolicy
+}
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+type nsBinding struct {
+	prefix string
+	uri    string
+}
+
+// nsScope tracks the namespace declarations in scope while encoding
+type nsScope struct {
+	bindings []nsBinding
+	marks    []int
+}
+
+func (s *nsScope) push(attrs []xml.Attr) {
+	s.marks = append(s.marks, len(s.bindings))
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" {
+			s.bindings = append(s.bindings, nsBinding{attr.Name.Local, attr.Value})
+		} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			s.bindings = append(s.bindings, nsBinding{"", attr.Value})
+		}
+	}
+}
+
+func (s *nsScope) pop() {
+	if len(s.marks) == 0 {
+		return
+	}
+	s.bindings = s.bindings[:s.marks[len(s.marks)-1]]
+	s.marks = s.marks[:len(s.marks)-1]
+}
+
+func (s *nsScope) reset() {
+	s.bindings = s.bindings[:0]
+	s.marks = s.marks[:0]
+}
+
+func (s *nsScope) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for i := len(s.bindings) - 1; i >= 0; i-- {
+		if s.bindings[i].prefix == prefix {
+			return s.bindings[i].uri, true
+		}
+	}
+	return "", prefix == ""
+}
+
+// resolve maps a raw prefix:name to namespace:name. Unprefixed attributes are in no namespace,
+// and unbound prefixes are left in place.
+func (s *nsScope) resolve(name xml.Name, isAttr bool) xml.Name {
+	if name.Space == "" && isAttr {
+		return name
+	}
+	if uri, ok := s.lookup(name.Space); ok {
+		return xml.Name{Space: uri, Local: name.Local}
+	}
+	return name
+}
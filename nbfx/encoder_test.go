@@ -3,9 +3,12 @@ package nbfx
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/xml"
 	"fmt"
 	"math"
+	"strings"
 	"testing"
+	"unicode/utf16"
 )
 
 func TestEncodeExampleEndElement(t *testing.T) {
@@ -267,30 +270,33 @@ func TestEncodeExampleDoubleTextWithEndElement(t *testing.T) {
 		"<PI>3.14159265358979</PI>")
 }
 
+// hintAll encodes all text as kind, for the records the encoder does not pick by itself
+func hintAll(kind TextKind) EncoderOptions {
+	return EncoderOptions{TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+		return kind
+	}}
+}
+
 func TestEncodeExampleDecimalText(t *testing.T) {
-	t.Skip("TODO")
-	testEncode(t,
+	testEncodeWithOptions(t, hintAll(TextDecimal),
 		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x04, 0x03, 0x69, 0x6E, 0x74, 0x94, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x2D, 0x4E, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
 		"<doc int=\"5.123456\"></doc>")
 }
 
 func TestEncodeExampleDecimalTextWithEndElement(t *testing.T) {
-	t.Skip("TODO")
-	testEncode(t,
+	testEncodeWithOptions(t, hintAll(TextDecimal),
 		[]byte{0x40, 0x08, 0x4D, 0x61, 0x78, 0x56, 0x61, 0x6C, 0x75, 0x65, 0x95, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
 		"<MaxValue>79228162514264337593543950335</MaxValue>")
 }
 
 func TestEncodeExampleDateTimeText(t *testing.T) {
-	t.Skip("TODO")
-	testEncode(t,
+	testEncodeWithOptions(t, hintAll(TextDateTime),
 		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x06, 0x6E, 0x96, 0xFF, 0x3F, 0x37, 0xF4, 0x75, 0x28, 0xCA, 0x2B, 0x01},
 		"<doc str110=\"9999-12-31T23:59:59.9999999\"></doc>")
 }
 
 func TestEncodeExampleDateTimeTextWithEndElement(t *testing.T) {
-	t.Skip("TODO")
-	testEncode(t,
+	testEncodeWithOptions(t, hintAll(TextDateTime),
 		[]byte{0x42, 0x6C, 0x97, 0x00, 0x40, 0x8E, 0xF9, 0x5B, 0x47, 0xC8, 0x08},
 		"<str108>2006-05-17T00:00:00</str108>")
 }
@@ -307,6 +313,21 @@ func TestEncodeExampleChars8TextWithEndElement(t *testing.T) {
 		"<a>hello</a>")
 }
 
+func TestEncodeChars8TextLongerThanMbi31Byte(t *testing.T) {
+	n := math.MaxUint8 - 54
+	bytBuffer := bytes.NewBuffer([]byte{0x40, 0x01, 0x61, 0x99, byte(n)})
+	strBuffer := bytes.Buffer{}
+	strBuffer.WriteString("<a>")
+	for i := 0; i < n; i++ {
+		bytBuffer.WriteByte(0x62)
+		strBuffer.WriteString("b")
+	}
+	strBuffer.WriteString("</a>")
+	testEncode(t,
+		bytBuffer.Bytes(),
+		strBuffer.String())
+}
+
 func TestEncodeExampleChars16Text(t *testing.T) {
 	n := math.MaxUint8 + 2
 	bytBuffer := bytes.NewBuffer([]byte{0x40, 0x01, 0x61, 0x06, 0x00, 0x9A})
@@ -460,13 +481,13 @@ func TestEncodeExampleBytes32TextWithEndElement(t *testing.T) {
 }
 
 func TestEncodeExampleStartListText(t *testing.T) {
-	testEncode(t,
+	testEncodeWithOptions(t, hintAll(TextList),
 		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x04, 0x01, 0x61, 0xA4, 0x88, 0x7B, 0x98, 0x05, 0x68, 0x65, 0x6C, 0x6C, 0x6F, 0x86, 0xA6, 0x01},
 		"<doc a=\"123 hello true\"></doc>")
 }
 
 func TestEncodeExampleEndListText(t *testing.T) {
-	testEncode(t,
+	testEncodeWithOptions(t, hintAll(TextList),
 		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x04, 0x01, 0x61, 0xA4, 0x88, 0x7B, 0x98, 0x05, 0x68, 0x65, 0x6C, 0x6C, 0x6F, 0x86, 0xA6, 0x01},
 		"<doc a=\"123 hello true\"></doc>")
 }
@@ -508,6 +529,27 @@ func TestEncodeExampleDictionaryTextWithEndElement(t *testing.T) {
 		"<Type>str196</Type>")
 }
 
+func TestEncodeDictionaryTextOutOfRangeIsChars(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x99, 0x0E, 0x73, 0x74, 0x72, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39},
+		"<a>str99999999999</a>")
+}
+
+func TestEncodeDictionaryTextNonCanonicalIsChars(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x06, 0x73, 0x74, 0x72, 0x30, 0x30, 0x37, 0x99, 0x06, 0x73, 0x74, 0x72, 0x30, 0x30, 0x37},
+		"<str007>str007</str007>")
+}
+
+func TestSpecialDictionaryKey(t *testing.T) {
+	for s, want := range map[string]bool{"str0": true, "str8": true, "str2147483647": true,
+		"str": false, "str00": false, "str007": false, "str+5": false, "str-1": false, "str2147483648": false, "str99999999999": false} {
+		if _, ok := specialDictionaryKey(s); ok != want {
+			t.Errorf("specialDictionaryKey(%q) ok = %v, want %v", s, ok, want)
+		}
+	}
+}
+
 func TestEncodeExampleUniqueIdText(t *testing.T) {
 	testEncode(t,
 		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x06, 0x00, 0xAC, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF, 0x01},
@@ -528,8 +570,7 @@ func TestEncodeExampleTimeSpanText(t *testing.T) {
 }
 
 func TestEncodeExampleTimeSpanTextWithEndElement(t *testing.T) {
-	t.Skip("TODO")
-	testEncode(t,
+	testEncodeWithOptions(t, hintAll(TextTimeSpan),
 		[]byte{0x42, 0x94, 0x07, 0xAF, 0x00, 0xB0, 0x8E, 0xF0, 0x1B, 0x00, 0x00, 0x00},
 		"<str916>PT3H20M</str916>")
 }
@@ -621,16 +662,80 @@ func TestEncodeExampleUnicodeChars32TextWithEndElement(t *testing.T) {
 
 func TestEncodeExampleQNameDictionaryText(t *testing.T) {
 	testEncode(t,
-		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x06, 0xF0, 0x06, 0xBC, 0x08, 0x8E, 0x07, 0x01},
-		"<doc str880=\"i:str910\"></doc>")
+		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x09, 0x01, 0x69, 0x05, 0x75, 0x72, 0x6E, 0x3A, 0x69, 0x06, 0xF0, 0x06, 0xBC, 0x08, 0x8E, 0x07, 0x01},
+		"<doc xmlns:i=\"urn:i\" str880=\"i:str910\"></doc>")
 }
 
 func TestEncodeExampleQNameDictionaryTextWithEndElement(t *testing.T) {
 	testEncode(t,
-		[]byte{0x40, 0x04, 0x54, 0x79, 0x70, 0x65, 0xBD, 0x12, 0x90, 0x07},
+		[]byte{0x40, 0x04, 0x54, 0x79, 0x70, 0x65, 0x09, 0x01, 0x73, 0x05, 0x75, 0x72, 0x6E, 0x3A, 0x73, 0xBD, 0x12, 0x90, 0x07},
+		"<Type xmlns:s=\"urn:s\">s:str912</Type>")
+}
+
+func TestEncodeQNameDictionaryTextInheritsPrefix(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x09, 0x01, 0x73, 0x05, 0x75, 0x72, 0x6E, 0x3A, 0x73, 0x40, 0x04, 0x54, 0x79, 0x70, 0x65, 0xBD, 0x12, 0x90, 0x07, 0x01},
+		"<a xmlns:s=\"urn:s\"><Type>s:str912</Type></a>")
+}
+
+func TestEncodeQNameUndeclaredPrefixAsChars(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x04, 0x54, 0x79, 0x70, 0x65, 0x99, 0x08, 0x73, 0x3A, 0x73, 0x74, 0x72, 0x39, 0x31, 0x32},
 		"<Type>s:str912</Type>")
 }
 
+func TestEncodeQNameOutOfScopePrefixAsChars(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x40, 0x01, 0x62, 0x09, 0x01, 0x73, 0x05, 0x75, 0x72, 0x6E, 0x3A, 0x73, 0x01, 0x40, 0x04, 0x54, 0x79, 0x70, 0x65, 0x99, 0x08, 0x73, 0x3A, 0x73, 0x74, 0x72, 0x39, 0x31, 0x32, 0x01},
+		"<a><b xmlns:s=\"urn:s\"></b><Type>s:str912</Type></a>")
+}
+
+func TestEncodeQNameTypeAttribute(t *testing.T) {
+	xmlString := `<Shape xmlns:i="http://www.w3.org/2001/XMLSchema-instance" xmlns:b="urn:shapes" i:type="b:Circle"></Shape>`
+	encoder := NewEncoderWithStrings(map[uint32]string{0x02: "Circle"})
+	actual, err := encoder.Encode(bytes.NewReader([]byte(xmlString)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last attribute is a PrefixAttributeI whose value is QNameDictionaryText b:Circle
+	assertBinEqual(t, actual[len(actual)-10:], []byte{0x2E, 0x04, 0x74, 0x79, 0x70, 0x65, 0xBC, 0x01, 0x02, 0x01})
+
+	// a local name outside the dictionary is written as characters
+	actual, err = NewEncoder().Encode(bytes.NewReader([]byte(xmlString)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBinEqual(t, actual[len(actual)-17:], []byte{0x2E, 0x04, 0x74, 0x79, 0x70, 0x65, 0x98, 0x08, 0x62, 0x3A, 0x43, 0x69, 0x72, 0x63, 0x6C, 0x65, 0x01})
+	decoded, err := NewDecoder().Decode(bytes.NewReader(actual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, decoded, xmlString)
+}
+
+func TestEncodeQNameShortText(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x98, 0x01, 0x78, 0x40, 0x01, 0x62, 0x99, 0x02, 0x78, 0x3A, 0x01},
+		"<a>x<b>x:</b></a>")
+}
+
+func TestEncodeQNameTypeHint(t *testing.T) {
+	opts := EncoderOptions{TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+		if attr.Local == "type" {
+			return TextQNameDictionary
+		}
+		return TextAuto
+	}}
+	testEncodeWithOptions(t, opts,
+		[]byte{0x40, 0x01, 0x61, 0x09, 0x01, 0x62, 0x05, 0x75, 0x72, 0x6E, 0x3A, 0x62, 0x04, 0x04, 0x74, 0x79, 0x70, 0x65, 0x98, 0x06, 0x62, 0x3A, 0x4C, 0x69, 0x6E, 0x65, 0x01},
+		`<a xmlns:b="urn:b" type="b:Line"></a>`)
+
+	_, err := NewEncoderWithOptions(nil, opts).Encode(bytes.NewReader([]byte(`<a type="b:Line"></a>`)))
+	if err == nil || !strings.Contains(err.Error(), "Undeclared prefix b") {
+		t.Errorf("Expected an undeclared prefix error, got %v", err)
+	}
+}
+
 //----------------------------------------------------
 
 func TestEncodePrefixDictionaryElementB(t *testing.T) {
@@ -723,6 +828,270 @@ func testWriteMultiByteInt31(t *testing.T, num uint32, expected []byte) {
 	assertBinEqual(t, buffer.Bytes()[0:i], expected)
 }
 
+func TestEncodeStringsOnly(t *testing.T) {
+	testEncodeWithOptions(t, EncoderOptions{StringsOnly: true},
+		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x04, 0x01, 0x61, 0x98, 0x03, 0x31, 0x32, 0x33, 0x99, 0x04, 0x61, 0x62, 0x63, 0x64},
+		"<doc a=\"123\">abcd</doc>")
+}
+
+func TestEncodeTypeHintByResolvedPath(t *testing.T) {
+	var paths []string
+	opts := EncoderOptions{TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+		paths = append(paths, fmt.Sprint(path, attr))
+		if path[0] == (xml.Name{Space: "urn:x", Local: "doc"}) {
+			return TextChars
+		}
+		return TextAuto
+	}}
+	testEncodeWithOptions(t, opts,
+		[]byte{0x6D, 0x03, 0x64, 0x6F, 0x63, 0x09, 0x01, 0x70, 0x05, 0x75, 0x72, 0x6E, 0x3A, 0x78, 0x04, 0x01, 0x6E, 0x98, 0x02, 0x34, 0x32, 0x99, 0x04, 0x61, 0x62, 0x63, 0x64},
+		"<p:doc xmlns:p=\"urn:x\" n=\"42\">abcd</p:doc>")
+	assertEqual(t, fmt.Sprint(paths), "[[{urn:x doc}] { n} [{urn:x doc}] { }]")
+}
+
+func TestEncodeTypeHintMismatch(t *testing.T) {
+	opts := EncoderOptions{TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+		return TextInt
+	}}
+	encoder := NewEncoderWithOptions(nil, opts)
+	_, err := encoder.Encode(bytes.NewReader([]byte("<a>abc</a>")))
+	if err == nil {
+		t.Error("Expected error encoding abc as Int")
+	}
+}
+
+func TestEncodeNameStartingWithStr(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x06, 0x73, 0x74, 0x72, 0x69, 0x6E, 0x67, 0x99, 0x04, 0x61, 0x20, 0x20, 0x62},
+		"<string>a  b</string>")
+}
+
+func TestEncodeNonCanonicalBase64AsChars(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x99, 0x04, 0x61, 0x62, 0x3D, 0x3D},
+		"<a>ab==</a>")
+}
+
+func TestEncodeNonCanonicalIntegersAsChars(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x04, 0x01, 0x78, 0x98, 0x03, 0x30, 0x30, 0x37, 0x04, 0x01, 0x79, 0x98, 0x02, 0x2B, 0x35,
+			0x91, 0x00, 0x00, 0x00, 0x80},
+		"<a x=\"007\" y=\"+5\">-0</a>")
+	// -0 is the float negative zero rather than the integer 0
+	testDecode(t, []byte{0x40, 0x01, 0x61, 0x91, 0x00, 0x00, 0x00, 0x80}, "<a>-0</a>")
+}
+
+func TestEncodeTypeHintNonCanonicalInt(t *testing.T) {
+	for _, kind := range []TextKind{TextInt, TextUInt64} {
+		opts := EncoderOptions{TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+			return kind
+		}}
+		for _, text := range []string{"007", "+5", "-0"} {
+			_, err := NewEncoderWithOptions(nil, opts).Encode(bytes.NewReader([]byte("<a>" + text + "</a>")))
+			if err == nil {
+				t.Errorf("Expected error encoding %s as %v", text, kind)
+			}
+		}
+	}
+}
+
+func TestEncodeWCFCompatibleExampleBytes8TextWithTypeHint(t *testing.T) {
+	opts := EncoderOptions{Strategy: WCFCompatible, TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+		if attr.Local == "str0" {
+			return TextBytes
+		}
+		return TextAuto
+	}}
+	testEncodeWithOptions(t, opts,
+		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x06, 0x00, 0x9E, 0x08, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x01},
+		"<doc str0=\"AAECAwQFBgc=\"></doc>")
+}
+
+func TestEncodeWCFCompatibleBase64AsChars(t *testing.T) {
+	testEncodeWithOptions(t, EncoderOptions{Strategy: WCFCompatible},
+		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x06, 0x00, 0x98, 0x0C, 0x41, 0x41, 0x45, 0x43, 0x41, 0x77, 0x51, 0x46, 0x42, 0x67, 0x63, 0x3D, 0x01},
+		"<doc str0=\"AAECAwQFBgc=\"></doc>")
+}
+
+func TestEncodeWCFCompatibleExampleInt8TextWithEndElement(t *testing.T) {
+	testEncodeWithOptions(t, EncoderOptions{Strategy: WCFCompatible},
+		[]byte{0x42, 0x9A, 0x01, 0x89, 0x7F},
+		"<str154>127</str154>")
+}
+
+func TestEncodeWCFCompatibleExampleZeroAndTrueText(t *testing.T) {
+	testEncodeWithOptions(t, EncoderOptions{Strategy: WCFCompatible},
+		[]byte{0x42, 0xA0, 0x03, 0x06, 0x00, 0x86, 0x81},
+		"<str416 str0=\"true\">0</str416>")
+}
+
+func TestEncodeWCFCompatibleListAsChars(t *testing.T) {
+	testEncodeWithOptions(t, EncoderOptions{Strategy: WCFCompatible},
+		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x04, 0x01, 0x61, 0x98, 0x0E, 0x31, 0x32, 0x33, 0x20, 0x68, 0x65, 0x6C, 0x6C, 0x6F, 0x20, 0x74, 0x72, 0x75, 0x65, 0x01},
+		"<doc a=\"123 hello true\"></doc>")
+}
+
+func TestEncodeWCFCompatibleNonCanonicalIntAsChars(t *testing.T) {
+	testEncodeWithOptions(t, EncoderOptions{Strategy: WCFCompatible},
+		[]byte{0x40, 0x01, 0x61, 0x99, 0x03, 0x30, 0x30, 0x37},
+		"<a>007</a>")
+}
+
+func TestEncodeListOnlyWhenHinted(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x03, 0x64, 0x6F, 0x63, 0x04, 0x01, 0x61, 0x98, 0x0E, 0x31, 0x32, 0x33, 0x20, 0x68, 0x65, 0x6C, 0x6C, 0x6F, 0x20, 0x74, 0x72, 0x75, 0x65,
+			0x99, 0x08, 0x35, 0x35, 0x35, 0x20, 0x31, 0x32, 0x33, 0x34},
+		"<doc a=\"123 hello true\">555 1234</doc>")
+}
+
+func TestEncodeChars16TextAtMaxLength(t *testing.T) {
+	text := strings.Repeat("b", math.MaxUint16)
+	bytBuffer := bytes.NewBuffer([]byte{0x40, 0x01, 0x61, 0x9B, 0xFF, 0xFF})
+	bytBuffer.WriteString(text)
+	for _, strategy := range []Strategy{Compact, WCFCompatible} {
+		testEncodeWithOptions(t, EncoderOptions{Strategy: strategy},
+			bytBuffer.Bytes(),
+			"<a>"+text+"</a>")
+	}
+}
+
+// encodeUtf16 encodes s as UTF-16 with a byte order mark when bom is set
+func encodeUtf16(s string, order binary.ByteOrder, bom bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	out := make([]byte, 2*len(units))
+	for i, c := range units {
+		order.PutUint16(out[2*i:], c)
+	}
+	return out
+}
+
+func TestEncodeTypeHintOutsideRoot(t *testing.T) {
+	opts := EncoderOptions{TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+		if len(path) == 0 {
+			t.Fatal("TypeHint called without an open element")
+		}
+		if path[len(path)-1].Local == "a" {
+			return TextChars
+		}
+		return TextAuto
+	}}
+	testEncodeWithOptions(t, opts,
+		[]byte{0x98, 0x01, 0x0A, 0x40, 0x01, 0x61, 0x99, 0x01, 0x31, 0x98, 0x01, 0x0A},
+		"\n<a>1</a>\n")
+}
+
+func TestEncodeXmlDeclaration(t *testing.T) {
+	expected := []byte{0x40, 0x01, 0x61, 0x99, 0x06, 0xC3, 0xA9, 0xF0, 0x9F, 0x98, 0x80}
+	testEncode(t, expected, `<?xml version="1.0" encoding="utf-8"?><a>é😀</a>`)
+	testEncode(t, expected, "\xEF\xBB\xBF<a>é😀</a>")
+	for _, input := range [][]byte{
+		encodeUtf16(`<?xml version="1.0" encoding="utf-16"?><a>é😀</a>`, binary.LittleEndian, true),
+		encodeUtf16(`<?xml version="1.0" encoding="UTF-16"?><a>é😀</a>`, binary.BigEndian, true),
+		encodeUtf16(`<a>é😀</a>`, binary.LittleEndian, false),
+		encodeUtf16(`<a>é😀</a>`, binary.BigEndian, false),
+	} {
+		actual, err := NewEncoder().Encode(bytes.NewReader(input))
+		if err != nil {
+			t.Errorf("Unexpected error for %x: %v", input, err)
+		}
+		assertBinEqual(t, actual, expected)
+	}
+	actual, err := NewEncoder().Encode(bytes.NewReader([]byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>\xE9</a>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBinEqual(t, actual, []byte{0x40, 0x01, 0x61, 0x99, 0x02, 0xC3, 0xA9})
+
+	for _, input := range []string{
+		`<?xml version="1.0" encoding="shift_jis"?><a/>`,
+		`<?xml version="1.0" encoding="utf-16"?><a/>`,
+	} {
+		if _, err := NewEncoder().Encode(bytes.NewReader([]byte(input))); err == nil {
+			t.Errorf("Expected error for %s", input)
+		}
+	}
+}
+
+func TestEncodeProcInstAndDirective(t *testing.T) {
+	for _, input := range []string{
+		`<!DOCTYPE a><a/>`,
+		`<?xml-stylesheet href="a.xsl"?><a/>`,
+		`<a><?pi data?></a>`,
+	} {
+		if _, err := NewEncoder().Encode(bytes.NewReader([]byte(input))); err == nil {
+			t.Errorf("Expected error for %s", input)
+		}
+	}
+	drop := EncoderOptions{ProcInst: NodeDrop, Directive: NodeDrop}
+	testEncodeWithOptions(t, drop, []byte{0x40, 0x01, 0x61, 0x01},
+		`<?xml version="1.0"?><!DOCTYPE a [<!ENTITY e "x">]><?xml-stylesheet href="a.xsl"?><a><?pi data?></a>`)
+	_, err := NewEncoderWithOptions(nil, EncoderOptions{ProcInst: NodeDrop}).Encode(bytes.NewReader([]byte(`<!DOCTYPE a><a/>`)))
+	if err == nil {
+		t.Error("Expected error for a DOCTYPE when only processing instructions are dropped")
+	}
+}
+
+const prettyXml = "<?xml version=\"1.0\"?>\n<a>\n  <b>1 2</b>\n  <c xml:space=\"preserve\">\n    <d> </d>\n  </c>\n</a>\n"
+
+func TestEncodeWhitespace(t *testing.T) {
+	for _, test := range []struct {
+		mode     WhitespaceMode
+		expected string
+	}{
+		{WhitespacePreserve, "\n<a>\n  <b>1 2</b>\n  <c xml:space=\"preserve\">\n    <d> </d>\n  </c>\n</a>\n"},
+		{WhitespaceDrop, "<a><b>1 2</b><c xml:space=\"preserve\"><d></d></c></a>"},
+		{WhitespaceXmlSpace, "<a><b>1 2</b><c xml:space=\"preserve\">\n    <d> </d>\n  </c></a>"},
+	} {
+		bin, err := NewEncoderWithOptions(nil, EncoderOptions{Whitespace: test.mode}).Encode(bytes.NewReader([]byte(prettyXml)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(bin, []byte{0x99, 0x03, 0x31, 0x20, 0x32}) {
+			t.Errorf("Expected 1 2 as characters in %x", bin)
+		}
+		decoded, err := NewDecoder().Decode(bytes.NewReader(bin))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertStringEqual(t, decoded, test.expected)
+	}
+}
+
+func TestEncodeWhitespaceAsChars(t *testing.T) {
+	// whitespace stays characters even where the dictionary or a list would be smaller
+	dictionary := NewDictionary(map[uint32]string{0: " ", 2: "\n  "})
+	bin, err := NewEncoderWithOptions(dictionary, EncoderOptions{}).Encode(bytes.NewReader([]byte("<a>\n  <b> </b>\n  </a>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBinEqual(t, bin, []byte{0x40, 0x01, 0x61, 0x98, 0x03, 0x0A, 0x20, 0x20, 0x40, 0x01, 0x62, 0x99, 0x01, 0x20, 0x99, 0x03, 0x0A, 0x20, 0x20})
+}
+
+func TestEncodeHintedListCollapsesWhitespace(t *testing.T) {
+	opts := EncoderOptions{TypeHint: func(path []xml.Name, attr xml.Name) TextKind {
+		if attr.Local == "l" || path[len(path)-1].Local == "l" {
+			return TextList
+		}
+		return TextAuto
+	}}
+	testEncodeWithOptions(t, opts,
+		[]byte{0x40, 0x01, 0x61, 0x04, 0x01, 0x6C, 0xA4, 0x82, 0x88, 0x02, 0xA6,
+			0x40, 0x01, 0x6C, 0xA5, 0x82, 0x88, 0x02, 0xA6, 0x01},
+		"<a l=\" 1  2 \"><l>\n  1\n  2\n</l></a>")
+}
+
+func testEncodeWithOptions(t *testing.T, opts EncoderOptions, expected []byte, xmlString string) {
+	encoder := NewEncoderWithOptions(nil, opts)
+	actual, err := encoder.Encode(bytes.NewReader([]byte(xmlString)))
+	if err != nil {
+		t.Error("Unexpected error: " + err.Error() + " Got: " + string(actual))
+	}
+	assertBinEqual(t, actual, expected)
+}
+
 func testEncode(t *testing.T, expected []byte, xmlString string) {
 	encoder := NewEncoder()
 	actual, err := encoder.Encode(bytes.NewReader([]byte(xmlString)))
@@ -731,3 +1100,69 @@ func testEncode(t *testing.T, expected []byte, xmlString string) {
 	}
 	assertBinEqual(t, actual, expected)
 }
+
+// chunkRecorder records the size of each Write
+type chunkRecorder struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	return c.Buffer.Write(p)
+}
+
+func TestEncodeToWritesAsItGoes(t *testing.T) {
+	xmlBytes := largeXmlDocument()
+	expected, err := NewEncoder().Encode(bytes.NewReader(xmlBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &chunkRecorder{}
+	if err = NewEncoder().EncodeTo(out, bytes.NewReader(xmlBytes)); err != nil {
+		t.Fatal(err)
+	}
+	assertBinEqual(t, out.Bytes(), expected)
+	if len(out.writes) < len(expected)/(2*encodeFlushSize) {
+		t.Errorf("Expected the %d bytes in writes of about %d bytes, got %d writes", len(expected), encodeFlushSize, len(out.writes))
+	}
+}
+
+func BenchmarkEncodeSmallEnvelope(b *testing.B) {
+	xmlString := "<s:Envelope xmlns:a=\"http://www.w3.org/2005/08/addressing\" xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Header><a:Action s:mustUnderstand=\"1\">action</a:Action></s:Header><s:Body><Inventory>0</Inventory></s:Body></s:Envelope>"
+	benchmarkEncode(b, []byte(xmlString))
+}
+
+func BenchmarkEncodeLargeDocument(b *testing.B) {
+	benchmarkEncode(b, largeXmlDocument())
+}
+
+func benchmarkEncode(b *testing.B, xmlBytes []byte) {
+	encoder := NewEncoder()
+	b.SetBytes(int64(len(xmlBytes)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := encoder.Encode(bytes.NewReader(xmlBytes)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// largeXmlDocument builds a multi-megabyte document mixing numeric, plain
+// text and base64 content
+func largeXmlDocument() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("<root>")
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(buf, "<item id=\"%d\">hello</item><price>%d.25</price>", i, i%1000)
+	}
+	blob := make([]byte, 1<<20)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+	buf.WriteString("<blob>")
+	buf.WriteString(b64.EncodeToString(blob))
+	buf.WriteString("</blob></root>")
+	return buf.Bytes()
+}
@@ -3,6 +3,7 @@ package nbfx
 import (
 	"encoding/xml"
 	"errors"
+	"fmt"
 )
 
 func init() {
@@ -30,6 +31,12 @@ func (r *endElementRecord) isEndElement() bool {
 
 func (r *endElementRecord) decodeElement(d *decoder) (record, error) {
 	item := d.elementStack.pop()
+	if item == nil {
+		return nil, errors.New("EndElement record without an open element")
+	}
+	if d.opts.Strict {
+		d.ns.pop()
+	}
 	element := item.(xml.StartElement)
 	endElementToken := xml.EndElement{Name: xml.Name{Local: element.Name.Local, Space: element.Name.Space}}
 	err := d.xml.EncodeToken(endElementToken)
@@ -37,7 +44,7 @@ func (r *endElementRecord) decodeElement(d *decoder) (record, error) {
 }
 
 func (r *endElementRecord) encodeElement(e *encoder, element xml.StartElement) error {
-	_, err := e.bin.Write([]byte{r.id})
+	err := e.bin.WriteByte(r.id)
 	return err
 }
 
@@ -95,11 +102,14 @@ func (r *arrayRecord) decodeElement(d *decoder) (record, error) {
 	if err != nil {
 		return rec, err
 	}
-	valRec, err := getNextRecord(d)
+	valId, err := readByte(d.bin)
 	if err != nil {
-		return valRec, err
+		return nil, err
+	}
+	valDecoder, ok := records[valId].(textRecordDecoder)
+	if !ok {
+		return nil, fmt.Errorf("Invalid array value record %#x", valId)
 	}
-	valDecoder := valRec.(textRecordDecoder)
 	len, err := readMultiByteInt31(d.bin)
 	if err != nil {
 		return nil, err
@@ -108,8 +118,13 @@ func (r *arrayRecord) decodeElement(d *decoder) (record, error) {
 	var startElement xml.StartElement
 	for i = 0; i < len; i++ {
 		if i == 0 {
-			startElement = d.elementStack.top.value.(xml.StartElement)
+			startElement = d.elementStack.peek().(xml.StartElement)
 		} else {
+			if d.opts.Strict {
+				if err = d.checkStartElement(startElement); err != nil {
+					return nil, err
+				}
+			}
 			err = d.xml.EncodeToken(startElement)
 			if err != nil {
 				return nil, err
@@ -120,9 +135,6 @@ func (r *arrayRecord) decodeElement(d *decoder) (record, error) {
 		if err != nil {
 			return nil, err
 		}
-		if i < len {
-			d.elementStack.push(startElement)
-		}
 	}
 	return nil, nil
 }
@@ -0,0 +1,152 @@
+package nbfx
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// PushDecoder decodes an NBFX document written to it in fragments of any size, for event-driven
+// network code that can't block reading a whole message. Write decodes every record the fragments
+// written so far complete, passing its tokens to the callback, and keeps the bytes of a partial
+// record, such as half of a MultiByteInt31 or of a Chars32Text payload, until the next Write.
+//
+// The tokens are those the Decoder writes as XML, with names like "s:Envelope" in Local.
+type PushDecoder struct {
+	d  decoder
+	fn func(xml.Token) error
+	// buf holds the bytes written that are not yet decoded
+	buf     []byte
+	pending pendingTokens
+	// saved is the element stack before the record being decoded
+	saved []interface{}
+	err   error
+}
+
+// pendingTokens is the tokenWriter of a PushDecoder, holding the tokens of a record until the
+// record is complete
+type pendingTokens struct {
+	tokens []xml.Token
+}
+
+func (p *pendingTokens) EncodeToken(t xml.Token) error {
+	if data, ok := t.(xml.CharData); ok {
+		// the decoder reuses its CharData buffer for every text record
+		t = data.Copy()
+	}
+	p.tokens = append(p.tokens, t)
+	return nil
+}
+
+func (p *pendingTokens) Flush() error {
+	return nil
+}
+
+// NewPushDecoder creates a PushDecoder calling fn with each token it decodes. The dictionary may be nil.
+func NewPushDecoder(dictionary *Dictionary, fn func(xml.Token) error) *PushDecoder {
+	if dictionary == nil {
+		dictionary = NewDictionary(nil)
+	}
+	p := &PushDecoder{fn: fn}
+	p.d = decoder{dict: dictionary.strings, xml: &p.pending}
+	return p
+}
+
+// Write decodes the records that fragment completes. It fails with the first error of a record or
+// of the callback, after which the PushDecoder decodes nothing more.
+func (p *PushDecoder) Write(fragment []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	p.buf = append(p.buf, fragment...)
+	p.d.bin = newBinReader(p.buf)
+	for p.d.bin.len() > 0 {
+		start := p.d.bin.off
+		complete, err := p.decodeRecord()
+		if err == nil && !complete {
+			p.d.bin.off = start
+			p.rollback()
+			break
+		}
+		for _, t := range p.pending.tokens {
+			if err != nil {
+				break
+			}
+			err = p.fn(t)
+		}
+		p.pending.tokens = p.pending.tokens[:0]
+		if err != nil {
+			p.err = err
+			return 0, err
+		}
+	}
+	if off := p.d.bin.off; off > 0 {
+		// a fragment that completes no record leaves the buffer as it is, so a large record
+		// written in many fragments isn't copied once for each of them
+		p.buf = p.buf[:copy(p.buf, p.buf[off:])]
+	}
+	return len(fragment), nil
+}
+
+// decodeRecord decodes the next record, reporting whether the bytes written hold all of it
+func (p *PushDecoder) decodeRecord() (bool, error) {
+	p.saved = append(p.saved[:0], p.d.elementStack.items[:p.d.elementStack.size]...)
+	rec, err := getNextRecord(&p.d)
+	if err != nil {
+		return false, err
+	}
+	elementReader, isElement := rec.(elementRecordDecoder)
+	textReader, isText := rec.(textRecordDecoder)
+	switch {
+	case (rec.isStartElement() || rec.isEndElement()) && isElement:
+		peek, err := elementReader.decodeElement(&p.d)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if peek != nil {
+			// the record after the attributes is decoded on its own
+			p.d.bin.off--
+		} else if rec.isStartElement() && rec != records[array] {
+			// the element records end their attributes at the next record, which is not written yet
+			return false, nil
+		}
+	case rec.isText() && isText:
+		_, err = textReader.decodeText(&p.d, textReader)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("NotSupported: Decode record %s", rec.getName())
+	}
+	return true, nil
+}
+
+// rollback undoes decoding a partial record
+func (p *PushDecoder) rollback() {
+	p.pending.tokens = p.pending.tokens[:0]
+	p.d.elementStack.reset()
+	for _, item := range p.saved {
+		p.d.elementStack.push(item)
+	}
+}
+
+// Close reports whether the bytes written were a complete document. It fails with
+// io.ErrUnexpectedEOF when they end inside a record or an element.
+func (p *PushDecoder) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if len(p.buf) > 0 || p.d.elementStack.size > 0 {
+		p.err = io.ErrUnexpectedEOF
+		return p.err
+	}
+	p.err = errors.New("PushDecoder is closed")
+	return nil
+}
@@ -0,0 +1,151 @@
+package nbfx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pushDecode writes each of fragments to a PushDecoder and returns the XML of its tokens
+func pushDecode(dictionary *Dictionary, fragments ...[]byte) (string, error) {
+	buf := &bytes.Buffer{}
+	enc := xml.NewEncoder(buf)
+	p := NewPushDecoder(dictionary, enc.EncodeToken)
+	for _, fragment := range fragments {
+		if _, err := p.Write(fragment); err != nil {
+			return "", err
+		}
+	}
+	err := p.Close()
+	enc.Flush()
+	return buf.String(), err
+}
+
+// testPushDecode checks that bin decodes to expected when split at every byte boundary, and
+// when written one byte at a time
+func testPushDecode(t *testing.T, bin []byte, expected string) {
+	t.Helper()
+	for i := 0; i <= len(bin); i++ {
+		actual, err := pushDecode(nil, bin[:i], bin[i:])
+		if err != nil || actual != expected {
+			t.Errorf("Split at %d of %x decoded to %s, %v", i, bin, actual, err)
+			return
+		}
+	}
+	bytewise := make([][]byte, len(bin))
+	for i := range bin {
+		bytewise[i] = bin[i : i+1]
+	}
+	actual, err := pushDecode(nil, bytewise...)
+	if err != nil || actual != expected {
+		t.Errorf("%x written a byte at a time decoded to %s, %v", bin, actual, err)
+	}
+}
+
+func TestPushDecodePartialRecords(t *testing.T) {
+	text := strings.Repeat("chars!", 20000)
+	dictionary := NewDictionary(map[uint32]string{300: "Envelope"})
+	bin, err := NewEncoderWithDictionary(dictionary).Encode(bytes.NewReader([]byte("<Envelope><t>" + text + "</t></Envelope>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(bin, []byte{shortDictionaryElement, 0xAC, 0x02}) || !bytes.Contains(bin, []byte{chars32TextWithEndElement}) {
+		t.Fatalf("Expected a two byte dictionary key and Chars32Text in %x", bin[:16])
+	}
+	var tokens []xml.Token
+	p := NewPushDecoder(dictionary, func(token xml.Token) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	write := func(fragment []byte, expected int) {
+		t.Helper()
+		if _, err := p.Write(fragment); err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != expected {
+			t.Fatalf("Expected %d tokens, got %v", expected, tokens)
+		}
+	}
+	// mid-MultiByteInt31, then mid-Chars32Text payload
+	write(bin[:2], 0)
+	write(bin[2:6], 1)
+	write(bin[6:1000], 2)
+	write(bin[1000:len(bin)-2], 2)
+	write(bin[len(bin)-2:len(bin)-1], 4)
+	write(bin[len(bin)-1:], 5)
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := tokens[2].(xml.CharData); !ok || string(data) != text {
+		t.Errorf("Unexpected text token %T", tokens[2])
+	}
+}
+
+func TestPushDecodeMalformedRecords(t *testing.T) {
+	for name, bin := range map[string][]byte{
+		"EndElement as attribute value": {0x40, 0x01, 0x61, 0x04, 0x01, 0x62, 0x01},
+		"EndElement as array value":     {0x03, 0x40, 0x01, 0x61, 0x01, 0x01, 0x01, 0x00},
+	} {
+		for i := 0; i <= len(bin); i++ {
+			if _, err := pushDecode(nil, bin[:i], bin[i:]); err == nil || err == io.ErrUnexpectedEOF {
+				t.Errorf("Expected error for %s split at %d, got %v", name, i, err)
+			}
+		}
+	}
+}
+
+func TestPushDecodeClose(t *testing.T) {
+	bin := []byte{0x40, 0x01, 0x61, 0x40, 0x01, 0x62, 0x99, 0x01, 0x78, 0x01}
+	for _, fragment := range [][]byte{bin[:1], bin[:3], bin[:8], bin[:9]} {
+		if _, err := pushDecode(nil, fragment); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected io.ErrUnexpectedEOF closing after %x, got %v", fragment, err)
+		}
+	}
+	actual, err := pushDecode(nil, bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, actual, "<a><b>x</b></a>")
+
+	if _, err = pushDecode(nil, []byte{0x40, 0x01, 0x61, 0xFF}); err == nil || err == io.ErrUnexpectedEOF {
+		t.Errorf("Expected error for an unknown record, got %v", err)
+	}
+	stop := io.ErrShortWrite
+	p := NewPushDecoder(nil, func(xml.Token) error { return stop })
+	if _, err = p.Write(bin); err != stop {
+		t.Errorf("Expected the callback's error, got %v", err)
+	}
+	if _, err = p.Write(bin); err != stop {
+		t.Errorf("Expected the error to stick, got %v", err)
+	}
+}
+
+// BenchmarkPushDecodeChars32Text writes a large text record in packet sized fragments. The
+// throughput should not drop as the record grows, as each fragment costs time for its own bytes.
+func BenchmarkPushDecodeChars32Text(b *testing.B) {
+	for _, size := range []int{1 << 20, 8 << 20} {
+		bin := []byte{0x40, 0x01, 0x61, chars32TextWithEndElement, byte(size), byte(size >> 8), byte(size >> 16), byte(size >> 24)}
+		bin = append(bin, bytes.Repeat([]byte("x"), size)...)
+		b.Run(strconv.Itoa(size>>20)+"MB", func(b *testing.B) {
+			b.SetBytes(int64(len(bin)))
+			for i := 0; i < b.N; i++ {
+				p := NewPushDecoder(nil, func(xml.Token) error { return nil })
+				for off := 0; off < len(bin); off += 1460 {
+					end := off + 1460
+					if end > len(bin) {
+						end = len(bin)
+					}
+					if _, err := p.Write(bin[off:end]); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := p.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
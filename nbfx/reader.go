@@ -0,0 +1,894 @@
+package nbfx
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// NodeType is the kind of node a Reader is on
+type NodeType int
+
+const (
+	// NoNode is the node of a Reader before its first Read and after the end of the document
+	NoNode NodeType = iota
+	// ElementNode is a start element
+	ElementNode
+	// TextNode is a single text record, or one value of an array
+	TextNode
+	// EndElementNode is an end element, whether written as an EndElement record or by a
+	// WithEndElement text record
+	EndElementNode
+	// CommentNode is a comment
+	CommentNode
+)
+
+var nodeTypeNames = []string{"None", "Element", "Text", "EndElement", "Comment"}
+
+func (t NodeType) String() string {
+	if t >= 0 && int(t) < len(nodeTypeNames) {
+		return nodeTypeNames[t]
+	}
+	return "NodeType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Reader pulls an NBFX document one node at a time, like .NET's XmlDictionaryReader.
+// The ReadElementContentAs methods take the values of typed text records such as Int32Text,
+// BoolText and Bytes32Text as they are, without formatting them as text and parsing them back,
+// and parse the text as XmlConvert does when the sender wrote it as characters.
+//
+// A Reader reads its input as it goes, holding little more than the record it is on, so
+// ReadElementContentAsBase64To can stream large binary content in constant memory.
+type Reader struct {
+	src     io.Reader
+	d       decoder
+	started bool
+	err     error
+
+	node   readerNode
+	scope  nsScope
+	open   []openElement
+	values []readerValue
+	// endPending is set after a WithEndElement text record, whose end element is the next node
+	endPending bool
+	array      readerArray
+}
+
+// readerNode is the node a Reader is on
+type readerNode struct {
+	kind   NodeType
+	name   xml.Name
+	prefix string
+	attr   []xml.Attr
+	value  readerValue
+}
+
+// openElement is an element whose end the Reader has not reached
+type openElement struct {
+	name   xml.Name
+	prefix string
+}
+
+// readerArray is what is left of an Array record, whose values are read one element at a time
+type readerArray struct {
+	element   xml.StartElement
+	id        byte
+	remaining uint32
+	// next is the kind of the next node of the current element
+	next NodeType
+}
+
+// readerValue is the value of one text record in the type it was written in.
+// Records that hold text, like Chars8Text and DictionaryText, keep it in text.
+type readerValue struct {
+	// id is the record type without the WithEndElement bit
+	id    byte
+	int   int64
+	uint  uint64
+	float float64
+	dec   Decimal
+	dt    DateTime
+	guid  Guid
+	bytes []byte
+	text  string
+}
+
+// capturedToken is the tokenWriter of a Reader's decoder, keeping the start element the element
+// records decode so their names and attributes are read in one place
+type capturedToken struct {
+	token xml.Token
+}
+
+func (c *capturedToken) EncodeToken(t xml.Token) error {
+	c.token = t
+	return nil
+}
+
+func (c *capturedToken) Flush() error {
+	return nil
+}
+
+// NewReader creates a Reader of the NBFX document in r. The dictionary may be nil.
+func NewReader(r io.Reader, dictionary *Dictionary) *Reader {
+	if dictionary == nil {
+		dictionary = NewDictionary(nil)
+	}
+	return &Reader{src: r, d: decoder{dict: dictionary.strings, xml: &capturedToken{}}}
+}
+
+// NodeType returns the kind of node the Reader is on
+func (r *Reader) NodeType() NodeType {
+	return r.node.kind
+}
+
+// Name returns the namespace and local name of the element or end element the Reader is on
+func (r *Reader) Name() xml.Name {
+	return r.node.name
+}
+
+// Prefix returns the prefix of the element or end element the Reader is on
+func (r *Reader) Prefix() string {
+	return r.node.prefix
+}
+
+// Attr returns the attributes of the element the Reader is on, with their namespaces resolved.
+// Namespace declarations are included as encoding/xml returns them, with xmlns in Space.
+func (r *Reader) Attr() []xml.Attr {
+	return r.node.attr
+}
+
+// GetAttribute returns the value of an attribute of the element the Reader is on
+func (r *Reader) GetAttribute(local, ns string) (string, bool) {
+	for _, attr := range r.node.attr {
+		if attr.Name.Local == local && attr.Name.Space == ns {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// Depth returns the number of elements enclosing the node the Reader is on
+func (r *Reader) Depth() int {
+	if r.node.kind == ElementNode {
+		return len(r.open) - 1
+	}
+	return len(r.open)
+}
+
+// Value returns the text of the text node or comment the Reader is on
+func (r *Reader) Value() string {
+	switch r.node.kind {
+	case TextNode, CommentNode:
+		return r.node.value.String()
+	}
+	return ""
+}
+
+// Read moves to the next node, returning io.EOF after the last one
+func (r *Reader) Read() error {
+	if r.err != nil {
+		return r.err
+	}
+	err := r.read()
+	if err != nil {
+		r.err = err
+		r.node = readerNode{}
+	}
+	return err
+}
+
+// next reads the next node, treating the end of the document as success
+func (r *Reader) next() error {
+	err := r.Read()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (r *Reader) read() error {
+	if !r.started {
+		r.started = true
+		r.d.bin = newStreamBinReader(r.src)
+	}
+	if r.endPending {
+		r.endPending = false
+		return r.endElement()
+	}
+	if r.array.remaining > 0 {
+		return r.readArrayNode()
+	}
+
+	id, err := r.d.bin.readByte()
+	if err != nil {
+		return err
+	}
+	rec, err := getRecord(id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case id == endElement:
+		return r.endElement()
+	case id == comment:
+		text, err := readString(r.d.bin)
+		r.node = readerNode{kind: CommentNode, value: readerValue{id: comment, text: text}}
+		return err
+	case id == array:
+		return r.startArray()
+	case rec.isStartElement():
+		element, err := r.decodeElement(rec)
+		if err != nil {
+			return err
+		}
+		r.startElement(element)
+		return nil
+	case rec.isText():
+		value, err := readValue(&r.d, id)
+		if err != nil {
+			return err
+		}
+		r.node = readerNode{kind: TextNode, value: value}
+		r.endPending = id&1 == 1
+		return nil
+	}
+	return fmt.Errorf("Unexpected record %s", rec.getName())
+}
+
+// decodeElement decodes an element record and its attributes, leaving the record after them unread
+func (r *Reader) decodeElement(rec record) (xml.StartElement, error) {
+	peek, err := rec.(elementRecordDecoder).decodeElement(&r.d)
+	if err != nil {
+		return xml.StartElement{}, err
+	}
+	if peek != nil {
+		r.d.bin.off--
+	}
+	r.d.elementStack.pop()
+	return r.d.xml.(*capturedToken).token.(xml.StartElement), nil
+}
+
+// startElement moves to element, declaring its namespaces and resolving its names
+func (r *Reader) startElement(element xml.StartElement) {
+	name := splitRawName(element.Name.Local)
+	attrs := make([]xml.Attr, len(element.Attr))
+	for i, attr := range element.Attr {
+		attrs[i] = xml.Attr{Name: splitRawName(attr.Name.Local), Value: attr.Value}
+	}
+	r.scope.push(attrs)
+	for i, attr := range attrs {
+		if attr.Name.Space != "xmlns" && attr.Name != (xml.Name{Local: "xmlns"}) {
+			attrs[i].Name = r.scope.resolve(attr.Name, true)
+		}
+	}
+	r.node = readerNode{kind: ElementNode, name: r.scope.resolve(name, false), prefix: name.Space, attr: attrs}
+	r.open = append(r.open, openElement{r.node.name, r.node.prefix})
+}
+
+func (r *Reader) endElement() error {
+	if len(r.open) == 0 {
+		return errors.New("EndElement record without an open element")
+	}
+	open := r.open[len(r.open)-1]
+	r.open = r.open[:len(r.open)-1]
+	r.scope.pop()
+	r.node = readerNode{kind: EndElementNode, name: open.name, prefix: open.prefix}
+	return nil
+}
+
+// splitRawName splits the prefix:name the records decode to into Space and Local
+func splitRawName(raw string) xml.Name {
+	if i := strings.IndexByte(raw, ':'); i >= 0 {
+		return xml.Name{Space: raw[:i], Local: raw[i+1:]}
+	}
+	return xml.Name{Local: raw}
+}
+
+// startArray reads the element and value type of an Array record
+func (r *Reader) startArray() error {
+	id, err := r.d.bin.readByte()
+	if err != nil {
+		return err
+	}
+	rec, err := getRecord(id)
+	if err != nil {
+		return err
+	}
+	if !rec.isStartElement() || id == array || id == endElement {
+		return errors.New("Element expected!")
+	}
+	element, err := r.decodeElement(rec)
+	if err != nil {
+		return err
+	}
+	end, err := r.d.bin.readByte()
+	if err != nil {
+		return err
+	}
+	if end != endElement {
+		return fmt.Errorf("Expected EndElement after the element of an array, found %#x", end)
+	}
+	valueId, err := r.d.bin.readByte()
+	if err != nil {
+		return err
+	}
+	if rec, err = getRecord(valueId); err != nil || !rec.isText() {
+		return fmt.Errorf("Invalid array value record %#x", valueId)
+	}
+	length, err := readMultiByteInt31(r.d.bin)
+	if err != nil {
+		return err
+	}
+	r.array = readerArray{element: element, id: valueId, remaining: length, next: ElementNode}
+	if length == 0 {
+		return r.read()
+	}
+	return r.readArrayNode()
+}
+
+// readArrayNode moves to the next node of the element holding the current value of an array
+func (r *Reader) readArrayNode() error {
+	switch r.array.next {
+	case ElementNode:
+		r.startElement(r.array.element)
+		r.array.next = TextNode
+	case TextNode:
+		value, err := readValue(&r.d, r.array.id)
+		if err != nil {
+			return err
+		}
+		r.node = readerNode{kind: TextNode, value: value}
+		r.array.next = EndElementNode
+	default:
+		r.array.remaining--
+		r.array.next = ElementNode
+		return r.endElement()
+	}
+	return nil
+}
+
+// readValue reads the value of the text record id
+func readValue(d *decoder, id byte) (readerValue, error) {
+	v := readerValue{id: id &^ 1}
+	var err error
+	switch v.id {
+	case zeroText, falseText:
+	case oneText, trueText:
+		v.int = 1
+	case boolText:
+		var b byte
+		b, err = d.bin.readByte()
+		if err == nil && b > 1 {
+			err = errors.New("BoolText record byte must be 0 or 1")
+		}
+		v.int = int64(b)
+	case int8Text:
+		var b byte
+		b, err = d.bin.readByte()
+		v.int = int64(int8(b))
+	case int16Text:
+		var i uint16
+		i, err = d.bin.readUint16()
+		v.int = int64(int16(i))
+	case int32Text:
+		var i uint32
+		i, err = d.bin.readUint32()
+		v.int = int64(int32(i))
+	case int64Text, timeSpanText:
+		var i uint64
+		i, err = d.bin.readUint64()
+		v.int = int64(i)
+	case uInt64Text:
+		v.uint, err = d.bin.readUint64()
+	case floatText:
+		var bits uint32
+		bits, err = d.bin.readUint32()
+		v.float = float64(math.Float32frombits(bits))
+	case doubleText:
+		var bits uint64
+		bits, err = d.bin.readUint64()
+		v.float = math.Float64frombits(bits)
+	case decimalText:
+		v.dec, err = readDecimal(d)
+	case dateTimeText:
+		v.dt, err = readDateTime(d)
+	case uuidText, uniqueIdText:
+		v.guid, err = readGuid(d)
+	case bytes8Text, bytes16Text, bytes32Text:
+		var length uint32
+		length, err = readBytesLength(d.bin, v.id)
+		if err == nil {
+			v.bytes, err = d.bin.next(length)
+			// the buffer is refilled by the next read
+			v.bytes = append([]byte(nil), v.bytes...)
+		}
+	default:
+		rec, ok := records[id].(textRecordDecoder)
+		if !ok {
+			return v, fmt.Errorf("Invalid text record %#x", id)
+		}
+		v.text, err = rec.readText(d)
+	}
+	return v, err
+}
+
+// readBytesLength reads the length of the Bytes8/16/32Text record id
+func readBytesLength(bin *binReader, id byte) (uint32, error) {
+	switch id {
+	case bytes8Text:
+		b, err := bin.readByte()
+		return uint32(b), err
+	case bytes16Text:
+		i, err := bin.readUint16()
+		return uint32(i), err
+	}
+	return bin.readUint32()
+}
+
+// String returns the value as the Decoder writes it
+func (v readerValue) String() string {
+	switch v.id {
+	case zeroText, oneText, int8Text, int16Text, int32Text, int64Text:
+		return strconv.FormatInt(v.int, 10)
+	case falseText, trueText, boolText:
+		return strconv.FormatBool(v.int != 0)
+	case uInt64Text:
+		return strconv.FormatUint(v.uint, 10)
+	case floatText:
+		return FormatFloat(v.float, 32)
+	case doubleText:
+		return FormatFloat(v.float, 64)
+	case decimalText:
+		return v.dec.String()
+	case dateTimeText:
+		return v.dt.String()
+	case timeSpanText:
+		return TimeSpan(v.int).String()
+	case uuidText:
+		return v.guid.String()
+	case uniqueIdText:
+		return UniqueID(v.guid).String()
+	case bytes8Text, bytes16Text, bytes32Text:
+		return b64.EncodeToString(v.bytes)
+	}
+	return v.text
+}
+
+func (v readerValue) isInt() bool {
+	switch v.id {
+	case zeroText, oneText, int8Text, int16Text, int32Text, int64Text:
+		return true
+	}
+	return false
+}
+
+func (v readerValue) isBytes() bool {
+	return v.id == bytes8Text || v.id == bytes16Text || v.id == bytes32Text
+}
+
+// isWhitespace reports whether v is text of nothing but whitespace, which MoveToContent skips
+func (v readerValue) isWhitespace() bool {
+	switch v.id {
+	case chars8Text, chars16Text, chars32Text, unicodeChars8Text, unicodeChars16Text, unicodeChars32Text, emptyText:
+		return strings.Trim(v.text, xmlWhitespace) == ""
+	}
+	return false
+}
+
+// MoveToContent skips comments and whitespace, reading the first node if the Reader has not started,
+// and returns the kind of node it stops on
+func (r *Reader) MoveToContent() (NodeType, error) {
+	if !r.started {
+		if err := r.next(); err != nil {
+			return NoNode, err
+		}
+	}
+	for r.node.kind == CommentNode || r.node.kind == TextNode && r.node.value.isWhitespace() {
+		if err := r.next(); err != nil {
+			return NoNode, err
+		}
+	}
+	return r.node.kind, nil
+}
+
+// IsStartElement moves to content and reports whether it is a start element named local in
+// namespace ns. An empty local name matches any element.
+func (r *Reader) IsStartElement(local, ns string) (bool, error) {
+	kind, err := r.MoveToContent()
+	if err != nil || kind != ElementNode {
+		return false, err
+	}
+	return local == "" || r.node.name.Local == local && r.node.name.Space == ns, nil
+}
+
+func (r *Reader) expectStartElement(local, ns string) error {
+	ok, err := r.IsStartElement(local, ns)
+	if err != nil || ok {
+		return err
+	}
+	found := r.node.kind.String()
+	if r.node.kind == ElementNode || r.node.kind == EndElementNode {
+		found += " " + r.node.name.Local
+	}
+	if local == "" {
+		return fmt.Errorf("Expected a start element, found %s", found)
+	}
+	return fmt.Errorf("Expected start element %s in namespace %q, found %s", local, ns, found)
+}
+
+// ReadStartElement checks that the content is a start element named local in namespace ns
+// and moves past it. An empty local name matches any element.
+func (r *Reader) ReadStartElement(local, ns string) error {
+	err := r.expectStartElement(local, ns)
+	if err != nil {
+		return err
+	}
+	return r.next()
+}
+
+// ReadEndElement checks that the content is an end element and moves past it
+func (r *Reader) ReadEndElement() error {
+	kind, err := r.MoveToContent()
+	if err != nil {
+		return err
+	}
+	if kind != EndElementNode {
+		return fmt.Errorf("Expected an end element, found %s", kind)
+	}
+	return r.next()
+}
+
+// Skip moves past the node the Reader is on, and past all of its content when it is a start element
+func (r *Reader) Skip() error {
+	if r.node.kind != ElementNode {
+		return r.next()
+	}
+	depth := len(r.open)
+	for {
+		err := r.Read()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if r.node.kind == EndElementNode && len(r.open) < depth {
+			return r.next()
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readContent reads the text of the element named local in namespace ns up to its end element,
+// which it moves past, and returns the values of its text records
+func (r *Reader) readContent(local, ns string) ([]readerValue, error) {
+	err := r.expectStartElement(local, ns)
+	if err != nil {
+		return nil, err
+	}
+	name := r.node.name
+	r.values = r.values[:0]
+	for {
+		err = r.Read()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		switch r.node.kind {
+		case TextNode:
+			r.values = append(r.values, r.node.value)
+		case ElementNode:
+			return nil, fmt.Errorf("Element %s has child elements", name.Local)
+		case EndElementNode:
+			return r.values, r.next()
+		}
+	}
+}
+
+// contentText returns values as the text they decode to
+func contentText(values []readerValue) string {
+	if len(values) == 1 {
+		return values[0].String()
+	}
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+// ReadElementContentAsString reads the text of the element named local in namespace ns and moves past it
+func (r *Reader) ReadElementContentAsString(local, ns string) (string, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return "", err
+	}
+	return contentText(values), nil
+}
+
+// ReadElementContentAsInt32 reads the content of the element named local in namespace ns as an int32
+// and moves past it
+func (r *Reader) ReadElementContentAsInt32(local, ns string) (int32, error) {
+	i, err := r.readContentAsInt(local, ns, 32)
+	return int32(i), err
+}
+
+// ReadElementContentAsInt64 reads the content of the element named local in namespace ns as an int64
+// and moves past it
+func (r *Reader) ReadElementContentAsInt64(local, ns string) (int64, error) {
+	return r.readContentAsInt(local, ns, 64)
+}
+
+func (r *Reader) readContentAsInt(local, ns string, bitSize int) (int64, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) == 1 && values[0].isInt() {
+		i := values[0].int
+		if bitSize == 64 || i >= math.MinInt32 && i <= math.MaxInt32 {
+			return i, nil
+		}
+	}
+	text := contentText(values)
+	i, err := strconv.ParseInt(strings.Trim(text, xmlWhitespace), 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid Int%d %q", bitSize, text)
+	}
+	return i, nil
+}
+
+// ReadElementContentAsDouble reads the content of the element named local in namespace ns as a float64
+// and moves past it
+func (r *Reader) ReadElementContentAsDouble(local, ns string) (float64, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) == 1 {
+		switch v := values[0]; {
+		case v.id == floatText || v.id == doubleText:
+			return v.float, nil
+		case v.isInt():
+			return float64(v.int), nil
+		}
+	}
+	return ParseFloat(contentText(values), 64)
+}
+
+// ReadElementContentAsDecimal reads the content of the element named local in namespace ns as a Decimal
+// and moves past it
+func (r *Reader) ReadElementContentAsDecimal(local, ns string) (Decimal, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return Decimal{}, err
+	}
+	if len(values) == 1 {
+		switch v := values[0]; {
+		case v.id == decimalText:
+			return v.dec, nil
+		case v.isInt():
+			return NewDecimal(big.NewInt(v.int), 0)
+		}
+	}
+	return ParseDecimal(contentText(values))
+}
+
+// ReadElementContentAsDateTime reads the content of the element named local in namespace ns as a DateTime
+// and moves past it
+func (r *Reader) ReadElementContentAsDateTime(local, ns string) (DateTime, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return DateTime{}, err
+	}
+	if len(values) == 1 && values[0].id == dateTimeText {
+		return values[0].dt, nil
+	}
+	return ParseDateTime(contentText(values))
+}
+
+// ReadElementContentAsTimeSpan reads the content of the element named local in namespace ns as a TimeSpan
+// and moves past it
+func (r *Reader) ReadElementContentAsTimeSpan(local, ns string) (TimeSpan, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) == 1 && values[0].id == timeSpanText {
+		return TimeSpan(values[0].int), nil
+	}
+	return ParseTimeSpan(contentText(values))
+}
+
+// ReadElementContentAsGuid reads the content of the element named local in namespace ns as a Guid
+// and moves past it
+func (r *Reader) ReadElementContentAsGuid(local, ns string) (Guid, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return Guid{}, err
+	}
+	if len(values) == 1 && (values[0].id == uuidText || values[0].id == uniqueIdText) {
+		return values[0].guid, nil
+	}
+	return ParseGuid(contentText(values))
+}
+
+// ReadElementContentAsUniqueID reads the content of the element named local in namespace ns as a UniqueID
+// and moves past it
+func (r *Reader) ReadElementContentAsUniqueID(local, ns string) (UniqueID, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return UniqueID{}, err
+	}
+	if len(values) == 1 && values[0].id == uniqueIdText {
+		return UniqueID(values[0].guid), nil
+	}
+	return ParseUniqueID(contentText(values))
+}
+
+// ReadElementContentAsBase64 reads the content of the element named local in namespace ns as bytes
+// and moves past it. Content split over several Bytes text records is joined.
+func (r *Reader) ReadElementContentAsBase64(local, ns string) ([]byte, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return nil, err
+	}
+	binary := true
+	length := 0
+	for _, v := range values {
+		binary = binary && v.isBytes()
+		length += len(v.bytes)
+	}
+	if binary {
+		b := make([]byte, 0, length)
+		for _, v := range values {
+			b = append(b, v.bytes...)
+		}
+		return b, nil
+	}
+	text := strings.Map(func(c rune) rune {
+		if strings.ContainsRune(xmlWhitespace, c) {
+			return -1
+		}
+		return c
+	}, contentText(values))
+	b, err := b64.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base64 %q", text)
+	}
+	return b, nil
+}
+
+// ReadElementContentAsBool reads the content of the element named local in namespace ns as a bool
+// and moves past it
+func (r *Reader) ReadElementContentAsBool(local, ns string) (bool, error) {
+	values, err := r.readContent(local, ns)
+	if err != nil {
+		return false, err
+	}
+	if len(values) == 1 {
+		switch v := values[0]; v.id {
+		case falseText, trueText, boolText, zeroText, oneText:
+			return v.int != 0, nil
+		}
+	}
+	text := contentText(values)
+	switch strings.Trim(text, xmlWhitespace) {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("Invalid Boolean %q", text)
+}
+
+// base64ChunkSize is the most bytes of a Bytes text record that are held at once when streaming
+const base64ChunkSize = math.MaxUint16
+
+// ReadElementContentAsBase64To copies the content of the element named local in namespace ns to w
+// as bytes, and moves past it. Bytes text records are copied in chunks as they are read, so content
+// of any size is copied in constant memory. Content written as base64 characters is decoded.
+func (r *Reader) ReadElementContentAsBase64To(local, ns string, w io.Writer) (int64, error) {
+	err := r.expectStartElement(local, ns)
+	if err != nil {
+		return 0, err
+	}
+	name := r.node.name
+	var n int64
+	var text []byte
+	for {
+		if !r.endPending && r.array.remaining == 0 {
+			copied, ok, err := r.copyBytes(w)
+			n += copied
+			if err != nil {
+				return n, unexpectedEOF(err)
+			}
+			if ok {
+				continue
+			}
+		}
+		err = r.Read()
+		if err != nil {
+			return n, unexpectedEOF(err)
+		}
+		switch r.node.kind {
+		case TextNode:
+			for _, c := range []byte(r.node.value.String()) {
+				if !strings.ContainsRune(xmlWhitespace, rune(c)) {
+					text = append(text, c)
+				}
+			}
+			decoded, err := r.decodeBase64To(w, text[:len(text)/4*4])
+			n += decoded
+			if err != nil {
+				return n, err
+			}
+			text = append(text[:0], text[len(text)/4*4:]...)
+		case ElementNode:
+			return n, fmt.Errorf("Element %s has child elements", name.Local)
+		case EndElementNode:
+			if len(text) > 0 {
+				return n, fmt.Errorf("Invalid base64 %q", text)
+			}
+			return n, r.next()
+		}
+	}
+}
+
+// copyBytes copies the next record to w when it is a Bytes text record, and reports whether it was
+func (r *Reader) copyBytes(w io.Writer) (int64, bool, error) {
+	id, err := r.d.bin.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch id &^ 1 {
+	case bytes8Text, bytes16Text, bytes32Text:
+	default:
+		r.d.bin.off--
+		return 0, false, nil
+	}
+	length, err := readBytesLength(r.d.bin, id&^1)
+	if err != nil {
+		return 0, true, err
+	}
+	var n int64
+	for length > 0 {
+		chunk := length
+		if chunk > base64ChunkSize {
+			chunk = base64ChunkSize
+		}
+		b, err := r.d.bin.next(chunk)
+		if err != nil {
+			return n, true, err
+		}
+		written, err := w.Write(b)
+		n += int64(written)
+		if err != nil {
+			return n, true, err
+		}
+		length -= chunk
+	}
+	r.node = readerNode{kind: TextNode, value: readerValue{id: id &^ 1}}
+	r.endPending = id&1 == 1
+	return n, true, nil
+}
+
+func (r *Reader) decodeBase64To(w io.Writer, text []byte) (int64, error) {
+	if len(text) == 0 {
+		return 0, nil
+	}
+	b := make([]byte, b64.DecodedLen(len(text)))
+	decoded, err := b64.Decode(b, text)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid base64 %q", text)
+	}
+	written, err := w.Write(b[:decoded])
+	return int64(written), err
+}
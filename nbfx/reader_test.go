@@ -0,0 +1,226 @@
+package nbfx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const readerXml = `<m xmlns="urn:m" xmlns:a="urn:a" a:id="7"><!--c--><i>-5</i><l>1099511627776</l><d>1.5</d>` +
+	`<dec>1.50</dec><dt>2006-05-17T00:00:00Z</dt><ts>PT1H</ts><g>33221100-5544-7766-8899-aabbccddeeff</g>` +
+	`<b>AQID</b><t>true</t><s> text </s><e></e></m>`
+
+// readerHint encodes the readerXml values that are not encoded as typed records automatically
+func readerHint(path []xml.Name, attr xml.Name) TextKind {
+	switch path[len(path)-1].Local {
+	case "dec":
+		return TextDecimal
+	case "dt":
+		return TextDateTime
+	case "ts":
+		return TextTimeSpan
+	case "b":
+		return TextBytes
+	}
+	return TextAuto
+}
+
+func encodeReaderXml(t *testing.T, opts EncoderOptions) []byte {
+	bin, err := NewEncoderWithOptions(nil, opts).Encode(bytes.NewReader([]byte(readerXml)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestReaderTypedContent(t *testing.T) {
+	bin := encodeReaderXml(t, EncoderOptions{TypeHint: readerHint})
+	for _, id := range []byte{int8TextWithEndElement, int64TextWithEndElement, floatTextWithEndElement, decimalTextWithEndElement,
+		dateTimeTextWithEndElement, timeSpanTextWithEndElement, uuidTextWithEndElement, bytes8TextWithEndElement, trueTextWithEndElement} {
+		if !bytes.Contains(bin, []byte{id}) {
+			t.Errorf("Expected record %#X in %x", id, bin)
+		}
+	}
+	testReadReaderXml(t, bin)
+}
+
+func TestReaderParsesChars(t *testing.T) {
+	bin := encodeReaderXml(t, hintAll(TextChars))
+	testReadReaderXml(t, bin)
+}
+
+func testReadReaderXml(t *testing.T, bin []byte) {
+	r := NewReader(bytes.NewReader(bin), nil)
+	ok, err := r.IsStartElement("m", "urn:m")
+	if err != nil || !ok {
+		t.Fatalf("Expected start element m, got %v, %v", r.Name(), err)
+	}
+	if id, ok := r.GetAttribute("id", "urn:a"); !ok || id != "7" {
+		t.Errorf("Expected attribute a:id, got %v", r.Attr())
+	}
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(r.ReadStartElement("m", "urn:m"))
+
+	i, err := r.ReadElementContentAsInt32("i", "urn:m")
+	check(err)
+	assertEqual(t, i, int32(-5))
+	l, err := r.ReadElementContentAsInt64("l", "urn:m")
+	check(err)
+	assertEqual(t, l, int64(1)<<40)
+	d, err := r.ReadElementContentAsDouble("d", "urn:m")
+	check(err)
+	assertEqual(t, d, 1.5)
+	dec, err := r.ReadElementContentAsDecimal("dec", "urn:m")
+	check(err)
+	assertEqual(t, dec, Decimal{Lo64: 150, Scale: 2})
+	dt, err := r.ReadElementContentAsDateTime("dt", "urn:m")
+	check(err)
+	assertEqual(t, dt, NewDateTime(time.Date(2006, 5, 17, 0, 0, 0, 0, time.UTC), DateTimeUTC))
+	ts, err := r.ReadElementContentAsTimeSpan("ts", "urn:m")
+	check(err)
+	assertEqual(t, ts, NewTimeSpan(time.Hour))
+	g, err := r.ReadElementContentAsGuid("g", "urn:m")
+	check(err)
+	assertStringEqual(t, g.String(), "33221100-5544-7766-8899-aabbccddeeff")
+	b, err := r.ReadElementContentAsBase64("b", "urn:m")
+	check(err)
+	assertBinEqual(t, b, []byte{1, 2, 3})
+	bl, err := r.ReadElementContentAsBool("t", "urn:m")
+	check(err)
+	assertEqual(t, bl, true)
+	s, err := r.ReadElementContentAsString("s", "urn:m")
+	check(err)
+	assertStringEqual(t, s, " text ")
+	s, err = r.ReadElementContentAsString("e", "urn:m")
+	check(err)
+	assertStringEqual(t, s, "")
+
+	check(r.ReadEndElement())
+	assertEqual(t, r.NodeType(), NoNode)
+	if r.Read() != io.EOF {
+		t.Error("Expected io.EOF after the document")
+	}
+}
+
+func TestReaderArray(t *testing.T) {
+	// the Array record example of [MC-NBFX], three Int16Text values of <arr>
+	r := NewReader(bytes.NewReader([]byte{0x03, 0x40, 0x03, 0x61, 0x72, 0x72, 0x01, 0x8B, 0x03, 0x33, 0x33, 0x88, 0x88, 0xDD, 0xDD}), nil)
+	var values []int32
+	for {
+		ok, err := r.IsStartElement("arr", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		i, err := r.ReadElementContentAsInt32("arr", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, i)
+	}
+	if len(values) != 3 || values[0] != 0x3333 || values[1] != -0x7778 || values[2] != -0x2223 {
+		t.Errorf("Unexpected array values %v", values)
+	}
+}
+
+func TestReaderNodes(t *testing.T) {
+	bin, err := NewEncoder().Encode(bytes.NewReader([]byte(`<a:r xmlns:a="urn:a"><skip><x>1</x><y/></skip><a:k>2</a:k></a:r>`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(bytes.NewReader(bin), nil)
+	err = r.Read()
+	if err != nil || r.NodeType() != ElementNode || r.Prefix() != "a" || r.Name() != (xml.Name{Space: "urn:a", Local: "r"}) || r.Depth() != 0 {
+		t.Fatalf("Unexpected node %v %v:%v, %v", r.NodeType(), r.Prefix(), r.Name(), err)
+	}
+	err = r.Read()
+	if err != nil || r.Name().Local != "skip" || r.Depth() != 1 {
+		t.Fatalf("Unexpected node %v, %v", r.Name(), err)
+	}
+	err = r.Skip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := r.ReadElementContentAsInt32("k", "urn:a")
+	if err != nil || k != 2 {
+		t.Errorf("Expected 2, got %d, %v", k, err)
+	}
+	if r.NodeType() != EndElementNode || r.Prefix() != "a" || r.Name().Local != "r" {
+		t.Errorf("Expected the end of a:r, got %v %v", r.NodeType(), r.Name())
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	bin, err := NewEncoder().Encode(bytes.NewReader([]byte(`<r><big>4294967296</big><x>abc</x><p><c/></p></r>`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(bytes.NewReader(bin), nil)
+	if err = r.ReadStartElement("other", ""); err == nil {
+		t.Error("Expected error for the wrong element name")
+	}
+	if err = r.ReadStartElement("r", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.ReadElementContentAsInt32("big", ""); err == nil {
+		t.Error("Expected error for an Int64Text out of the range of int32")
+	}
+	if _, err = r.ReadElementContentAsBool("x", ""); err == nil {
+		t.Error("Expected error reading abc as a bool")
+	}
+	if _, err = r.ReadElementContentAsString("p", ""); err == nil {
+		t.Error("Expected error for an element with child elements")
+	}
+
+	r = NewReader(bytes.NewReader([]byte{0x40, 0x01, 0x61, 0x01, 0x01}), nil)
+	for err == nil {
+		err = r.Read()
+	}
+	if err == io.EOF {
+		t.Error("Expected error for an EndElement without an open element")
+	}
+
+	r = NewReader(bytes.NewReader([]byte{0x40, 0x01, 0x61, 0x04, 0x01, 0x62, 0x01}), nil)
+	if err = r.Read(); err == nil || err == io.EOF {
+		t.Errorf("Expected error for an EndElement as attribute value, got %v", err)
+	}
+}
+
+func TestReaderHugeLengthOverShortInput(t *testing.T) {
+	for _, id := range []byte{chars32Text, bytes32Text} {
+		bin := []byte{0x40, 0x01, 0x61, id, 0xFF, 0xFF, 0xFF, 0x7F, 0x61, 0x62, 0x63}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := NewReader(bytes.NewReader(bin), nil).ReadElementContentAsString("a", "")
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("Expected error for record %#x declaring more bytes than the input has", id)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("Expected the declared length not to be allocated, allocated %d bytes", allocated)
+		}
+	}
+}
+
+func TestReaderBase64ToFromChars(t *testing.T) {
+	bin, err := NewEncoderWithOptions(nil, hintAll(TextChars)).Encode(bytes.NewReader([]byte("<a>AQID BAU=</a>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := &bytes.Buffer{}
+	_, err = NewReader(bytes.NewReader(bin), nil).ReadElementContentAsBase64To("a", "", copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBinEqual(t, copied.Bytes(), []byte{1, 2, 3, 4, 5})
+}
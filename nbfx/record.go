@@ -73,7 +73,7 @@ var records = make(map[byte]record)
 func addAzRecords(idA byte, baseName string, recFunc func(byte, string) record) {
 	for i := 0; i < 26; i++ {
 		id := idA + byte(i)
-		rec := recFunc(id, baseName+strings.ToUpper(string('a'+i)))
+		rec := recFunc(id, baseName+strings.ToUpper(string(rune('a'+i))))
 		records[id] = rec
 	}
 }
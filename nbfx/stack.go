@@ -1,26 +1,34 @@
 package nbfx
 
-// https://gist.github.com/bemasher/1777766
+// stack is a LIFO backed by a slice, so pushing only allocates when it grows
 type stack struct {
-	top  *stackElement
-	size int
-}
-
-type stackElement struct {
-	value interface{} // All types satisfy the empty interface, so we can store anything here.
-	next  *stackElement
+	items []interface{}
+	size  int
 }
 
 func (s *stack) push(value interface{}) {
-	s.top = &stackElement{value, s.top}
+	s.items = append(s.items[:s.size], value)
 	s.size++
 }
 
 func (s *stack) pop() (value interface{}) {
 	if s.size > 0 {
-		value, s.top = s.top.value, s.top.next
 		s.size--
+		value, s.items[s.size] = s.items[s.size], nil
 		return
 	}
 	return nil
 }
+
+func (s *stack) peek() interface{} {
+	if s.size > 0 {
+		return s.items[s.size-1]
+	}
+	return nil
+}
+
+func (s *stack) reset() {
+	for s.size > 0 {
+		s.pop()
+	}
+}
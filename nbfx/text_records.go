@@ -1,10 +1,10 @@
 package nbfx
 
 import (
-	"encoding/binary"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -97,16 +97,13 @@ func (r *textRecordBase) decodeText(d *decoder, trd textRecordDecoder) (string,
 	if err != nil {
 		return "", err
 	}
-	charData := xml.CharData([]byte(text))
-	d.xml.EncodeToken(charData)
+	// the xml encoder escapes CharData straight to its output, so one scratch buffer serves every text record
+	d.charData = append(d.charData[:0], text...)
+	d.xml.EncodeToken(xml.CharData(d.charData))
 	if r.withEndElement {
-		rec, err := getRecord(endElement)
-		endElementReader := rec.(elementRecordDecoder)
-		if err == nil {
-			endElementReader.decodeElement(d)
-		}
+		_, err = records[endElement].(elementRecordDecoder).decodeElement(d)
 	}
-	return text, nil
+	return text, err
 }
 
 func (r *textRecordBase) encodeText(e *encoder, tre textRecordEncoder, text string) error {
@@ -184,7 +181,7 @@ func (r *int8TextRecord) writeText(e *encoder, text string) error {
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.bin, binary.LittleEndian, int8(i))
+	return e.bin.WriteByte(byte(int8(i)))
 }
 
 type int16TextRecord struct {
@@ -200,7 +197,7 @@ func (r *int16TextRecord) writeText(e *encoder, text string) error {
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.bin, binary.LittleEndian, int16(i))
+	return writeUint16(e, uint16(int16(i)))
 }
 
 type int32TextRecord struct {
@@ -216,7 +213,7 @@ func (r *int32TextRecord) writeText(e *encoder, text string) error {
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.bin, binary.LittleEndian, int32(i))
+	return writeUint32(e, uint32(int32(i)))
 }
 
 type int64TextRecord struct {
@@ -232,7 +229,7 @@ func (r *int64TextRecord) writeText(e *encoder, text string) error {
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.bin, binary.LittleEndian, int64(i))
+	return writeUint64(e, uint64(i))
 }
 
 type floatTextRecord struct {
@@ -244,11 +241,11 @@ func (r *floatTextRecord) readText(d *decoder) (string, error) {
 }
 
 func (r *floatTextRecord) writeText(e *encoder, text string) error {
-	f, err := strconv.ParseFloat(text, 32)
+	f, err := ParseFloat(text, 32)
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.bin, binary.LittleEndian, float32(f))
+	return writeUint32(e, math.Float32bits(float32(f)))
 }
 
 type doubleTextRecord struct {
@@ -260,11 +257,11 @@ func (r *doubleTextRecord) readText(d *decoder) (string, error) {
 }
 
 func (r *doubleTextRecord) writeText(e *encoder, text string) error {
-	f, err := strconv.ParseFloat(text, 64)
+	f, err := ParseFloat(text, 64)
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.bin, binary.LittleEndian, f)
+	return writeUint64(e, math.Float64bits(f))
 }
 
 type decimalTextRecord struct {
@@ -275,6 +272,14 @@ func (r *decimalTextRecord) readText(d *decoder) (string, error) {
 	return readDecimalText(d)
 }
 
+func (r *decimalTextRecord) writeText(e *encoder, text string) error {
+	d, err := ParseDecimal(text)
+	if err != nil {
+		return err
+	}
+	return writeDecimal(e, d)
+}
+
 type dateTimeTextRecord struct {
 	textRecordBase
 }
@@ -283,6 +288,14 @@ func (r *dateTimeTextRecord) readText(d *decoder) (string, error) {
 	return readDateTimeText(d)
 }
 
+func (r *dateTimeTextRecord) writeText(e *encoder, text string) error {
+	dt, err := ParseDateTime(text)
+	if err != nil {
+		return err
+	}
+	return writeDateTime(e, dt)
+}
+
 type chars8TextRecord struct {
 	textRecordBase
 }
@@ -328,11 +341,11 @@ func (r *bytes8TextRecord) readText(d *decoder) (string, error) {
 }
 
 func (r *bytes8TextRecord) writeText(e *encoder, text string) error {
-	byteSlice, err := b64.DecodeString(text)
+	byteSlice, err := e.decodeBase64(text)
 	if err != nil {
 		return err
 	}
-	err = binary.Write(e.bin, binary.LittleEndian, uint8(len(byteSlice)))
+	err = e.bin.WriteByte(uint8(len(byteSlice)))
 	if err != nil {
 		return err
 	}
@@ -349,11 +362,11 @@ func (r *bytes16TextRecord) readText(d *decoder) (string, error) {
 }
 
 func (r *bytes16TextRecord) writeText(e *encoder, text string) error {
-	byteSlice, err := b64.DecodeString(text)
+	byteSlice, err := e.decodeBase64(text)
 	if err != nil {
 		return err
 	}
-	err = binary.Write(e.bin, binary.LittleEndian, uint16(len(byteSlice)))
+	err = writeUint16(e, uint16(len(byteSlice)))
 	if err != nil {
 		return err
 	}
@@ -366,12 +379,12 @@ type bytes32TextRecord struct {
 }
 
 func (r *bytes32TextRecord) writeText(e *encoder, text string) error {
-	byteSlice, err := b64.DecodeString(text)
+	byteSlice, err := e.decodeBase64(text)
 	if err != nil {
 		return err
 	}
 	// PER SPEC: int32 not uint32
-	err = binary.Write(e.bin, binary.LittleEndian, int32(len(byteSlice)))
+	err = writeUint32(e, uint32(int32(len(byteSlice))))
 	if err != nil {
 		return err
 	}
@@ -392,9 +405,9 @@ func (r *startListTextRecord) readText(d *decoder) (string, error) {
 }
 
 func (r *startListTextRecord) writeText(e *encoder, text string) error {
-	texts := strings.Split(text, " ")
-	for _, t := range texts {
-		tr, err := e.getTextRecordFromText(t, false)
+	// list items are separated by any run of whitespace, as in an xsd:list
+	for _, t := range strings.Fields(text) {
+		tr, err := e.getTextRecordFromText(t, TextAuto, false)
 		if err != nil {
 			return err
 		}
@@ -455,6 +468,14 @@ func (r *timeSpanTextRecord) readText(d *decoder) (string, error) {
 	return readTimeSpanText(d)
 }
 
+func (r *timeSpanTextRecord) writeText(e *encoder, text string) error {
+	ts, err := ParseTimeSpan(text)
+	if err != nil {
+		return err
+	}
+	return writeUint64(e, uint64(ts))
+}
+
 type uuidTextRecord struct {
 	textRecordBase
 }
@@ -480,7 +501,7 @@ func (r *uInt64TextRecord) writeText(e *encoder, text string) error {
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.bin, binary.LittleEndian, uint64(i))
+	return writeUint64(e, i)
 }
 
 type boolTextRecord struct {
@@ -0,0 +1,339 @@
+package nbfx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// Decimal is a .NET System.Decimal, a 96 bit integer divided by a power of ten. Unlike a float
+// it keeps its scale, so 1.50 and 1.5 are different values, as they are in DecimalText records.
+type Decimal struct {
+	Hi32 uint32
+	Lo64 uint64
+	// Scale is the power of ten the integer is divided by, at most 28
+	Scale    byte
+	Negative bool
+}
+
+// NewDecimal returns the decimal mantissa / 10^scale
+func NewDecimal(mantissa *big.Int, scale int) (Decimal, error) {
+	if scale < 0 || scale > maxDecimalScale {
+		return Decimal{}, fmt.Errorf("Invalid decimal scale %d", scale)
+	}
+	abs := new(big.Int).Abs(mantissa)
+	if abs.BitLen() > 96 {
+		return Decimal{}, fmt.Errorf("Decimal mantissa %s is out of range", mantissa)
+	}
+	d := Decimal{Scale: byte(scale), Negative: mantissa.Sign() < 0}
+	d.setMantissa(abs)
+	return d, nil
+}
+
+// DecimalFromRat returns r as a decimal with the smallest scale that holds it exactly.
+// It fails for fractions like 1/3 that have no exact decimal.
+func DecimalFromRat(r *big.Rat) (Decimal, error) {
+	mantissa := new(big.Rat).Set(r)
+	ten := big.NewRat(10, 1)
+	for scale := 0; scale <= maxDecimalScale; scale++ {
+		if mantissa.IsInt() {
+			return NewDecimal(mantissa.Num(), scale)
+		}
+		mantissa.Mul(mantissa, ten)
+	}
+	return Decimal{}, fmt.Errorf("%s has no exact decimal", r.RatString())
+}
+
+// ParseDecimal parses text in the form XmlConvert.ToDecimal accepts, keeping its scale
+func ParseDecimal(text string) (Decimal, error) {
+	return parseDecimal(text)
+}
+
+func (d *Decimal) setMantissa(abs *big.Int) {
+	d.Lo64 = new(big.Int).And(abs, new(big.Int).SetUint64(math.MaxUint64)).Uint64()
+	d.Hi32 = uint32(new(big.Int).Rsh(abs, 64).Uint64())
+}
+
+// Mantissa returns the signed integer that d divides by 10^Scale
+func (d Decimal) Mantissa() *big.Int {
+	mantissa := new(big.Int).SetUint64(uint64(d.Hi32))
+	mantissa.Lsh(mantissa, 64)
+	mantissa.Or(mantissa, new(big.Int).SetUint64(d.Lo64))
+	if d.Negative {
+		mantissa.Neg(mantissa)
+	}
+	return mantissa
+}
+
+// Rat returns the value of d
+func (d Decimal) Rat() *big.Rat {
+	denominator := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Scale)), nil)
+	return new(big.Rat).SetFrac(d.Mantissa(), denominator)
+}
+
+// String returns d as XmlConvert writes it, such as 1.50
+func (d Decimal) String() string {
+	return formatDecimal(d)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Decimal) UnmarshalText(text []byte) error {
+	value, err := ParseDecimal(string(text))
+	if err != nil {
+		return err
+	}
+	*d = value
+	return nil
+}
+
+// DateTimeKind is the Kind of a .NET System.DateTime
+type DateTimeKind byte
+
+const (
+	// DateTimeUnspecified is a time without a time zone
+	DateTimeUnspecified DateTimeKind = iota
+	// DateTimeUTC is a time in UTC
+	DateTimeUTC
+	// DateTimeLocal is a time in the local time zone of whoever reads it
+	DateTimeLocal
+)
+
+const (
+	ticksPerSecond = 10000000
+	ticksPerMinute = 60 * ticksPerSecond
+	ticksPerHour   = 60 * ticksPerMinute
+	ticksPerDay    = 24 * ticksPerHour
+	// unixEpochTicks is 1970-01-01T00:00:00 in ticks
+	unixEpochTicks = 621355968000000000
+	// maxTicks is DateTime.MaxValue, 9999-12-31T23:59:59.9999999
+	maxTicks = 3155378975999999999
+	// dateTimeTicksMask masks the 62 bits of ticks in the binary form of a DateTime
+	dateTimeTicksMask = 1<<62 - 1
+)
+
+// DateTime is a .NET System.DateTime: a count of 100 nanosecond ticks since 0001-01-01T00:00:00 and a Kind.
+// As in DateTimeText records, the ticks of a local time count to the same instant in UTC, so that
+// readers in other time zones see the same instant.
+type DateTime struct {
+	Ticks int64
+	Kind  DateTimeKind
+}
+
+// NewDateTime returns t as a DateTime of kind. Unspecified times keep the clock time of t in its
+// location, UTC and local times keep its instant.
+func NewDateTime(t time.Time, kind DateTimeKind) DateTime {
+	if kind == DateTimeUnspecified {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+	return DateTime{Ticks: t.Unix()*ticksPerSecond + unixEpochTicks + int64(t.Nanosecond()/100), Kind: kind}
+}
+
+// ParseDateTime parses text as XmlConvert.ToDateTime does with XmlDateTimeSerializationMode.RoundtripKind
+func ParseDateTime(text string) (DateTime, error) {
+	return parseDateTime(text)
+}
+
+// Time returns dt as a time.Time: unspecified times are returned in UTC, and local times in time.Local
+func (dt DateTime) Time() time.Time {
+	ticks := dt.Ticks - unixEpochTicks
+	t := time.Unix(ticks/ticksPerSecond, ticks%ticksPerSecond*100).UTC()
+	if dt.Kind == DateTimeLocal {
+		t = t.In(time.Local)
+	}
+	return t
+}
+
+// String returns dt as XmlConvert writes it, such as 2006-05-17T00:00:00Z
+func (dt DateTime) String() string {
+	return formatDateTime(dt)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (dt DateTime) MarshalText() ([]byte, error) {
+	return []byte(dt.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (dt *DateTime) UnmarshalText(text []byte) error {
+	value, err := ParseDateTime(string(text))
+	if err != nil {
+		return err
+	}
+	*dt = value
+	return nil
+}
+
+// binary returns dt in the form of DateTime.ToBinary
+func (dt DateTime) binary() (uint64, error) {
+	if dt.Kind > DateTimeLocal {
+		return 0, fmt.Errorf("Invalid DateTimeKind %d", dt.Kind)
+	}
+	if dt.Ticks > maxTicks || dt.Ticks < 0 && (dt.Kind != DateTimeLocal || dt.Ticks < -ticksPerDay) {
+		return 0, fmt.Errorf("DateTime ticks %d are out of range", dt.Ticks)
+	}
+	return uint64(dt.Ticks)&dateTimeTicksMask | uint64(dt.Kind)<<62, nil
+}
+
+// dateTimeFromBinary returns the DateTime of the form of DateTime.ToBinary
+func dateTimeFromBinary(bin uint64) (DateTime, error) {
+	dt := DateTime{Ticks: int64(bin & dateTimeTicksMask), Kind: DateTimeKind(bin >> 62)}
+	if dt.Kind > DateTimeLocal {
+		return DateTime{}, fmt.Errorf("Unrecognized TZ %v", dt.Kind)
+	}
+	if dt.Ticks > maxTicks {
+		if dt.Kind != DateTimeLocal {
+			return DateTime{}, fmt.Errorf("DateTime ticks %d are out of range", dt.Ticks)
+		}
+		// local times just after 0001-01-01 east of UTC are before it in UTC
+		dt.Ticks -= 1 << 62
+	}
+	return dt, nil
+}
+
+// TimeSpan is a .NET System.TimeSpan in 100 nanosecond ticks. It spans ±29,000 years, where a
+// time.Duration spans ±292.
+type TimeSpan int64
+
+// NewTimeSpan returns d as a TimeSpan, dropping nanoseconds below a tick
+func NewTimeSpan(d time.Duration) TimeSpan {
+	return TimeSpan(d / 100)
+}
+
+// ParseTimeSpan parses an xsd:duration as XmlConvert.ToTimeSpan does
+func ParseTimeSpan(text string) (TimeSpan, error) {
+	return parseTimeSpan(text)
+}
+
+// Duration returns ts as a time.Duration, limited to the longest durations it can hold
+func (ts TimeSpan) Duration() time.Duration {
+	if ts > TimeSpan(math.MaxInt64/100) {
+		return math.MaxInt64
+	} else if ts < TimeSpan(math.MinInt64/100) {
+		return math.MinInt64
+	}
+	return time.Duration(ts) * 100
+}
+
+// String returns ts as XmlConvert writes it, such as PT3H20M
+func (ts TimeSpan) String() string {
+	return formatTimeSpan(ts)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (ts TimeSpan) MarshalText() ([]byte, error) {
+	return []byte(ts.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (ts *TimeSpan) UnmarshalText(text []byte) error {
+	value, err := ParseTimeSpan(string(text))
+	if err != nil {
+		return err
+	}
+	*ts = value
+	return nil
+}
+
+// Guid is a .NET System.Guid, held in RFC 4122 byte order like uuid.UUID, and written as UuidText
+type Guid [16]byte
+
+// ParseGuid parses a Guid such as 33221100-5544-7766-8899-aabbccddeeff
+func ParseGuid(text string) (Guid, error) {
+	id, err := uuid.FromString(strings.Trim(text, xmlWhitespace))
+	if err != nil {
+		return Guid{}, fmt.Errorf("Invalid Guid %q", text)
+	}
+	return Guid(id), nil
+}
+
+// GuidFromByteArray returns the Guid of the 16 bytes of .NET's Guid.ToByteArray, whose first three
+// fields are little-endian
+func GuidFromByteArray(b []byte) (Guid, error) {
+	if len(b) != 16 {
+		return Guid{}, errors.New("Guid byte array must be 16 bytes")
+	}
+	var g Guid
+	copy(g[:], b)
+	flipUuidByteOrder(g[:])
+	return g, nil
+}
+
+// ByteArray returns the bytes of g as .NET's Guid.ToByteArray returns them, the layout of UuidText
+func (g Guid) ByteArray() []byte {
+	b := make([]byte, 16)
+	copy(b, g[:])
+	flipUuidByteOrder(b)
+	return b
+}
+
+// String returns g in the lowercase 8-4-4-4-12 form
+func (g Guid) String() string {
+	return formatUuid(g)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (g Guid) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (g *Guid) UnmarshalText(text []byte) error {
+	value, err := ParseGuid(string(text))
+	if err != nil {
+		return err
+	}
+	*g = value
+	return nil
+}
+
+// UniqueID is a System.Xml.UniqueId holding a Guid, written as "urn:uuid:" and the Guid,
+// and encoded as UniqueIdText
+type UniqueID Guid
+
+// ParseUniqueID parses a UniqueID such as urn:uuid:33221100-5544-7766-8899-aabbccddeeff
+func ParseUniqueID(text string) (UniqueID, error) {
+	value := strings.Trim(text, xmlWhitespace)
+	if !strings.HasPrefix(value, urnPrefix) {
+		return UniqueID{}, fmt.Errorf("Invalid UniqueID %q", text)
+	}
+	g, err := ParseGuid(value[len(urnPrefix):])
+	if err != nil {
+		return UniqueID{}, fmt.Errorf("Invalid UniqueID %q", text)
+	}
+	return UniqueID(g), nil
+}
+
+// Guid returns the Guid of id
+func (id UniqueID) Guid() Guid {
+	return Guid(id)
+}
+
+// String returns id with its urn:uuid: prefix
+func (id UniqueID) String() string {
+	return urnPrefix + formatUuid(id)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (id UniqueID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (id *UniqueID) UnmarshalText(text []byte) error {
+	value, err := ParseUniqueID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = value
+	return nil
+}
@@ -0,0 +1,219 @@
+package nbfx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestDecimal(t *testing.T) {
+	d, err := NewDecimal(big.NewInt(-150), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, d.String(), "-1.50")
+	if d.Rat().Cmp(big.NewRat(-3, 2)) != 0 || d.Mantissa().Int64() != -150 {
+		t.Errorf("Unexpected value %v of %v", d.Rat(), d)
+	}
+
+	d, err = DecimalFromRat(big.NewRat(1, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, d.String(), "0.125")
+	if _, err = DecimalFromRat(big.NewRat(1, 3)); err == nil {
+		t.Error("Expected error for 1/3")
+	}
+	if _, err = NewDecimal(new(big.Int).Lsh(big.NewInt(1), 96), 0); err == nil {
+		t.Error("Expected error for a mantissa over 96 bits")
+	}
+	if _, err = NewDecimal(big.NewInt(1), 29); err == nil {
+		t.Error("Expected error for scale 29")
+	}
+}
+
+func TestDateTime(t *testing.T) {
+	utc := time.Date(2006, 5, 17, 1, 2, 3, 400000000, time.UTC)
+	tests := []struct {
+		value    DateTime
+		expected string
+	}{
+		{DateTime{}, "0001-01-01T00:00:00"},
+		{DateTime{Ticks: maxTicks}, "9999-12-31T23:59:59.9999999"},
+		{NewDateTime(utc, DateTimeUnspecified), "2006-05-17T01:02:03.4"},
+		{NewDateTime(utc, DateTimeUTC), "2006-05-17T01:02:03.4Z"},
+		{NewDateTime(utc.In(time.FixedZone("", 3600)), DateTimeUTC), "2006-05-17T01:02:03.4Z"},
+		{NewDateTime(utc.In(time.FixedZone("", 3600)), DateTimeUnspecified), "2006-05-17T02:02:03.4"},
+	}
+	for _, test := range tests {
+		assertStringEqual(t, test.value.String(), test.expected)
+		parsed, err := ParseDateTime(test.expected)
+		if err != nil || parsed != test.value {
+			t.Errorf("%s parsed to %v, %v", test.expected, parsed, err)
+		}
+	}
+
+	local, err := ParseDateTime("2006-05-17T01:02:03+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.Kind != DateTimeLocal || !local.Time().Equal(time.Date(2006, 5, 16, 23, 2, 3, 0, time.UTC)) || local.Time().Location() != time.Local {
+		t.Errorf("Expected a local time for the instant, got %v", local)
+	}
+	for _, text := range []string{"2006-05-17T01:02:03.12345678", "2006-05-17 01:02:03", "2006-13-01T00:00:00", "17/05/2006"} {
+		if _, err := ParseDateTime(text); err == nil {
+			t.Errorf("Expected error parsing %q", text)
+		}
+	}
+}
+
+func TestDateTimeBinary(t *testing.T) {
+	for _, dt := range []DateTime{{Ticks: maxTicks}, {Ticks: 632834208000000000, Kind: DateTimeUTC}, {Ticks: -ticksPerHour, Kind: DateTimeLocal}} {
+		bin, err := dt.binary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := dateTimeFromBinary(bin)
+		if err != nil || read != dt {
+			t.Errorf("%v read back as %v, %v", dt, read, err)
+		}
+	}
+	if _, err := (DateTime{Ticks: maxTicks + 1}).binary(); err == nil {
+		t.Error("Expected error for ticks after DateTime.MaxValue")
+	}
+	if _, err := dateTimeFromBinary(3 << 62); err == nil {
+		t.Error("Expected error for TZ 3")
+	}
+}
+
+func TestTimeSpan(t *testing.T) {
+	tests := []struct {
+		value    TimeSpan
+		expected string
+	}{
+		{0, "PT0S"},
+		{NewTimeSpan(-5*time.Minute - 44*time.Second), "-PT5M44S"},
+		{NewTimeSpan(3*time.Hour + 20*time.Minute), "PT3H20M"},
+		{NewTimeSpan(10 * time.Second), "PT10S"},
+		{NewTimeSpan(1500 * time.Millisecond), "PT1.5S"},
+		{1, "PT0.0000001S"},
+		{NewTimeSpan(26 * time.Hour), "P1DT2H"},
+		{NewTimeSpan(48 * time.Hour), "P2D"},
+		{math.MaxInt64, "P10675199DT2H48M5.4775807S"},
+		{math.MinInt64, "-P10675199DT2H48M5.4775808S"},
+	}
+	for _, test := range tests {
+		assertStringEqual(t, test.value.String(), test.expected)
+		parsed, err := ParseTimeSpan(test.expected)
+		if err != nil || parsed != test.value {
+			t.Errorf("%s parsed to %d, %v", test.expected, parsed, err)
+		}
+	}
+
+	for text, expected := range map[string]TimeSpan{"P1Y": 365 * ticksPerDay, "P1M": 30 * ticksPerDay, "PT1M": ticksPerMinute, "PT0.123456789S": 1234567} {
+		parsed, err := ParseTimeSpan(text)
+		if err != nil || parsed != expected {
+			t.Errorf("%s parsed to %d, %v", text, parsed, err)
+		}
+	}
+	for _, text := range []string{"", "P", "PT", "P1DT", "P1H", "PT1D", "P1S", "PT1M1H", "P1.5D", "P10675199DT2H48M5.4775808S", "1D"} {
+		if _, err := ParseTimeSpan(text); err == nil {
+			t.Errorf("Expected error parsing %q", text)
+		}
+	}
+	if TimeSpan(math.MaxInt64).Duration() != math.MaxInt64 || NewTimeSpan(time.Second).Duration() != time.Second {
+		t.Error("Unexpected Duration")
+	}
+}
+
+func TestGuid(t *testing.T) {
+	netBytes := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	g, err := GuidFromByteArray(netBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, g.String(), "33221100-5544-7766-8899-aabbccddeeff")
+	assertBinEqual(t, g.ByteArray(), netBytes)
+	parsed, err := ParseGuid("33221100-5544-7766-8899-AABBCCDDEEFF")
+	if err != nil || parsed != g {
+		t.Errorf("Unexpected Guid %v, %v", parsed, err)
+	}
+
+	id := UniqueID(g)
+	assertStringEqual(t, id.String(), "urn:uuid:33221100-5544-7766-8899-aabbccddeeff")
+	parsedId, err := ParseUniqueID(id.String())
+	if err != nil || parsedId != id || parsedId.Guid() != g {
+		t.Errorf("Unexpected UniqueID %v, %v", parsedId, err)
+	}
+	if _, err = ParseUniqueID(g.String()); err == nil {
+		t.Error("Expected error parsing a UniqueID without urn:uuid:")
+	}
+}
+
+type typedValues struct {
+	XMLName  xml.Name `xml:"values"`
+	Decimal  Decimal  `xml:"decimal"`
+	DateTime DateTime `xml:"dateTime"`
+	TimeSpan TimeSpan `xml:"timeSpan"`
+	Guid     Guid     `xml:"guid"`
+	UniqueID UniqueID `xml:"id,attr"`
+}
+
+// typedHint picks the record of each typed value
+func typedHint(path []xml.Name, attr xml.Name) TextKind {
+	if attr.Local == "id" {
+		return TextUniqueId
+	}
+	switch path[len(path)-1].Local {
+	case "decimal":
+		return TextDecimal
+	case "dateTime":
+		return TextDateTime
+	case "timeSpan":
+		return TextTimeSpan
+	case "guid":
+		return TextUuid
+	}
+	return TextAuto
+}
+
+func TestTypedValuesRoundTrip(t *testing.T) {
+	g, _ := ParseGuid("33221100-5544-7766-8899-aabbccddeeff")
+	values := typedValues{
+		Decimal:  Decimal{Lo64: 150, Scale: 2},
+		DateTime: NewDateTime(time.Date(2006, 5, 17, 0, 0, 0, 0, time.UTC), DateTimeUTC),
+		TimeSpan: NewTimeSpan(time.Hour),
+		Guid:     g,
+		UniqueID: UniqueID(g),
+	}
+	text, err := xml.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := NewEncoderWithOptions(nil, EncoderOptions{TypeHint: typedHint}).Encode(bytes.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []byte{decimalTextWithEndElement, dateTimeTextWithEndElement, timeSpanTextWithEndElement, uuidTextWithEndElement, uniqueIdText} {
+		if !bytes.Contains(bin, []byte{id}) {
+			t.Errorf("Expected record %#X in %x", id, bin)
+		}
+	}
+	decoded, err := NewDecoder().Decode(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := typedValues{XMLName: xml.Name{Local: "values"}}
+	values.XMLName = read.XMLName
+	err = xml.Unmarshal([]byte(decoded), &read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != values {
+		t.Errorf("%v not equal to expected %v", read, values)
+	}
+	assertStringEqual(t, decoded, string(text))
+}
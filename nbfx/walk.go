@@ -0,0 +1,380 @@
+package nbfx
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// Record is a record of an NBFX document as Walk visits it: an *Element, *Attribute, *Text,
+// *Array, *EndElement or *Comment. Element records are visited without their attributes, which
+// are the Attribute records after them, and without their content.
+type Record interface {
+	// RecordType returns the id of the record, such as 0x56 for PrefixDictionaryElementS
+	RecordType() byte
+	// RecordName returns the name of the record in [MC-NBFX], such as PrefixDictionaryElementS
+	RecordName() string
+}
+
+// EndElement is an EndElement record
+type EndElement struct{}
+
+func (el *Element) RecordType() byte     { return el.Record }
+func (attr *Attribute) RecordType() byte { return attr.Record }
+func (t *Text) RecordType() byte         { return t.Record }
+func (a *Array) RecordType() byte        { return array }
+func (*EndElement) RecordType() byte     { return endElement }
+func (*Comment) RecordType() byte        { return comment }
+
+func (el *Element) RecordName() string     { return recordName(el.Record) }
+func (attr *Attribute) RecordName() string { return recordName(attr.Record) }
+func (t *Text) RecordName() string         { return recordName(t.Record) }
+func (a *Array) RecordName() string        { return recordName(array) }
+func (*EndElement) RecordName() string     { return recordName(endElement) }
+func (*Comment) RecordName() string        { return recordName(comment) }
+
+// recordNames are the names of the records other than the text records and the A-Z records
+var recordNames = map[byte]string{
+	endElement:                    "EndElement",
+	comment:                       "Comment",
+	array:                         "Array",
+	shortAttribute:                "ShortAttribute",
+	attribute:                     "Attribute",
+	shortDictionaryAttribute:      "ShortDictionaryAttribute",
+	dictionaryAttribute:           "DictionaryAttribute",
+	shortXmlnsAttribute:           "ShortXmlnsAttribute",
+	xmlnsAttribute:                "XmlnsAttribute",
+	shortDictionaryXmlnsAttribute: "ShortDictionaryXmlnsAttribute",
+	dictionaryXmlnsAttribute:      "DictionaryXmlnsAttribute",
+	shortElement:                  "ShortElement",
+	element:                       "Element",
+	shortDictionaryElement:        "ShortDictionaryElement",
+	dictionaryElement:             "DictionaryElement",
+}
+
+func recordName(id byte) string {
+	if name, ok := recordNames[id]; ok {
+		return name
+	}
+	for _, az := range []struct {
+		a    byte
+		name string
+	}{{prefixDictionaryAttributeA, "PrefixDictionaryAttribute"}, {prefixAttributeA, "PrefixAttribute"},
+		{prefixDictionaryElementA, "PrefixDictionaryElement"}, {prefixElementA, "PrefixElement"}} {
+		if id >= az.a && id < az.a+26 {
+			return az.name + string(rune('A'+id-az.a))
+		}
+	}
+	if rec, ok := records[id]; ok && rec.isText() {
+		return rec.getName()
+	}
+	return fmt.Sprintf("Unknown record %#x", id)
+}
+
+// Walk calls fn for each record of the NBFX document in r, in the order they were written, and
+// stops at the first error fn returns. Dictionary strings are named like "str8", as the Decoder
+// names them without a dictionary.
+func Walk(r io.Reader, fn func(Record) error) error {
+	return WalkWithDictionary(r, nil, fn)
+}
+
+// WalkWithDictionary is Walk naming dictionary strings with the dictionary, which may be nil
+func WalkWithDictionary(r io.Reader, dictionary *Dictionary, fn func(Record) error) error {
+	p, err := newRecordParser(r, dictionary)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := p.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// recordParser reads the records of a document one at a time
+type recordParser struct {
+	d decoder
+	// inStart is set after an element record or its attributes, where attribute records may follow
+	inStart bool
+}
+
+func newRecordParser(r io.Reader, dictionary *Dictionary) (*recordParser, error) {
+	bin, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if dictionary == nil {
+		dictionary = NewDictionary(nil)
+	}
+	return &recordParser{d: decoder{dict: dictionary.strings, bin: newBinReader(bin)}}, nil
+}
+
+// next reads the next record. It returns io.EOF at the end of the document, and
+// io.ErrUnexpectedEOF when the document ends inside a record.
+func (p *recordParser) next() (Record, error) {
+	id, err := p.d.bin.readByte()
+	if err != nil {
+		return nil, err
+	}
+	rec, err := getRecord(id)
+	if err != nil {
+		return nil, err
+	}
+	inStart := p.inStart
+	p.inStart = false
+	switch {
+	case id == endElement:
+		return &EndElement{}, nil
+	case id == comment:
+		text, err := readString(p.d.bin)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return &Comment{text}, nil
+	case id == array:
+		return p.parseArray()
+	case rec.isAttribute():
+		if !inStart {
+			return nil, fmt.Errorf("%s record outside an element record", recordName(id))
+		}
+		p.inStart = true
+		return p.parseAttribute(id)
+	case rec.isStartElement():
+		p.inStart = true
+		return p.parseElement(id)
+	case rec.isText():
+		return p.parseText(id)
+	}
+	return nil, fmt.Errorf("Unexpected record %s", rec.getName())
+}
+
+// parseElement reads the name of the element record id
+func (p *recordParser) parseElement(id byte) (*Element, error) {
+	el := &Element{Record: id}
+	var err error
+	el.Prefix, el.Name, el.NameKey, err = p.parseName(id, shortElement, prefixDictionaryElementA, prefixElementA)
+	if err != nil {
+		return nil, err
+	}
+	return el, nil
+}
+
+// parseName reads the name of the element or attribute record id, given the ids of the short
+// record and the A records of its kind
+func (p *recordParser) parseName(id, short, dictA, letterA byte) (prefix, name string, key uint32, err error) {
+	switch {
+	case id >= letterA && id < letterA+26:
+		prefix = string(rune('a' + id - letterA))
+	case id >= dictA && id < dictA+26:
+		prefix = string(rune('a' + id - dictA))
+	case id == short+1 || id == short+3:
+		prefix, err = readString(p.d.bin)
+	}
+	if err == nil {
+		if id == short+2 || id == short+3 || id >= dictA && id < dictA+26 {
+			key, name, err = p.parseDictionaryString()
+		} else {
+			name, err = readString(p.d.bin)
+		}
+	}
+	return prefix, name, key, unexpectedEOF(err)
+}
+
+func (p *recordParser) parseDictionaryString() (uint32, string, error) {
+	key, err := readMultiByteInt31(p.d.bin)
+	if err != nil {
+		return 0, "", err
+	}
+	if s, ok := p.d.dict[key]; ok {
+		return key, s, nil
+	}
+	return key, "str" + strconv.FormatUint(uint64(key), 10), nil
+}
+
+func (p *recordParser) parseAttribute(id byte) (*Attribute, error) {
+	attr := &Attribute{Record: id}
+	var err error
+	switch id {
+	case shortXmlnsAttribute, shortDictionaryXmlnsAttribute:
+		attr.Name = "xmlns"
+	case xmlnsAttribute, dictionaryXmlnsAttribute:
+		attr.Prefix = "xmlns"
+		attr.Name, err = readString(p.d.bin)
+	default:
+		attr.Prefix, attr.Name, attr.NameKey, err = p.parseName(id, shortAttribute, prefixDictionaryAttributeA, prefixAttributeA)
+		if err != nil {
+			return nil, err
+		}
+		id, err := p.d.bin.readByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if rec, err := getRecord(id); err != nil || !rec.isText() || id&1 == 1 {
+			return nil, fmt.Errorf("Invalid attribute value record %#x", id)
+		}
+		attr.Value, err = p.parseText(id)
+		return attr, err
+	}
+	if err == nil {
+		if id == shortDictionaryXmlnsAttribute || id == dictionaryXmlnsAttribute {
+			attr.NamespaceKey, attr.Namespace, err = p.parseDictionaryString()
+		} else {
+			attr.Namespace, err = readString(p.d.bin)
+		}
+	}
+	return attr, unexpectedEOF(err)
+}
+
+// parseText reads the value of the text record id
+func (p *recordParser) parseText(id byte) (*Text, error) {
+	text := &Text{Record: id}
+	var err error
+	switch id &^ 1 {
+	case startListText:
+		var items []*Text
+		for {
+			id, err := p.d.bin.readByte()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if rec, err := getRecord(id); err != nil || !rec.isText() {
+				return nil, fmt.Errorf("Records within list must be TextRecord types, but found %#x", id)
+			}
+			item, err := p.parseText(id)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if id&^1 == endListText {
+				break
+			}
+		}
+		text.Value = items
+	case endListText:
+	case dictionaryText:
+		var s DictionaryString
+		s.Key, s.Value, err = p.parseDictionaryString()
+		text.Value = s
+	case qNameDictionaryText:
+		var q DictionaryQName
+		var b byte
+		b, err = p.d.bin.readByte()
+		if err == nil && b > 25 {
+			return nil, fmt.Errorf("Invalid QNameDictionaryText prefix %d", b)
+		}
+		q.Prefix = string(rune('a' + b))
+		if err == nil {
+			q.Name.Key, q.Name.Value, err = p.parseDictionaryString()
+		}
+		text.Value = q
+	default:
+		var v readerValue
+		v, err = readValue(&p.d, id)
+		text.Value = v.typed()
+	}
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return text, nil
+}
+
+// typed returns the value in the Go type a Text holds it in
+func (v readerValue) typed() interface{} {
+	switch v.id {
+	case zeroText, oneText, int8Text, int16Text, int32Text, int64Text:
+		return v.int
+	case falseText, trueText, boolText:
+		return v.int != 0
+	case uInt64Text:
+		return v.uint
+	case floatText:
+		return float32(v.float)
+	case doubleText:
+		return v.float
+	case decimalText:
+		return v.dec
+	case dateTimeText:
+		return v.dt
+	case timeSpanText:
+		return TimeSpan(v.int)
+	case uuidText:
+		return v.guid
+	case uniqueIdText:
+		return UniqueID(v.guid)
+	case bytes8Text, bytes16Text, bytes32Text:
+		return v.bytes
+	}
+	return v.text
+}
+
+// isArrayValueRecord reports whether .NET writes arrays of the text record id
+func isArrayValueRecord(id byte) bool {
+	switch id &^ 1 {
+	case boolText, int16Text, int32Text, int64Text, floatText, doubleText, decimalText, dateTimeText, timeSpanText, uuidText:
+		return true
+	}
+	return false
+}
+
+func (p *recordParser) parseArray() (*Array, error) {
+	id, err := p.d.bin.readByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if id < shortElement || id >= prefixElementA+26 {
+		return nil, errors.New("Element expected!")
+	}
+	el, err := p.parseElement(id)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if id, err = p.d.bin.readByte(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if id < shortAttribute || id >= shortElement {
+			break
+		}
+		attr, err := p.parseAttribute(id)
+		if err != nil {
+			return nil, err
+		}
+		el.Attributes = append(el.Attributes, attr)
+	}
+	end := id
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if end != endElement {
+		return nil, fmt.Errorf("Expected EndElement after the element of an array, found %#x", end)
+	}
+	a := &Array{Element: el}
+	a.Record, err = p.d.bin.readByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if !isArrayValueRecord(a.Record) {
+		return nil, fmt.Errorf("Invalid array value record %#x", a.Record)
+	}
+	length, err := readMultiByteInt31(p.d.bin)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	for i := uint32(0); i < length; i++ {
+		v, err := readValue(&p.d, a.Record)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		a.Values = append(a.Values, v.typed())
+	}
+	return a, nil
+}
@@ -0,0 +1,88 @@
+package nbfx
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	var names []string
+	var keys []uint32
+	err := Walk(bytes.NewReader(writeDocumentMessage(t, "http://old")), func(rec Record) error {
+		names = append(names, rec.RecordName())
+		if el, ok := rec.(*Element); ok && el.Record >= prefixDictionaryElementA && el.Record < prefixElementA {
+			keys = append(keys, el.NameKey)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, strings.Join(names, " "), "PrefixDictionaryElementS DictionaryXmlnsAttribute DictionaryXmlnsAttribute "+
+		"PrefixDictionaryElementS PrefixDictionaryElementA Chars8TextWithEndElement PrefixElementA UniqueIdTextWithEndElement EndElement "+
+		"PrefixDictionaryElementS Comment ShortElement ShortXmlnsAttribute PrefixAttributeA DoubleTextWithEndElement Array EndElement EndElement")
+	if len(keys) != 4 || keys[0] != 2 || keys[1] != 6 || keys[2] != 8 || keys[3] != 10 {
+		t.Errorf("Unexpected dictionary keys %v", keys)
+	}
+}
+
+func TestWalkNamesDictionaryStrings(t *testing.T) {
+	// the PrefixDictionaryElementS example of [MC-NBFX], <s:str8 xmlns:s="str4">
+	bin := []byte{0x56, 0x08, 0x0B, 0x01, 0x73, 0x04, 0x01}
+	var el *Element
+	var ns *Attribute
+	visit := func(rec Record) error {
+		switch rec := rec.(type) {
+		case *Element:
+			el = rec
+		case *Attribute:
+			ns = rec
+		}
+		return nil
+	}
+	if err := Walk(bytes.NewReader(bin), visit); err != nil {
+		t.Fatal(err)
+	}
+	if el.Prefix != "s" || el.Name != "str8" || el.NameKey != 8 || ns.Name != "s" || ns.Namespace != "str4" || ns.NamespaceKey != 4 {
+		t.Errorf("Unexpected records %+v %+v", el, ns)
+	}
+	dictionary := NewDictionary(map[uint32]string{4: "urn:s", 8: "Envelope"})
+	if err := WalkWithDictionary(bytes.NewReader(bin), dictionary, visit); err != nil {
+		t.Fatal(err)
+	}
+	if el.Name != "Envelope" || ns.Namespace != "urn:s" {
+		t.Errorf("Unexpected records %+v %+v", el, ns)
+	}
+}
+
+func TestWalkErrors(t *testing.T) {
+	stop := errors.New("stop")
+	count := 0
+	err := Walk(bytes.NewReader([]byte{0x40, 0x01, 0x61, 0x40, 0x01, 0x62, 0x01, 0x01}), func(Record) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("Expected Walk to stop at the first error, got %v after %d records", err, count)
+	}
+	visit := func(Record) error { return nil }
+	if Walk(bytes.NewReader([]byte{0x04, 0x01, 0x61, 0x98, 0x00}), visit) == nil {
+		t.Error("Expected error for an attribute outside an element")
+	}
+	if Walk(bytes.NewReader([]byte{0x40, 0x01, 0x61, 0x98, 0x00, 0x04, 0x01, 0x62, 0x98, 0x00}), visit) == nil {
+		t.Error("Expected error for an attribute after text")
+	}
+}
+
+func TestRecordNames(t *testing.T) {
+	for id, rec := range records {
+		name := recordName(id)
+		if strings.HasPrefix(name, "Unknown") || strings.ContainsAny(name, " ()") {
+			t.Errorf("Unexpected name %q of %s", name, rec.getName())
+		}
+	}
+	assertStringEqual(t, recordName(0x56), "PrefixDictionaryElementS")
+	assertStringEqual(t, recordName(0x3F), "PrefixAttributeZ")
+}
@@ -0,0 +1,388 @@
+package nbfx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Writer writes an NBFX document one node at a time, like .NET's XmlDictionaryWriter.
+// Each typed method emits the record of its type, so nothing is guessed from text: WriteDouble
+// always writes DoubleText and WriteString always writes characters. Integers use the smallest of
+// ZeroText, OneText and Int8/16/32/64Text, and text followed by WriteEndElement uses the
+// WithEndElement record, as .NET's XmlBinaryWriter does.
+//
+// Records are buffered until Flush.
+type Writer struct {
+	w io.Writer
+	e encoder
+	// depth is the number of open elements
+	depth int
+	// inStart is set while attributes may be written to the last start element
+	inStart bool
+	// textAt is the offset of the last record when it is a text record, which an end element
+	// turns into its WithEndElement record, or -1
+	textAt int
+	chunk  []byte
+}
+
+// NewWriter creates a Writer writing to w. The dictionary may be nil.
+func NewWriter(w io.Writer, dictionary *Dictionary) *Writer {
+	if dictionary == nil {
+		dictionary = NewDictionary(nil)
+	}
+	return &Writer{
+		w:      w,
+		e:      encoder{dict: dictionary.keys, bin: &bytes.Buffer{}, opts: EncoderOptions{StringsOnly: true}},
+		textAt: -1,
+	}
+}
+
+// Flush writes the buffered records to the underlying writer. An end element written after
+// Flush is an EndElement record even when it follows text.
+func (w *Writer) Flush() error {
+	w.textAt = -1
+	_, err := w.w.Write(w.e.bin.Bytes())
+	w.e.bin.Reset()
+	return err
+}
+
+// WriteStartElement writes the element record of prefix:local, and declares ns for prefix
+// when it is not already in scope
+func (w *Writer) WriteStartElement(prefix, local, ns string) error {
+	err := w.startElement(prefix, local)
+	if err != nil {
+		return err
+	}
+	return w.declare(prefix, ns)
+}
+
+func (w *Writer) startElement(prefix, local string) error {
+	if local == "" {
+		return errors.New("Element name must not be empty")
+	}
+	element := xml.StartElement{Name: xml.Name{Space: prefix, Local: local}}
+	rec, err := w.e.getStartElementRecordFromToken(element)
+	if err != nil {
+		return err
+	}
+	w.textAt = -1
+	err = rec.(elementRecordEncoder).encodeElement(&w.e, element)
+	if err != nil {
+		return err
+	}
+	w.e.ns.push(nil)
+	w.depth++
+	w.inStart = true
+	return nil
+}
+
+// declare writes an xmlns attribute binding prefix to ns unless that binding is already in scope
+func (w *Writer) declare(prefix, ns string) error {
+	if uri, ok := w.e.ns.lookup(prefix); ok && uri == ns || prefix == "xml" {
+		return nil
+	}
+	if prefix != "" && ns == "" {
+		return fmt.Errorf("Prefix %s must be bound to a namespace", prefix)
+	}
+	attr := xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: ns}
+	if prefix == "" {
+		attr.Name = xml.Name{Local: "xmlns"}
+	}
+	err := w.writeAttribute(attr)
+	if err != nil {
+		return err
+	}
+	w.e.ns.bindings = append(w.e.ns.bindings, nsBinding{prefix, ns})
+	return nil
+}
+
+func (w *Writer) writeAttribute(attr xml.Attr) error {
+	if !w.inStart {
+		return errors.New("Attributes must be written straight after their start element")
+	}
+	rec, err := w.e.getAttributeRecordFromToken(attr)
+	if err != nil {
+		return err
+	}
+	return rec.(attributeRecordEncoder).encodeAttribute(&w.e, attr)
+}
+
+// WriteAttribute writes an attribute of the current start element with its value as characters,
+// declaring ns for prefix when it is not already in scope. Unprefixed attributes are in no
+// namespace, whatever the default namespace is, so their ns must be empty.
+func (w *Writer) WriteAttribute(prefix, local, ns, value string) error {
+	if prefix == "" && ns != "" {
+		return fmt.Errorf("Attribute %s in namespace %q needs a prefix", local, ns)
+	}
+	err := w.writeAttribute(xml.Attr{Name: xml.Name{Space: prefix, Local: local}, Value: value})
+	if err != nil || prefix == "" {
+		return err
+	}
+	return w.declare(prefix, ns)
+}
+
+// WriteXmlnsAttribute declares ns for prefix on the current start element, unless the same
+// declaration is already in scope. An empty prefix declares the default namespace.
+func (w *Writer) WriteXmlnsAttribute(prefix, ns string) error {
+	if !w.inStart {
+		return errors.New("Attributes must be written straight after their start element")
+	}
+	return w.declare(prefix, ns)
+}
+
+// WriteEndElement closes the innermost open element
+func (w *Writer) WriteEndElement() error {
+	if w.depth == 0 {
+		return errors.New("WriteEndElement without an open element")
+	}
+	w.depth--
+	w.e.ns.pop()
+	w.inStart = false
+	if w.textAt >= 0 {
+		w.e.bin.Bytes()[w.textAt]++
+		w.textAt = -1
+		return nil
+	}
+	return w.e.bin.WriteByte(endElement)
+}
+
+// startText starts a text record with id, which may become a WithEndElement record
+func (w *Writer) startText(id byte) error {
+	if w.depth == 0 {
+		return errors.New("Text must be written inside an element")
+	}
+	w.inStart = false
+	w.textAt = w.e.bin.Len()
+	return w.e.bin.WriteByte(id)
+}
+
+// WriteString writes text as EmptyText or Chars8/16/32Text
+func (w *Writer) WriteString(text string) error {
+	id := emptyText
+	if text != "" {
+		var err error
+		id, err = getCharsTextRecordId(text)
+		if err != nil {
+			return err
+		}
+	}
+	err := w.startText(id)
+	if err != nil {
+		return err
+	}
+	return records[id].(textRecordEncoder).writeText(&w.e, text)
+}
+
+// WriteInt32 writes i with the smallest integer record that holds it
+func (w *Writer) WriteInt32(i int32) error {
+	return w.WriteInt64(int64(i))
+}
+
+// WriteInt64 writes i with the smallest integer record that holds it
+func (w *Writer) WriteInt64(i int64) error {
+	switch i {
+	case 0:
+		return w.startText(zeroText)
+	case 1:
+		return w.startText(oneText)
+	}
+	id := getIntTextRecordId(i)
+	err := w.startText(id)
+	if err != nil {
+		return err
+	}
+	switch id {
+	case int8Text:
+		return w.e.bin.WriteByte(byte(i))
+	case int16Text:
+		return writeUint16(&w.e, uint16(i))
+	case int32Text:
+		return writeUint32(&w.e, uint32(i))
+	}
+	return writeUint64(&w.e, uint64(i))
+}
+
+// WriteBool writes b as TrueText or FalseText
+func (w *Writer) WriteBool(b bool) error {
+	if b {
+		return w.startText(trueText)
+	}
+	return w.startText(falseText)
+}
+
+// WriteDouble writes f as DoubleText
+func (w *Writer) WriteDouble(f float64) error {
+	err := w.startText(doubleText)
+	if err != nil {
+		return err
+	}
+	return writeUint64(&w.e, math.Float64bits(f))
+}
+
+// WriteDecimal writes d as DecimalText
+func (w *Writer) WriteDecimal(d Decimal) error {
+	err := w.startText(decimalText)
+	if err != nil {
+		return err
+	}
+	return writeDecimal(&w.e, d)
+}
+
+// WriteDateTime writes dt as DateTimeText
+func (w *Writer) WriteDateTime(dt DateTime) error {
+	err := w.startText(dateTimeText)
+	if err != nil {
+		return err
+	}
+	return writeDateTime(&w.e, dt)
+}
+
+// WriteTimeSpan writes ts as TimeSpanText
+func (w *Writer) WriteTimeSpan(ts TimeSpan) error {
+	err := w.startText(timeSpanText)
+	if err != nil {
+		return err
+	}
+	return writeUint64(&w.e, uint64(ts))
+}
+
+// WriteGuid writes g as UuidText
+func (w *Writer) WriteGuid(g Guid) error {
+	err := w.startText(uuidText)
+	if err != nil {
+		return err
+	}
+	return writeGuid(&w.e, g)
+}
+
+// WriteUniqueID writes id as UniqueIdText
+func (w *Writer) WriteUniqueID(id UniqueID) error {
+	err := w.startText(uniqueIdText)
+	if err != nil {
+		return err
+	}
+	return writeGuid(&w.e, Guid(id))
+}
+
+// WriteBase64 writes b as Bytes8/16/32Text by its length
+func (w *Writer) WriteBase64(b []byte) error {
+	var err error
+	switch {
+	case len(b) <= math.MaxUint8:
+		if err = w.startText(bytes8Text); err == nil {
+			err = w.e.bin.WriteByte(byte(len(b)))
+		}
+	case len(b) <= math.MaxUint16:
+		if err = w.startText(bytes16Text); err == nil {
+			err = writeUint16(&w.e, uint16(len(b)))
+		}
+	case len(b) <= math.MaxInt32:
+		if err = w.startText(bytes32Text); err == nil {
+			err = writeUint32(&w.e, uint32(len(b)))
+		}
+	default:
+		return fmt.Errorf("%d bytes are too long for Bytes32Text", len(b))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.e.bin.Write(b)
+	return err
+}
+
+// WriteBase64From writes the bytes read from r up to io.EOF as Bytes text records of at most 64KB.
+// The records before each chunk are written to the underlying writer as it goes, so content of any
+// size is written in constant memory.
+func (w *Writer) WriteBase64From(r io.Reader) (int64, error) {
+	if w.chunk == nil {
+		w.chunk = make([]byte, base64ChunkSize)
+	}
+	var n int64
+	for {
+		read, err := io.ReadFull(r, w.chunk)
+		if read > 0 {
+			if err := w.WriteBase64(w.chunk[:read]); err != nil {
+				return n, err
+			}
+			n += int64(read)
+			if err := w.flushRecords(); err != nil {
+				return n, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
+// flushRecords writes the buffered records to the underlying writer, holding back the last
+// record when it is text that an end element may still change
+func (w *Writer) flushRecords() error {
+	end := w.e.bin.Len()
+	if w.textAt >= 0 {
+		end = w.textAt
+	}
+	_, err := w.w.Write(w.e.bin.Next(end))
+	if w.textAt >= 0 {
+		w.textAt = 0
+	}
+	return err
+}
+
+// WriteDictionaryString writes s as DictionaryText. It fails when s is not in the dictionary.
+func (w *Writer) WriteDictionaryString(s string) error {
+	if !w.e.isDictionaryString(s) {
+		return fmt.Errorf("%q is not in the dictionary", s)
+	}
+	err := w.startText(dictionaryText)
+	if err != nil {
+		return err
+	}
+	return writeDictionaryString(&w.e, s)
+}
+
+// WriteComment writes a comment record
+func (w *Writer) WriteComment(text string) error {
+	w.inStart = false
+	w.textAt = -1
+	return records[comment].(textRecordEncoder).encodeText(&w.e, nil, text)
+}
+
+// WriteArray writes values as an Array record of Int32TextWithEndElement values, the form
+// .NET reads as one prefix:local element in namespace ns for each value
+func (w *Writer) WriteArray(prefix, local, ns string, values []int32) error {
+	w.textAt = -1
+	err := w.e.bin.WriteByte(array)
+	if err != nil {
+		return err
+	}
+	err = w.WriteStartElement(prefix, local, ns)
+	if err != nil {
+		return err
+	}
+	w.depth--
+	w.e.ns.pop()
+	w.inStart = false
+	err = w.e.bin.WriteByte(endElement)
+	if err != nil {
+		return err
+	}
+	err = w.e.bin.WriteByte(int32TextWithEndElement)
+	if err != nil {
+		return err
+	}
+	_, err = writeMultiByteInt31(&w.e, uint32(len(values)))
+	for _, i := range values {
+		if err != nil {
+			break
+		}
+		err = writeUint32(&w.e, uint32(i))
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package nbfx
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriterRecords(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, nil)
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(w.WriteStartElement("", "doc", ""))
+	check(w.WriteAttribute("", "n", "", "1"))
+	check(w.WriteStartElement("", "a", ""))
+	check(w.WriteInt32(2))
+	check(w.WriteEndElement())
+	check(w.WriteStartElement("", "b", ""))
+	check(w.WriteDouble(1))
+	check(w.WriteEndElement())
+	check(w.WriteStartElement("", "c", ""))
+	check(w.WriteEndElement())
+	check(w.WriteEndElement())
+	check(w.Flush())
+	assertBinEqual(t, buf.Bytes(), []byte{
+		0x40, 0x03, 0x64, 0x6F, 0x63, 0x04, 0x01, 0x6E, 0x98, 0x01, 0x31,
+		0x40, 0x01, 0x61, 0x89, 0x02,
+		0x40, 0x01, 0x62, 0x93, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xF0, 0x3F,
+		0x40, 0x01, 0x63, 0x01,
+		0x01})
+}
+
+func TestWriterNamespaces(t *testing.T) {
+	dictionary := NewDictionary(map[uint32]string{2: "urn:d", 4: "Body"})
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, dictionary)
+	for _, err := range []error{
+		w.WriteStartElement("s", "Envelope", "urn:d"),
+		w.WriteXmlnsAttribute("x", "urn:x"),
+		w.WriteStartElement("s", "Body", "urn:d"),
+		w.WriteAttribute("x", "id", "urn:x", "1"),
+		w.WriteDictionaryString("Body"),
+		w.WriteEndElement(),
+		w.WriteStartElement("", "c", "urn:c"),
+		w.WriteEndElement(),
+		w.WriteEndElement(),
+		w.Flush(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bytes.Contains(buf.Bytes(), []byte("urn:d")) || bytes.Contains(buf.Bytes(), []byte("Body")) {
+		t.Errorf("Expected dictionary records in %x", buf.Bytes())
+	}
+	decoded, err := NewDecoderWithDictionary(dictionary).Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, decoded, `<s:Envelope xmlns:s="urn:d" xmlns:x="urn:x"><s:Body x:id="1">Body</s:Body><c xmlns="urn:c"></c></s:Envelope>`)
+}
+
+func TestWriterUnprefixedAttributeInDefaultNamespace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, nil)
+	for _, err := range []error{
+		w.WriteStartElement("", "a", "urn:x"),
+		w.WriteAttribute("", "id", "", "1"),
+		w.WriteEndElement(),
+		w.Flush(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	decoded, err := NewDecoder().Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStringEqual(t, decoded, `<a xmlns="urn:x" id="1"></a>`)
+
+	w = NewWriter(&bytes.Buffer{}, nil)
+	w.WriteStartElement("", "a", "urn:x")
+	if w.WriteAttribute("", "id", "urn:x", "1") == nil {
+		t.Error("Expected error for an unprefixed attribute in a namespace")
+	}
+}
+
+func TestWriterTypedRoundTrip(t *testing.T) {
+	g, _ := ParseGuid("33221100-5544-7766-8899-aabbccddeeff")
+	dec := Decimal{Lo64: 150, Scale: 2, Negative: true}
+	dt := NewDateTime(time.Date(2006, 5, 17, 0, 0, 0, 0, time.UTC), DateTimeUTC)
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, nil)
+	element := func(local string, write func() error) {
+		t.Helper()
+		if err := w.WriteStartElement("", local, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteEndElement(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.WriteStartElement("", "r", "")
+	element("i", func() error { return w.WriteInt64(-1 << 40) })
+	element("d", func() error { return w.WriteDouble(0.1) })
+	element("dec", func() error { return w.WriteDecimal(dec) })
+	element("dt", func() error { return w.WriteDateTime(dt) })
+	element("g", func() error { return w.WriteGuid(g) })
+	element("b", func() error { return w.WriteBase64(make([]byte, 300)) })
+	element("t", func() error { return w.WriteBool(true) })
+	w.WriteArray("", "arr", "", []int32{1, -1})
+	w.WriteEndElement()
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []byte{int64TextWithEndElement, doubleTextWithEndElement, decimalTextWithEndElement, dateTimeTextWithEndElement,
+		uuidTextWithEndElement, bytes16TextWithEndElement, trueTextWithEndElement} {
+		if !bytes.Contains(buf.Bytes(), []byte{id}) {
+			t.Errorf("Expected record %#X in %x", id, buf.Bytes())
+		}
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte{0x03, 0x40, 0x03, 0x61, 0x72, 0x72, 0x01, 0x8D, 0x02, 0x01, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}) {
+		t.Errorf("Unexpected array records in %x", buf.Bytes())
+	}
+
+	r := NewReader(buf, nil)
+	if err := r.ReadStartElement("r", ""); err != nil {
+		t.Fatal(err)
+	}
+	i, _ := r.ReadElementContentAsInt64("i", "")
+	assertEqual(t, i, int64(-1<<40))
+	d, _ := r.ReadElementContentAsDouble("d", "")
+	assertEqual(t, d, 0.1)
+	readDec, _ := r.ReadElementContentAsDecimal("dec", "")
+	assertEqual(t, readDec, dec)
+	readDt, _ := r.ReadElementContentAsDateTime("dt", "")
+	assertEqual(t, readDt, dt)
+	readG, _ := r.ReadElementContentAsGuid("g", "")
+	assertEqual(t, readG, g)
+	b, _ := r.ReadElementContentAsBase64("b", "")
+	assertEqual(t, len(b), 300)
+	bl, _ := r.ReadElementContentAsBool("t", "")
+	assertEqual(t, bl, true)
+	for _, expected := range []int32{1, -1} {
+		a, err := r.ReadElementContentAsInt32("arr", "")
+		if err != nil || a != expected {
+			t.Errorf("Expected %d, got %d, %v", expected, a, err)
+		}
+	}
+	if err := r.ReadEndElement(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, nil)
+	if w.WriteInt32(1) == nil {
+		t.Error("Expected error writing text outside an element")
+	}
+	if w.WriteEndElement() == nil {
+		t.Error("Expected error for an end element without an open element")
+	}
+	w.WriteStartElement("", "a", "")
+	w.WriteString("text")
+	if w.WriteAttribute("", "n", "", "v") == nil {
+		t.Error("Expected error writing an attribute after content")
+	}
+	if w.WriteDictionaryString("text") == nil {
+		t.Error("Expected error for a string missing from the dictionary")
+	}
+	if w.WriteStartElement("p", "b", "") == nil {
+		t.Error("Expected error for a prefix without a namespace")
+	}
+}
+
+func TestWriterStreamsBase64(t *testing.T) {
+	payload := make([]byte, 1<<20)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	out := &bytes.Buffer{}
+	w := NewWriter(out, nil)
+	w.WriteStartElement("", "data", "")
+	held := 0
+	for i := 0; i < 4; i++ {
+		n, err := w.WriteBase64From(bytes.NewReader(payload))
+		if err != nil || n != int64(len(payload)) {
+			t.Fatalf("Wrote %d bytes, %v", n, err)
+		}
+		if i > 0 && w.e.bin.Cap() != held {
+			t.Errorf("Expected the Writer's buffer to stay at %d bytes, grew to %d", held, w.e.bin.Cap())
+		}
+		held = w.e.bin.Cap()
+	}
+	w.WriteEndElement()
+	err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := bytes.Count(out.Bytes(), []byte{bytes16Text, 0xFF, 0xFF}); records != 64 {
+		t.Errorf("Expected 64 full Bytes16Text records, found %d", records)
+	}
+	if !bytes.Contains(out.Bytes(), []byte{bytes8TextWithEndElement, 16}) {
+		t.Error("Expected the last chunk of 16 bytes to end the element")
+	}
+
+	r := NewReader(out, nil)
+	copied := &bytes.Buffer{}
+	n, err := r.ReadElementContentAsBase64To("data", "", copied)
+	if err != nil || n != 4*int64(len(payload)) {
+		t.Fatalf("Read %d bytes, %v", n, err)
+	}
+	if !bytes.Equal(copied.Bytes(), bytes.Repeat(payload, 4)) {
+		t.Error("Streamed bytes differ from the payload")
+	}
+	if cap(r.d.bin.buf) > 2*base64ChunkSize {
+		t.Errorf("Expected the Reader to hold one chunk, holds %d bytes", cap(r.d.bin.buf))
+	}
+}
@@ -0,0 +1,358 @@
+package nbfx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed values are written the way XmlConvert.ToString writes them on .NET Core, so that decoded
+// text matches what a WCF service reads, and parsed the way XmlConvert.ToSingle, ToDouble,
+// ToDecimal, ToDateTime and ToTimeSpan parse them.
+
+const (
+	// singlePrecision and doublePrecision are the number of integer digits .NET's "R" format writes
+	// before switching to exponent notation, unless the shortest round trip digits are more
+	singlePrecision = 7
+	doublePrecision = 15
+	// maxDecimalScale is the largest scale of a .NET decimal
+	maxDecimalScale = 28
+)
+
+// xmlWhitespace is the whitespace XmlConvert trims from values
+const xmlWhitespace = " \t\n\r"
+
+// FormatFloat returns the shortest text that parses back to f, as .NET's "R" format writes it,
+// with INF and -INF for infinity
+func FormatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "INF"
+	case math.IsInf(f, -1):
+		return "-INF"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	s := strconv.FormatFloat(f, 'e', -1, bitSize)
+	sign := ""
+	if s[0] == '-' {
+		sign, s = "-", s[1:]
+	}
+	e := strings.IndexByte(s, 'e')
+	exp, _ := strconv.Atoi(s[e+1:])
+	digits := strings.Replace(s[:e], ".", "", 1)
+	// point is the number of digits before the decimal point
+	point := exp + 1
+
+	precision := doublePrecision
+	if bitSize == 32 {
+		precision = singlePrecision
+	}
+	if len(digits) > precision {
+		precision = len(digits)
+	}
+	switch {
+	case point > precision || point < -3:
+		mantissa := digits[:1]
+		if len(digits) > 1 {
+			mantissa += "." + digits[1:]
+		}
+		expSign := "+"
+		if exp < 0 {
+			expSign, exp = "-", -exp
+		}
+		return fmt.Sprintf("%s%sE%s%02d", sign, mantissa, expSign, exp)
+	case point <= 0:
+		return sign + "0." + strings.Repeat("0", -point) + digits
+	case point >= len(digits):
+		return sign + digits + strings.Repeat("0", point-len(digits))
+	}
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// ParseFloat parses text in the forms XmlConvert accepts: an optionally signed decimal number with
+// an optional exponent, INF, -INF or NaN. Values too large for bitSize parse as infinity, as they do on .NET Core.
+func ParseFloat(text string, bitSize int) (float64, error) {
+	text = strings.Trim(text, xmlWhitespace)
+	switch text {
+	case "INF":
+		return math.Inf(1), nil
+	case "-INF":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	if !isDecimalNumber(text, true) {
+		return 0, fmt.Errorf("Invalid float %q", text)
+	}
+	f, err := strconv.ParseFloat(text, bitSize)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, err
+	}
+	return f, nil
+}
+
+// isCanonicalFloat reports whether text is a float of bitSize written exactly as FormatFloat writes it,
+// so that encoding it as FloatText or DoubleText loses nothing
+func isCanonicalFloat(text string, bitSize int) bool {
+	f, err := ParseFloat(text, bitSize)
+	return err == nil && FormatFloat(f, bitSize) == text
+}
+
+// isDecimalNumber reports whether text is an optional sign, digits with an optional decimal point,
+// and, if exponent is set, an optional exponent
+func isDecimalNumber(text string, exponent bool) bool {
+	i := 0
+	if i < len(text) && (text[i] == '+' || text[i] == '-') {
+		i++
+	}
+	digits, point := 0, false
+	for ; i < len(text); i++ {
+		c := text[i]
+		if '0' <= c && c <= '9' {
+			digits++
+		} else if c == '.' && !point {
+			point = true
+		} else {
+			break
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	if i < len(text) && exponent && (text[i] == 'e' || text[i] == 'E') {
+		i++
+		if i < len(text) && (text[i] == '+' || text[i] == '-') {
+			i++
+		}
+		start := i
+		for i < len(text) && '0' <= text[i] && text[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	return i == len(text)
+}
+
+// formatDecimal writes d as .NET does, keeping the trailing zeros of its scale. Zero is never negative.
+func formatDecimal(d Decimal) string {
+	mantissa := new(big.Int).SetUint64(uint64(d.Hi32))
+	mantissa.Lsh(mantissa, 64)
+	mantissa.Or(mantissa, new(big.Int).SetUint64(d.Lo64))
+	digits := mantissa.String()
+	if n := int(d.Scale) + 1; len(digits) < n {
+		digits = strings.Repeat("0", n-len(digits)) + digits
+	}
+	if d.Scale > 0 {
+		point := len(digits) - int(d.Scale)
+		digits = digits[:point] + "." + digits[point:]
+	}
+	if d.Negative && mantissa.Sign() != 0 {
+		digits = "-" + digits
+	}
+	return digits
+}
+
+// parseDecimal parses text in the form XmlConvert.ToDecimal accepts, an optionally signed number without
+// an exponent, keeping its scale
+func parseDecimal(text string) (Decimal, error) {
+	value := strings.Trim(text, xmlWhitespace)
+	if !isDecimalNumber(value, false) {
+		return Decimal{}, fmt.Errorf("Invalid decimal %q", text)
+	}
+	var d Decimal
+	digits := value
+	if digits[0] == '+' || digits[0] == '-' {
+		d.Negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+	if point := strings.IndexByte(digits, '.'); point >= 0 {
+		if len(digits)-point-1 > maxDecimalScale {
+			return Decimal{}, fmt.Errorf("Decimal %q has more than %d decimal places", value, maxDecimalScale)
+		}
+		d.Scale = byte(len(digits) - point - 1)
+		digits = digits[:point] + digits[point+1:]
+	}
+	mantissa, _ := new(big.Int).SetString(digits, 10)
+	if mantissa.BitLen() > 96 {
+		return Decimal{}, fmt.Errorf("Decimal %q is out of range", value)
+	}
+	d.setMantissa(mantissa)
+	return d, nil
+}
+
+// formatDateTime writes dt in the round trip form, with seven fractional digits at most and none when
+// they are zero, followed by Z for UTC and the offset of the local time zone for local times
+func formatDateTime(dt DateTime) string {
+	t := dt.Time()
+	text := t.Format("2006-01-02T15:04:05.9999999")
+	switch dt.Kind {
+	case DateTimeUTC:
+		return text + "Z"
+	case DateTimeLocal:
+		return text + t.Format("-07:00")
+	}
+	return text
+}
+
+// parseDateTime parses a date or a date and time, with up to seven fractional digits. Times ending
+// in Z are UTC, times with an offset are converted to local times and others are unspecified.
+func parseDateTime(text string) (DateTime, error) {
+	value := strings.Trim(text, xmlWhitespace)
+	layout := "2006-01-02"
+	if strings.IndexByte(value, 'T') >= 0 {
+		layout += "T15:04:05"
+	}
+	kind := DateTimeUnspecified
+	if strings.HasSuffix(value, "Z") {
+		kind = DateTimeUTC
+		layout += "Z07:00"
+	} else if n := len(value); n > len(layout) && (value[n-6] == '+' || value[n-6] == '-') && value[n-3] == ':' {
+		kind = DateTimeLocal
+		layout += "Z07:00"
+	}
+	if point := strings.IndexByte(value, '.'); point >= 0 {
+		digits := point + 1
+		for digits < len(value) && '0' <= value[digits] && value[digits] <= '9' {
+			digits++
+		}
+		if digits-point-1 > 7 {
+			return DateTime{}, fmt.Errorf("DateTime %q has more than 7 fractional digits", text)
+		}
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return DateTime{}, fmt.Errorf("Invalid DateTime %q", text)
+	}
+	return NewDateTime(t, kind), nil
+}
+
+// formatTimeSpan writes ts as an xsd:duration in days, hours, minutes and seconds, as XmlConvert does
+func formatTimeSpan(ts TimeSpan) string {
+	var b strings.Builder
+	ticks := uint64(ts)
+	if ts < 0 {
+		b.WriteByte('-')
+		ticks = -ticks
+	}
+	b.WriteByte('P')
+	days := ticks / ticksPerDay
+	ticks %= ticksPerDay
+	if days > 0 {
+		b.WriteString(strconv.FormatUint(days, 10) + "D")
+	}
+	if ticks > 0 {
+		b.WriteByte('T')
+		hours, minutes := ticks/ticksPerHour, ticks/ticksPerMinute%60
+		seconds, fraction := ticks/ticksPerSecond%60, ticks%ticksPerSecond
+		if hours > 0 {
+			b.WriteString(strconv.FormatUint(hours, 10) + "H")
+		}
+		if minutes > 0 {
+			b.WriteString(strconv.FormatUint(minutes, 10) + "M")
+		}
+		if seconds > 0 || fraction > 0 {
+			b.WriteString(strconv.FormatUint(seconds, 10))
+			if fraction > 0 {
+				b.WriteString(strings.TrimRight(fmt.Sprintf(".%07d", fraction), "0"))
+			}
+			b.WriteByte('S')
+		}
+	} else if days == 0 {
+		b.WriteString("T0S")
+	}
+	return b.String()
+}
+
+// parseTimeSpan parses an xsd:duration, counting years as 365 days and months as 30 days like XmlConvert.
+// Digits beyond the seven fractional digits a TimeSpan holds are dropped.
+func parseTimeSpan(text string) (TimeSpan, error) {
+	value := strings.Trim(text, xmlWhitespace)
+	invalid := fmt.Errorf("Invalid TimeSpan %q", text)
+	s := value
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) == 1 || strings.HasSuffix(s, "T") {
+		return 0, invalid
+	}
+	s = s[1:]
+	units := []struct {
+		designator byte
+		ticks      uint64
+		inTime     bool
+	}{
+		{'Y', 365 * ticksPerDay, false},
+		{'M', 30 * ticksPerDay, false},
+		{'D', ticksPerDay, false},
+		{'H', ticksPerHour, true},
+		{'M', ticksPerMinute, true},
+		{'S', ticksPerSecond, true},
+	}
+	limit := uint64(math.MaxInt64)
+	if negative {
+		limit++
+	}
+	var total uint64
+	inTime := false
+	next := 0
+	for len(s) > 0 {
+		if s[0] == 'T' {
+			if inTime {
+				return 0, invalid
+			}
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		end := 0
+		for end < len(s) && ('0' <= s[end] && s[end] <= '9' || s[end] == '.') {
+			end++
+		}
+		if end == 0 || end == len(s) {
+			return 0, invalid
+		}
+		for next < len(units) && (units[next].designator != s[end] || units[next].inTime != inTime) {
+			next++
+		}
+		if next == len(units) {
+			return 0, invalid
+		}
+		number, fraction := s[:end], ""
+		if point := strings.IndexByte(number, '.'); point >= 0 {
+			if units[next].designator != 'S' || point == 0 || point == len(number)-1 {
+				return 0, invalid
+			}
+			number, fraction = number[:point], number[point+1:]
+		}
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil || n > limit/units[next].ticks {
+			return 0, invalid
+		}
+		total += n * units[next].ticks
+		if fraction != "" {
+			fraction = (fraction + "000000")[:7]
+			f, err := strconv.ParseUint(fraction, 10, 64)
+			if err != nil {
+				return 0, invalid
+			}
+			total += f
+		}
+		if total > limit {
+			return 0, invalid
+		}
+		next++
+		s = s[end+1:]
+	}
+	if negative {
+		return TimeSpan(-int64(total)), nil
+	}
+	return TimeSpan(total), nil
+}
@@ -0,0 +1,165 @@
+package nbfx
+
+import (
+	"math"
+	"testing"
+)
+
+// The expected text is what XmlConvert.ToString writes on .NET Core for the same value
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		value    float64
+		bitSize  int
+		expected string
+	}{
+		{0, 64, "0"},
+		{math.Copysign(0, -1), 64, "-0"},
+		{1, 64, "1"},
+		{-60, 64, "-60"},
+		{0.1, 64, "0.1"},
+		{-1.5, 64, "-1.5"},
+		{math.Pi, 64, "3.141592653589793"},
+		{2.71828182845905, 64, "2.71828182845905"},
+		{1e6, 64, "1000000"},
+		{1e14, 64, "100000000000000"},
+		{1e15, 64, "1E+15"},
+		{1e16, 64, "1E+16"},
+		{1e17, 64, "1E+17"},
+		{123456789012345678, 64, "1.2345678901234568E+17"},
+		{1e100, 64, "1E+100"},
+		{0.0001, 64, "0.0001"},
+		{0.00001, 64, "1E-05"},
+		{-1.5e-7, 64, "-1.5E-07"},
+		{math.MaxFloat64, 64, "1.7976931348623157E+308"},
+		{math.SmallestNonzeroFloat64, 64, "5E-324"},
+		{math.Inf(1), 64, "INF"},
+		{math.Inf(-1), 64, "-INF"},
+		{math.NaN(), 64, "NaN"},
+
+		{0, 32, "0"},
+		{math.Copysign(0, -1), 32, "-0"},
+		{float64(float32(1.1)), 32, "1.1"},
+		{float64(float32(32.45)), 32, "32.45"},
+		{float64(float32(0.1)), 32, "0.1"},
+		{1e6, 32, "1000000"},
+		{1e7, 32, "1E+07"},
+		{1e8, 32, "1E+08"},
+		{1e9, 32, "1E+09"},
+		{16777216, 32, "16777216"},
+		{float64(float32(1e-5)), 32, "1E-05"},
+		{math.MaxFloat32, 32, "3.4028235E+38"},
+		{math.SmallestNonzeroFloat32, 32, "1E-45"},
+		{math.Inf(1), 32, "INF"},
+		{math.NaN(), 32, "NaN"},
+	}
+	for _, test := range tests {
+		actual := FormatFloat(test.value, test.bitSize)
+		if actual != test.expected {
+			t.Errorf("%v as float%d: %s not equal to expected %s", test.value, test.bitSize, actual, test.expected)
+		}
+	}
+}
+
+func TestParseFloat(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected float64
+	}{
+		{"1", 1},
+		{"+1.5", 1.5},
+		{"-1.5E-07", -1.5e-7},
+		{"1e3", 1000},
+		{"1.", 1},
+		{".5", 0.5},
+		{" 2 ", 2},
+		{"INF", math.Inf(1)},
+		{"-INF", math.Inf(-1)},
+		{"1E+400", math.Inf(1)},
+	}
+	for _, test := range tests {
+		actual, err := ParseFloat(test.text, 64)
+		if err != nil || actual != test.expected {
+			t.Errorf("%q: %v, %v not equal to expected %v", test.text, actual, err, test.expected)
+		}
+	}
+	if f, err := ParseFloat("NaN", 64); err != nil || !math.IsNaN(f) {
+		t.Errorf("Expected NaN, got %v, %v", f, err)
+	}
+	if f, err := ParseFloat("-0", 64); err != nil || !math.Signbit(f) {
+		t.Errorf("Expected -0, got %v, %v", f, err)
+	}
+	// forms strconv.ParseFloat accepts but XmlConvert does not
+	for _, text := range []string{"", "inf", "Infinity", "+INF", "nan", "0x1p-2", "1_000", "1e", "1e+", ".", "-", "1..2"} {
+		if _, err := ParseFloat(text, 64); err == nil {
+			t.Errorf("Expected error parsing %q", text)
+		}
+	}
+}
+
+func TestIsCanonicalFloat(t *testing.T) {
+	for _, text := range []string{"1.1", "-0", "INF", "NaN", "1E+17"} {
+		if !isCanonicalFloat(text, 64) {
+			t.Errorf("Expected %s to be a canonical double", text)
+		}
+	}
+	for _, text := range []string{"1.10", "+1", "1e17", "1E17", "01", "0.0", "1.0E+17"} {
+		if isCanonicalFloat(text, 64) {
+			t.Errorf("Expected %s not to be a canonical double", text)
+		}
+	}
+	if isCanonicalFloat("3.141592653589793", 32) {
+		t.Error("Expected pi not to be a canonical float")
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		value    Decimal
+		expected string
+	}{
+		{Decimal{}, "0"},
+		{Decimal{Scale: 2, Negative: true}, "0.00"},
+		{Decimal{Lo64: 5123456, Scale: 6}, "5.123456"},
+		{Decimal{Lo64: 5123456, Scale: 6, Negative: true}, "-5.123456"},
+		{Decimal{Lo64: 1, Scale: 3}, "0.001"},
+		{Decimal{Lo64: 1, Scale: 28, Negative: true}, "-0.0000000000000000000000000001"},
+		{Decimal{Lo64: 100, Scale: 2}, "1.00"},
+		{Decimal{Hi32: 0xFFFFFFFF, Lo64: 0xFFFFFFFFFFFFFFFF}, "79228162514264337593543950335"},
+		{Decimal{Hi32: 0xFFFFFFFF, Lo64: 0xFFFFFFFFFFFFFFFF, Scale: 28}, "7.9228162514264337593543950335"},
+	}
+	for _, test := range tests {
+		actual := formatDecimal(test.value)
+		if actual != test.expected {
+			t.Errorf("%s not equal to expected %s", actual, test.expected)
+		}
+		expected := test.value
+		expected.Negative = expected.Negative && test.expected[0] == '-'
+		parsed, err := parseDecimal(test.expected)
+		if err != nil || parsed != expected {
+			t.Errorf("%s parsed to %v, %v", test.expected, parsed, err)
+		}
+	}
+}
+
+func TestParseDecimalInvalid(t *testing.T) {
+	for _, text := range []string{"", "1E+2", "INF", "NaN", "0.00000000000000000000000000001", "79228162514264337593543950336", "1.2.3"} {
+		if _, err := parseDecimal(text); err == nil {
+			t.Errorf("Expected error parsing %q", text)
+		}
+	}
+}
+
+func TestDecodeSmallDecimalText(t *testing.T) {
+	testDecode(t,
+		[]byte{0x40, 0x01, 0x61, 0x95, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		"<a>0.001</a>")
+}
+
+func TestEncodeNonCanonicalFloatAsChars(t *testing.T) {
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x99, 0x04, 0x31, 0x2E, 0x31, 0x30},
+		"<a>1.10</a>")
+	testEncode(t,
+		[]byte{0x40, 0x01, 0x61, 0x99, 0x03, 0x69, 0x6E, 0x66},
+		"<a>inf</a>")
+}